@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/pkg/profile"
+	"github.com/spf13/cobra"
+	"github.com/will-rowe/baby-groot/src/misc"
+	"github.com/will-rowe/baby-groot/src/pipeline"
+	"github.com/will-rowe/baby-groot/src/version"
+)
+
+// the command line arguments
+var (
+	fastaFile         *string  // the multi-FASTA file of reference genes
+	clusterIdentity   *float64 // the sequence identity threshold used to cluster the reference genes
+	clusterKmerSize   *int     // size of k-mer used to find candidate sequences when clustering
+	clusterSketchSize *int     // size of MinHash sketch used to find candidate sequences when clustering
+	maxCandidates     *int     // the number of candidate clusters to align each sequence to
+)
+
+// the build-db command (used by cobra)
+var buildDBCmd = &cobra.Command{
+	Use:   "build-db",
+	Short: "Cluster a set of reference sequences, build variation graphs from the clusters and then index them",
+	Long:  `Cluster a set of reference sequences, build variation graphs from the clusters and then index them (no MSAs or external tools needed)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runBuildDB()
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return misc.CheckRequiredFlags(cmd.Flags())
+	},
+}
+
+// a function to initialise the command line arguments
+func init() {
+	fastaFile = buildDBCmd.Flags().StringP("fasta", "f", "", "multi-FASTA file of reference sequences (can be gzipped) - required")
+	clusterIdentity = buildDBCmd.Flags().Float64("identity", 0.9, "sequence identity threshold used to cluster the reference sequences")
+	clusterKmerSize = buildDBCmd.Flags().Int("clusterKmerSize", 15, "size of k-mer used to find candidate sequences when clustering")
+	clusterSketchSize = buildDBCmd.Flags().Int("clusterSketchSize", 128, "size of MinHash sketch used to find candidate sequences when clustering")
+	maxCandidates = buildDBCmd.Flags().Int("maxCandidates", 10, "maximum number of candidate clusters to align each sequence to")
+	buildDBCmd.Flags().AddFlagSet(indexParams)
+	buildDBCmd.MarkFlagRequired("fasta")
+	RootCmd.AddCommand(buildDBCmd)
+}
+
+// runBuildDB is the main function for the build-db sub-command
+func runBuildDB() {
+
+	// check index flag is set (global flag but don't require it for all sub commands)
+	if *indexDir == "" {
+		fmt.Println("please specify a directory for the index files (--indexDir)")
+		os.Exit(1)
+	}
+
+	// set up profiling
+	if *profiling == true {
+		defer profile.Start(profile.MemProfile, profile.ProfilePath("./")).Stop()
+	}
+
+	// start logging
+	if *logFile != "" {
+		logFH := misc.StartLogging(*logFile)
+		defer logFH.Close()
+		log.SetOutput(logFH)
+	} else {
+		log.SetOutput(os.Stdout)
+	}
+
+	// start the build-db sub command
+	start := time.Now()
+	log.Printf("i am groot (version %s)", version.VERSION)
+	log.Printf("starting the build-db subcommand")
+
+	// check the supplied files and then log some stuff
+	log.Printf("checking parameters...")
+	misc.ErrorCheck(buildDBParamCheck())
+	log.Printf("\tprocessors: %d", *proc)
+	log.Printf("\tclustering identity: %.2f", *clusterIdentity)
+	log.Printf("\tclustering k-mer size: %d", *clusterKmerSize)
+	log.Printf("\tclustering sketch size: %d", *clusterSketchSize)
+	log.Printf("\tk-mer size: %d", *kmerSize)
+	log.Printf("\tsketch size: %d", *sketchSize)
+	log.Printf("\tgraph window size: %v", formatWindowSizes(*windowSize))
+	log.Printf("\tnum. partitions: %d", *numPart)
+	log.Printf("\tmax. K: %d", *maxK)
+	log.Printf("\tstranded: %v", *stranded)
+	log.Printf("\tlow-complexity masking (DUST level): %d", *dustLevel)
+	if *maxTravs > 0 {
+		log.Printf("\tmax. traversals per window: %d", *maxTravs)
+	}
+	if *coarseScale > 0 {
+		log.Printf("\tcoarse index scale: 1 in %d k-mers", *coarseScale)
+	}
+
+	// record the runtime information for the build-db sub command
+	info := &pipeline.Info{
+		Version:    version.VERSION,
+		KmerSize:   *kmerSize,
+		SketchSize: *sketchSize,
+		NumPart:    *numPart,
+		MaxK:       *maxK,
+		IndexDir:   *indexDir,
+		Stranded:   *stranded,
+		DustLevel:  *dustLevel,
+
+		SharedSimilarity: *sharedSim,
+		MaxTraversals:    *maxTravs,
+		WindowStride:     *stride,
+		MinimizerWindows: *minimizers,
+		CoarseScale:      *coarseScale,
+		BuildDB: pipeline.BuildDBCmd{
+			Identity:          *clusterIdentity,
+			ClusterKmerSize:   *clusterKmerSize,
+			ClusterSketchSize: *clusterSketchSize,
+			MaxCandidates:     *maxCandidates,
+		},
+	}
+	info.WindowSize, info.WindowSizes = getWindowSizes()
+	info.NumProc = *proc
+	log.Printf("\twindow selection: %v", formatWindowSelection(info))
+	misc.ErrorCheck(loadBackground(info))
+	logBackground(info)
+
+	// create the pipeline
+	log.Printf("initialising build-db pipeline...")
+	buildPipeline := pipeline.NewPipeline()
+
+	// initialise processes
+	log.Printf("\tinitialising the processes")
+	clusterBuilder := pipeline.NewClusterBuilder(info)
+	graphSketcher := pipeline.NewGraphSketcher(info)
+	sketchIndexer := pipeline.NewSketchIndexer(info)
+
+	// connect the pipeline processes and submit them to the pipeline
+	log.Printf("\tconnecting data streams")
+	clusterBuilder.Connect(*fastaFile)
+	graphSketcher.ConnectClusterBuilder(clusterBuilder)
+	sketchIndexer.Connect(graphSketcher)
+	buildPipeline.AddProcesses(clusterBuilder, graphSketcher, sketchIndexer)
+	log.Printf("\tnumber of processes added to the build-db pipeline: %d\n", buildPipeline.GetNumProcesses())
+	log.Print("clustering sequences, creating graphs, sketching traversals and indexing...")
+	buildPipeline.Run()
+	misc.ErrorCheck(tagSharedWindows(info))
+	log.Printf("writing index files in \"%v\"...", *indexDir)
+	misc.ErrorCheck(info.WriteIndex(*indexDir, *numShards))
+	misc.ErrorCheck(info.WriteManifest(*indexDir + "/" + pipeline.ManifestFile))
+	log.Printf("finished in %s", time.Since(start))
+}
+
+// buildDBParamCheck is a function to check user supplied parameters
+func buildDBParamCheck() error {
+	log.Printf("\tFASTA file: %v", *fastaFile)
+	if err := misc.CheckFile(*fastaFile); err != nil {
+		return err
+	}
+	if *clusterIdentity <= 0.0 || *clusterIdentity > 1.0 {
+		return fmt.Errorf("identity threshold must be between 0.0 and 1.0")
+	}
+	if err := windowParamCheck(); err != nil {
+		return err
+	}
+	if err := sharedParamCheck(); err != nil {
+		return err
+	}
+	if *numShards < 1 {
+		return fmt.Errorf("number of index shards must be at least 1")
+	}
+	if _, err := os.Stat(*indexDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(*indexDir, 0700); err != nil {
+			return fmt.Errorf("can't create specified output directory")
+		}
+	}
+	// set number of processors to use
+	if *proc <= 0 || *proc > runtime.NumCPU() {
+		*proc = runtime.NumCPU()
+	}
+	runtime.GOMAXPROCS(*proc)
+	return nil
+}
@@ -1,16 +1,17 @@
 package cmd
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/mholt/archiver"
 	"github.com/spf13/cobra"
+	"github.com/will-rowe/baby-groot/src/misc"
+	"github.com/will-rowe/baby-groot/src/pipeline"
 )
 
 // available databases to download
@@ -102,18 +103,10 @@ func DownloadFile(savePath string, url string) error {
 
 // getMD5 is a function to calculate the md5
 func getMD5(savePath string) error {
-	var dbMD5 string
-	file, err := os.Open(savePath)
+	dbMD5, err := misc.GetMD5(savePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return err
-	}
-	hashInBytes := hash.Sum(nil)[:16]
-	dbMD5 = hex.EncodeToString(hashInBytes)
 	lookup := fmt.Sprintf("%v.%v", *database, *identity)
 	if dbMD5 != md5sums[lookup] {
 		return errors.New("md5sum for downloaded tarball did not match record")
@@ -169,6 +162,19 @@ func runGet() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	// record where the database came from, so that indexes built from it can be traced back to this release
+	release := &pipeline.DatabaseRelease{
+		Name:       *database,
+		Identity:   *identity,
+		URL:        dbURL,
+		MD5:        md5sums[dbName],
+		Downloaded: time.Now(),
+	}
+	if err := release.Save(dbSave + "/" + pipeline.ReleaseFile); err != nil {
+		fmt.Println("could not write the database release file")
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	fmt.Printf("database saved to: %v\n", dbSave)
 	fmt.Printf("now run `groot index -m %v -i newIndex` or `groot index --help` for full options\n", dbSave)
 }
@@ -87,15 +87,15 @@ func runHaplotype() {
 	log.Printf("\tabundance cut off reporting haplotypes: %0.2f", *cutOff)
 	log.Printf("\tprocessors: %d", *proc)
 	log.Print("loading the index...")
-	log.Printf("\tgraph file: %v", *indexDir+"groot.gg")
+	shards, err := pipeline.FindShards(*indexDir)
+	misc.ErrorCheck(err)
+	log.Printf("\tgraph file: %v", shards[0].InfoFile)
 	info := new(pipeline.Info)
-	misc.ErrorCheck(info.Load(*indexDir + "/groot.gg"))
-	if info.Version != version.VERSION {
-		misc.ErrorCheck(fmt.Errorf("the groot index was created with a different version of groot (you are currently using version %v)", version.VERSION))
-	}
+	misc.ErrorCheck(info.Load(shards[0].InfoFile))
+	log.Printf("\tindex created by groot version: %v\n", info.Version)
 	log.Printf("\tk-mer size: %d\n", info.KmerSize)
 	log.Printf("\tsketch size: %d\n", info.SketchSize)
-	log.Printf("\twindow size used in indexing: %d\n", info.WindowSize)
+	log.Printf("\twindow size used in indexing: %v\n", formatWindowSizes(info.GetWindowSizes()))
 	log.Print("loading the graphs...")
 	log.Printf("\tnumber of weighted GFAs for haplotyping: %d", len(graphList))
 
@@ -107,8 +107,9 @@ func runHaplotype() {
 		HaploDir:      *haploDir,
 	}
 
-	// create a graphStore
+	// create a graphStore (graphs are named using their GFA filenames)
 	info.Store = make(graph.Store)
+	info.Sources = make(map[uint32]string)
 
 	// create the pipeline
 	log.Printf("initialising haplotype pipeline...")
@@ -135,7 +136,7 @@ func runHaplotype() {
 		log.Printf("writing files to \"%v/\"...\n", *haploDir)
 		pathNames := []string{}
 		for graphID, g := range info.Store {
-			fileName := fmt.Sprintf("%v/groot-graph-%d-haplotype", *haploDir, graphID)
+			fileName := fmt.Sprintf("%v/groot-graph-%v-haplotype", *haploDir, info.GraphFileName(graphID))
 			_, err := g.SaveGraphAsGFA(fileName+".gfa", info.Haplotype.TotalKmers)
 			misc.ErrorCheck(err)
 			seqs, err := g.Graph2Seqs()
@@ -165,7 +166,9 @@ func runHaplotype() {
 // haplotypeParamCheck is a function to check user supplied parameters
 func haplotypeParamCheck() error {
 	misc.ErrorCheck(misc.CheckDir(*indexDir))
-	misc.ErrorCheck(misc.CheckFile(*indexDir + "/groot.gg"))
+	if _, err := pipeline.FindShards(*indexDir); err != nil {
+		return err
+	}
 	misc.ErrorCheck(misc.CheckDir(*graphDirectory))
 	graphs, err := filepath.Glob(*graphDirectory + "/groot-graph-*.gfa")
 	if err != nil {
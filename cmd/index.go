@@ -1,38 +1,89 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/profile"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/will-rowe/baby-groot/src/graph"
 	"github.com/will-rowe/baby-groot/src/misc"
 	"github.com/will-rowe/baby-groot/src/pipeline"
+	"github.com/will-rowe/baby-groot/src/seqio"
 	"github.com/will-rowe/baby-groot/src/version"
 )
 
 // the command line arguments
 var (
-	kmerSize   *int     // size of k-mer
-	sketchSize *int     // size of MinHash sketch
-	windowSize *int     // length of query reads (used during alignment subcommand), needed as window length should ~= read length
-	numPart    *int     // number of partitions in the LSH Ensemble
-	maxK       *int     // maxK in the LSH Ensemble
-	msaDir     *string  // directory containing the input MSA files
-	msaList    []string // the collected MSA files
+	kmerSize      *int              // size of k-mer
+	sketchSize    *int              // size of MinHash sketch
+	windowSize    *[]int            // length of query reads (used during alignment subcommand), needed as window length should ~= read length (several sizes give a multi-resolution index)
+	numPart       *int              // number of partitions in the LSH Ensemble
+	maxK          *int              // maxK in the LSH Ensemble
+	numShards     *int              // number of shards to split the index into
+	stranded      *bool             // sketch forward k-mers and index a window for each strand
+	sharedSim     *float64          // minimum sketch similarity for windows from different graphs to be tagged as shared
+	dustLevel     *int              // DUST score threshold (x10) for masking low-complexity sequence
+	maxTravs      *int              // maximum number of graph traversals to window from each window start
+	stride        *int              // stride between the indexed windows of each path
+	minimizers    *bool             // select the indexed windows with minimizers instead of a fixed stride
+	coarseScale   *int              // sample 1 in this many k-mers from each graph for the coarse index
+	background    *string           // FASTA file of background sequences whose k-mers are subtracted from the graph windows
+	maxBackground *float64          // proportion of background k-mers at which a graph window is left out of the index
+	msaDir        *string           // directory containing the input MSA files
+	gfaDir        *string           // directory containing the input GFA files
+	appendIndex   *bool             // add the MSAs to an existing index
+	manifest      *string           // file mapping the input files to graph names
+	validate      *bool             // validate the index with simulated reads once it is built
+	simLength     *int              // length of the simulated reads used to validate the index
+	simError      *float64          // per-base substitution rate of the simulated reads
+	simReads      *int              // number of reads to simulate from each path
+	simThresh     *float64          // containment threshold used to query the simulated reads
+	tradeoff      *[]int            // window strides to measure the index size and recall of
+	inputFiles    []string          // the collected MSA or GFA files
+	inputNames    map[string]string // the graph name to use for each collected file
 )
 
+// the parameters used to build an index, which are shared by the index and build-db commands
+var indexParams = func() *pflag.FlagSet {
+	params := pflag.NewFlagSet("index parameters", pflag.ExitOnError)
+	kmerSize = params.IntP("kmerSize", "k", 21, "size of k-mer")
+	sketchSize = params.IntP("sketchSize", "s", 42, "size of MinHash sketch")
+	windowSize = params.IntSliceP("windowSize", "w", []int{100}, "size of window to sketch graph traversals with (comma separated sizes build a window set for each, so reads are queried against the closest window size)")
+	numPart = params.IntP("numPart", "x", 8, "number of partitions in the LSH Ensemble")
+	maxK = params.IntP("maxK", "y", 4, "maxK in the LSH Ensemble")
+	numShards = params.Int("shards", 1, "number of shards to split the index into (each shard is an independent pair of .gg and .lshe files)")
+	sharedSim = params.Float64("sharedSimilarity", 1.0, "minimum sketch similarity for windows from different graphs to be tagged as shared (1.0 only tags identical windows)")
+	stranded = params.Bool("stranded", false, "sketch forward k-mers instead of canonical k-mers and index a window for each strand, so that the strand of mapped reads is reported (e.g. for stranded RNA-seq)")
+	dustLevel = params.Int("dustLevel", 0, fmt.Sprintf("DUST score threshold (x10) used to mask low-complexity sequence in the graph windows and the reads (0 turns masking off, %d is the level used by dustmasker)", seqio.DefaultDustLevel))
+	maxTravs = params.Int("maxTraversals", 0, "also window every traversal through the graphs, following up to this many traversals from each window start, so that combinations of variants not seen in the reference sequences are indexed (0 only windows the reference sequences)")
+	stride = params.Int("stride", 1, "only index every Nth window of each graph path, to reduce the index size for long sequences")
+	minimizers = params.Bool("minimizers", false, "instead of a fixed --stride, index the windows whose first k-mer is the minimizer of --stride consecutive windows")
+	coarseScale = params.Int("coarseScale", 0, "also build a coarse index from 1 in N of the k-mers in each graph, so that reads can be queried in two stages with sketch --hierarchical (0 builds no coarse index)")
+	background = params.String("background", "", "FASTA file of background sequences (e.g. housekeeping genes or plasmid backbones), whose k-mers are subtracted from the graph windows so that windows dominated by them are left out of the index")
+	maxBackground = params.Float64("maxBackground", pipeline.DefaultMaxBackground, "proportion of background k-mers at which a graph window is left out of the index (used with --background)")
+	return params
+}()
+
 // the index command (used by cobra)
 var indexCmd = &cobra.Command{
 	Use:   "index",
 	Short: "Convert a set of clustered reference sequences to variation graphs and then index them",
-	Long:  `Convert a set of clustered reference sequences to variation graphs and then index them`,
+	Long: `Convert a set of clustered reference sequences to variation graphs and then index them.
+
+The graphs are windowed and sketched by a pool of --processors workers, which bounds the memory used for windowing.
+Every distinct window is still held in memory until the LSH Ensemble is written, so peak memory grows with the number of windows in the index.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		runIndex()
+		runIndex(cmd.Flags())
 	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		return misc.CheckRequiredFlags(cmd.Flags())
@@ -41,18 +92,22 @@ var indexCmd = &cobra.Command{
 
 // a function to initialise the command line arguments
 func init() {
-	kmerSize = indexCmd.Flags().IntP("kmerSize", "k", 21, "size of k-mer")
-	sketchSize = indexCmd.Flags().IntP("sketchSize", "s", 42, "size of MinHash sketch")
-	windowSize = indexCmd.Flags().IntP("windowSize", "w", 100, "size of window to sketch graph traversals with")
-	numPart = indexCmd.Flags().IntP("numPart", "x", 8, "number of partitions in the LSH Ensemble")
-	maxK = indexCmd.Flags().IntP("maxK", "y", 4, "maxK in the LSH Ensemble")
-	msaDir = indexCmd.Flags().StringP("msaDir", "m", "", "directory containing the clustered references (MSA files) - required")
-	indexCmd.MarkFlagRequired("msaDir")
+	indexCmd.Flags().AddFlagSet(indexParams)
+	msaDir = indexCmd.Flags().StringP("msaDir", "m", "", "directory containing the clustered references (MSA files)")
+	gfaDir = indexCmd.Flags().String("gfaDir", "", "directory containing variation graphs (GFA v1 files with paths) to index instead of MSAs")
+	manifest = indexCmd.Flags().String("manifest", "", "tab separated file of input file paths (relative to --msaDir/--gfaDir) and the cluster names to use for their graphs (with --append, a graphID can be used as the name to replace a graph that has no name)")
+	appendIndex = indexCmd.Flags().Bool("append", false, "add new MSAs/GFAs to (or replace updated ones in) an existing index, instead of building a new one")
+	validate = indexCmd.Flags().Bool("validate", false, "simulate reads from every graph path and report how well the index recovers them")
+	simLength = indexCmd.Flags().Int("simReadLength", 100, "length of the simulated reads used by --validate")
+	simError = indexCmd.Flags().Float64("simErrorRate", 0.01, "per-base substitution rate of the simulated reads used by --validate")
+	simReads = indexCmd.Flags().Int("simReads", 10, "number of reads to simulate from each graph path for --validate")
+	simThresh = indexCmd.Flags().Float64("simContThresh", 0.95, "containment threshold used to query the simulated reads for --validate")
+	tradeoff = indexCmd.Flags().IntSlice("tradeoff", nil, "comma separated window strides to re-index the graphs with (using a fixed stride and minimizers), reporting the index size and the recall of reads simulated as for --validate (written to "+pipeline.TradeoffFile+")")
 	RootCmd.AddCommand(indexCmd)
 }
 
 // runIndex is the main function for the index sub-command
-func runIndex() {
+func runIndex(flags *pflag.FlagSet) {
 
 	// check index flag is set (global flag but don't require it for all sub commands)
 	if *indexDir == "" {
@@ -83,80 +138,355 @@ func runIndex() {
 	// check the supplied files and then log some stuff
 	log.Printf("checking parameters...")
 	misc.ErrorCheck(indexParamCheck())
-	log.Printf("\tprocessors: %d", *proc)
-	log.Printf("\tk-mer size: %d", *kmerSize)
-	log.Printf("\tsketch size: %d", *sketchSize)
-	log.Printf("\tgraph window size: %d", *windowSize)
-	log.Printf("\tnum. partitions: %d", *numPart)
-	log.Printf("\tmax. K: %d", *maxK)
 
 	// record the runtime information for the index sub command
-	info := &pipeline.Info{
-		Version:    version.VERSION,
-		KmerSize:   *kmerSize,
-		SketchSize: *sketchSize,
-		WindowSize: *windowSize,
-		NumPart:    *numPart,
-		MaxK:       *maxK,
-		IndexDir:   *indexDir,
+	var info *pipeline.Info
+	if *appendIndex {
+		log.Printf("loading the existing index...")
+		var err error
+		info, err = loadExistingIndex(flags)
+		misc.ErrorCheck(err)
+		if len(inputFiles) == 0 {
+			log.Printf("no new or changed input files, the index is already up to date")
+			log.Printf("finished in %s", time.Since(start))
+			return
+		}
+	} else {
+		info = &pipeline.Info{
+			Version:    version.VERSION,
+			KmerSize:   *kmerSize,
+			SketchSize: *sketchSize,
+			NumPart:    *numPart,
+			MaxK:       *maxK,
+			IndexDir:   *indexDir,
+			Stranded:   *stranded,
+			DustLevel:  *dustLevel,
+
+			SharedSimilarity: *sharedSim,
+			MaxTraversals:    *maxTravs,
+			WindowStride:     *stride,
+			MinimizerWindows: *minimizers,
+			CoarseScale:      *coarseScale,
+		}
+		info.WindowSize, info.WindowSizes = getWindowSizes()
+		misc.ErrorCheck(loadBackground(info))
 	}
 
+	// if the MSAs were downloaded by groot get, record the database release
+	if releaseFile := filepath.Join(*msaDir, pipeline.ReleaseFile); *msaDir != "" && misc.CheckFile(releaseFile) == nil {
+		release := &pipeline.DatabaseRelease{}
+		misc.ErrorCheck(release.Load(releaseFile))
+		info.Database = release
+		log.Printf("\tdatabase: %v (%v%% identity)", release.Name, release.Identity)
+	}
+	log.Printf("\tprocessors: %d", *proc)
+	log.Printf("\tk-mer size: %d", info.KmerSize)
+	log.Printf("\tsketch size: %d", info.SketchSize)
+	log.Printf("\tgraph window size: %v", formatWindowSizes(info.GetWindowSizes()))
+	log.Printf("\tnum. partitions: %d", info.NumPart)
+	log.Printf("\tmax. K: %d", info.MaxK)
+	log.Printf("\tstranded: %v", info.Stranded)
+	log.Printf("\tlow-complexity masking (DUST level): %d", info.DustLevel)
+	if info.MaxTraversals > 0 {
+		log.Printf("\tmax. traversals per window: %d", info.MaxTraversals)
+	}
+	log.Printf("\twindow selection: %v", formatWindowSelection(info))
+	if info.CoarseScale > 0 {
+		log.Printf("\tcoarse index scale: 1 in %d k-mers", info.CoarseScale)
+	}
+	logBackground(info)
+	if *numShards > 1 {
+		log.Printf("\tindex shards: %d", *numShards)
+	}
+
+	// build the graphs and index them
+	buildIndex(info)
+	misc.ErrorCheck(tagSharedWindows(info))
+	log.Printf("writing index files in \"%v\"...", *indexDir)
+	misc.ErrorCheck(info.WriteIndex(*indexDir, *numShards))
+	misc.ErrorCheck(info.WriteManifest(*indexDir + "/" + pipeline.ManifestFile))
+	if *validate {
+		log.Print("validating the index with simulated reads...")
+		misc.ErrorCheck(validateIndex(info))
+	}
+	if len(*tradeoff) > 0 {
+		log.Print("measuring the trade-off between index size and recall for different window selections...")
+		misc.ErrorCheck(measureTradeoff(info))
+	}
+	log.Printf("finished in %s", time.Since(start))
+}
+
+// buildIndex is a function to run the indexing pipeline on the collected input files, adding the graphs and their sketches to the runtime info
+func buildIndex(info *pipeline.Info) {
+
+	// the graphs are windowed by a pool of workers, one per processor
+	info.NumProc = *proc
+
 	// create the pipeline
 	log.Printf("initialising indexing pipeline...")
 	indexingPipeline := pipeline.NewPipeline()
 
 	// initialise processes
 	log.Printf("\tinitialising the processes")
-	msaConverter := pipeline.NewMSAconverter(info)
 	graphSketcher := pipeline.NewGraphSketcher(info)
 	sketchIndexer := pipeline.NewSketchIndexer(info)
 
-	// connect the pipeline processes
+	// connect the pipeline processes and submit them to the pipeline
 	log.Printf("\tconnecting data streams")
-	msaConverter.Connect(msaList)
-	graphSketcher.Connect(msaConverter)
+	if *gfaDir != "" {
+		gfaConverter := pipeline.NewGFAconverter(info)
+		gfaConverter.Connect(inputFiles)
+		gfaConverter.SetNames(inputNames)
+		graphSketcher.ConnectGFA(gfaConverter)
+		indexingPipeline.AddProcess(gfaConverter)
+	} else {
+		msaConverter := pipeline.NewMSAconverter(info)
+		msaConverter.Connect(inputFiles)
+		msaConverter.SetNames(inputNames)
+		graphSketcher.Connect(msaConverter)
+		indexingPipeline.AddProcess(msaConverter)
+	}
 	sketchIndexer.Connect(graphSketcher)
-
-	// submit each process to the pipeline and run it
-	indexingPipeline.AddProcesses(msaConverter, graphSketcher, sketchIndexer)
+	indexingPipeline.AddProcesses(graphSketcher, sketchIndexer)
 	log.Printf("\tnumber of processes added to the indexing pipeline: %d\n", indexingPipeline.GetNumProcesses())
 	log.Print("creating graphs, sketching traversals and indexing...")
 	indexingPipeline.Run()
-	log.Printf("writing index files in \"%v\"...", *indexDir)
-	misc.ErrorCheck(info.SaveDB(*indexDir + "/groot.lshe"))
-	misc.ErrorCheck(info.Dump(*indexDir + "/groot.gg"))
-	log.Printf("finished in %s", time.Since(start))
 }
 
-// indexParamCheck is a function to check user supplied parameters
-func indexParamCheck() error {
+// loadExistingIndex is a function to load an index so that MSAs/GFAs can be added to it
+// graphs built from files that are already in the index are removed from the LSH Ensemble so that they can be replaced
+func loadExistingIndex(flags *pflag.FlagSet) (*pipeline.Info, error) {
+	shards, err := pipeline.FindShards(*indexDir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := pipeline.LoadShards(shards)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("\tindex created by groot version: %v", info.Version)
+	info.Version = version.VERSION
+	info.IndexDir = *indexDir
 
-	// check the supplied directory is accessible etc.
-	log.Printf("\tdirectory containing MSA files: %v", *msaDir)
-	misc.ErrorCheck(misc.CheckDir(*msaDir))
+	// the index parameters can't be changed when appending
+	for _, param := range []struct {
+		flag     string
+		user     int
+		existing int
+	}{
+		{"kmerSize", *kmerSize, info.KmerSize},
+		{"sketchSize", *sketchSize, info.SketchSize},
+		{"numPart", *numPart, info.NumPart},
+		{"maxK", *maxK, info.MaxK},
+		{"dustLevel", *dustLevel, info.DustLevel},
+		{"maxTraversals", *maxTravs, info.MaxTraversals},
+		{"stride", *stride, info.GetWindowStride()},
+	} {
+		if flags.Changed(param.flag) && param.user != param.existing {
+			return nil, fmt.Errorf("--%v does not match the existing index (%d vs. %d)", param.flag, param.user, param.existing)
+		}
+	}
+	if flags.Changed("windowSize") {
+		userSizes := append([]int{}, *windowSize...)
+		sort.Ints(userSizes)
+		if fmt.Sprint(userSizes) != fmt.Sprint(info.GetWindowSizes()) {
+			return nil, fmt.Errorf("--windowSize does not match the existing index (%v vs. %v)", userSizes, info.GetWindowSizes())
+		}
+	}
+	if flags.Changed("stranded") && *stranded != info.Stranded {
+		return nil, fmt.Errorf("--stranded does not match the existing index (%v vs. %v)", *stranded, info.Stranded)
+	}
+	if flags.Changed("minimizers") && *minimizers != info.MinimizerWindows {
+		return nil, fmt.Errorf("--minimizers does not match the existing index (%v vs. %v)", *minimizers, info.MinimizerWindows)
+	}
+
+	// the background k-mers are saved with the index, so that the added graphs are windowed with the same background
+	if *background != "" || flags.Changed("maxBackground") {
+		if info.Background == nil {
+			return nil, fmt.Errorf("--background can't be used when appending to an index that was built without a background")
+		}
+		if *background != "" {
+			md5sum, err := misc.GetMD5(*background)
+			if err != nil {
+				return nil, err
+			}
+			if md5sum != info.Background.MD5 {
+				return nil, fmt.Errorf("--background does not match the existing index (%v vs. %v)", *background, info.Background.Path)
+			}
+		}
+		if flags.Changed("maxBackground") && *maxBackground != info.Background.MaxBackground {
+			return nil, fmt.Errorf("--maxBackground does not match the existing index (%.2f vs. %.2f)", *maxBackground, info.Background.MaxBackground)
+		}
+	}
+
+	// the shared windows are tagged again once the index is updated, so the similarity can be changed
+	if flags.Changed("sharedSimilarity") {
+		info.SharedSimilarity = *sharedSim
+	}
 
-	// check there are some files with the msa extension TODO: this can be better...
-	msas, err := filepath.Glob(*msaDir + "/cluster*.msa")
+	// the coarse sketches are also rebuilt when the index is written, so the coarse scale can be changed
+	if flags.Changed("coarseScale") {
+		info.CoarseScale = *coarseScale
+	}
+	log.Printf("\tnumber of graphs in the existing index: %d", len(info.Store))
+
+	// graphs in indexes built before graphs were named can't be matched to the input files by name, so the manifest must name the input files with their graphIDs
+	if unnamed := info.UnnamedGraphs(); len(unnamed) != 0 {
+		if *manifest == "" {
+			return nil, fmt.Errorf("the existing index has %d graphs without names (graphIDs: %v), please use --manifest to give their input files these graphIDs as names, otherwise they would be added a second time", len(unnamed), unnamed)
+		}
+		named := make(map[string]struct{}, len(inputNames))
+		for _, name := range inputNames {
+			named[name] = struct{}{}
+		}
+		for _, graphID := range unnamed {
+			if _, ok := named[strconv.Itoa(int(graphID))]; !ok {
+				log.Printf("	warning: graph %d has no name and is not named by the manifest, so it will be kept as it is", graphID)
+			}
+		}
+	}
+
+	// keep the existing number of shards, unless a different number was requested
+	if !flags.Changed("shards") {
+		*numShards = len(shards)
+	}
+
+	// load the LSH Ensemble records from each shard, without populating the LSH Ensemble
+	lshe, err := pipeline.ReadShards(shards)
 	if err != nil {
-		return fmt.Errorf("no MSA files in the supplied directory (must be named cluster-DD.msa)")
+		return nil, err
 	}
 
-	// check the file accessibility and add to the pile
-	for _, msa := range msas {
-		misc.ErrorCheck(misc.CheckFile(msa))
-		msaList = append(msaList, msa)
+	// find which input files are new or have changed since they were indexed, leaving the graphs of unchanged files as they are
+	numInputs := len(inputFiles)
+	changedFiles, replacedGraphs, err := info.CheckInputs(inputFiles, inputNames)
+	if err != nil {
+		return nil, err
+	}
+	inputFiles = changedFiles
+	newGraphs := len(inputFiles) - len(replacedGraphs)
+	removed, err := lshe.RemoveGraphs(replacedGraphs)
+	if err != nil {
+		return nil, err
 	}
-	if len(msas) == 0 {
-		return fmt.Errorf("no MSA files found that passed the file checks (make sure filenames follow 'clusterXXX.msa' convention)")
+	for graphID := range replacedGraphs {
+		delete(info.Store, graphID)
+	}
+	log.Printf("\tnumber of unchanged input files: %d", numInputs-len(inputFiles))
+	log.Printf("\tnumber of graphs being replaced: %d (%d sketches removed)", len(replacedGraphs), removed)
+	log.Printf("\tnumber of graphs being added: %d", newGraphs)
+	info.AttachDB(lshe)
+	return info, nil
+}
+
+// validateIndex is a function to load the index files that were written, query them with reads simulated from every graph path and log the report
+func validateIndex(info *pipeline.Info) error {
+	setValidateParams(info)
+	log.Printf("\tsimulated read length: %d", info.Validate.ReadLength)
+	log.Printf("\tsimulated error rate: %.3f", info.Validate.ErrorRate)
+	log.Printf("\treads per path: %d", info.Validate.ReadsPerPath)
+	log.Printf("\tcontainment threshold: %.2f", info.Validate.ContainmentThreshold)
+	shards, err := pipeline.FindShards(*indexDir)
+	if err != nil {
+		return err
+	}
+	dbs := make([]*graph.ContainmentIndex, len(shards))
+	for i, shard := range shards {
+		dbs[i] = &graph.ContainmentIndex{}
+		if err := dbs[i].Load(shard.IndexFile); err != nil {
+			return err
+		}
+		defer dbs[i].Close()
+	}
+	report, err := pipeline.ValidateIndex(info, dbs)
+	if err != nil {
+		return err
+	}
+	for _, gv := range report.Graphs {
+		log.Printf("\tgraph %v: recall %.3f (%d/%d reads), wrong graph hit rate %.3f (%d/%d hits, %d reads)", gv.Name, gv.Recall(), gv.Recalled, gv.Reads, gv.WrongGraphRate(), gv.WrongGraphHits, gv.Hits, gv.WrongGraphReads)
+		for _, paths := range gv.IndistinguishablePaths {
+			log.Printf("\t\tindistinguishable paths: %v", strings.Join(paths, ", "))
+		}
+	}
+	log.Printf("\toverall recall: %.3f", report.Recall())
+	log.Printf("\toverall wrong graph hit rate: %.3f", report.WrongGraphRate())
+	return nil
+}
+
+// setValidateParams is a function to record the settings for simulating reads in the runtime info
+func setValidateParams(info *pipeline.Info) {
+	info.Validate = pipeline.ValidateCmd{
+		ReadLength:           *simLength,
+		ErrorRate:            *simError,
+		ReadsPerPath:         *simReads,
+		ContainmentThreshold: *simThresh,
+	}
+}
+
+// measureTradeoff is a function to re-index the graphs with each of the requested window strides, and report the index size and recall of simulated reads for each
+func measureTradeoff(info *pipeline.Info) error {
+	setValidateParams(info)
+	tradeoffs, err := pipeline.MeasureWindowTradeoff(info, *tradeoff)
+	if err != nil {
+		return err
+	}
+	for _, t := range tradeoffs {
+		log.Printf("\t%v (stride %d): %d windows (%.3f of all windows), recall %.3f, wrong graph hit rate %.3f", t.Selection(), t.Stride, t.Windows, float64(t.Windows)/float64(tradeoffs[0].Windows), t.Recall, t.WrongGraphRate)
+	}
+	reportFile := filepath.Join(*indexDir, pipeline.TradeoffFile)
+	if err := pipeline.WriteWindowTradeoff(reportFile, tradeoffs); err != nil {
+		return err
+	}
+	log.Printf("\twritten the trade-off report to %v", reportFile)
+	return nil
+}
+
+// indexParamCheck is a function to check user supplied parameters
+func indexParamCheck() error {
+
+	// check that one input directory has been supplied
+	if (*msaDir == "") == (*gfaDir == "") {
+		return fmt.Errorf("please specify either a directory of MSA files (--msaDir) or a directory of GFA files (--gfaDir)")
+	}
+	if *gfaDir != "" {
+		if err := gfaParamCheck(); err != nil {
+			return err
+		}
+	} else if err := msaParamCheck(); err != nil {
+		return err
+	}
+
+	// name the graphs, using the manifest if one was supplied
+	inputDir := *msaDir
+	if *gfaDir != "" {
+		inputDir = *gfaDir
+	}
+	if err := nameInputFiles(inputDir); err != nil {
+		return err
 	}
-	log.Printf("\tnumber of MSA files: %d", len(msas))
 
 	// TODO: check the supplied arguments to make sure they don't conflict with each other eg:
-	if *kmerSize > *windowSize {
-		return fmt.Errorf("supplied k-mer size greater than read length")
+	if err := windowParamCheck(); err != nil {
+		return err
+	}
+	if err := sharedParamCheck(); err != nil {
+		return err
 	}
-	// setup the indexDir
-	if _, err := os.Stat(*indexDir); os.IsNotExist(err) {
+	if *numShards < 1 {
+		return fmt.Errorf("number of index shards must be at least 1")
+	}
+	if (*validate || len(*tradeoff) > 0) && (*simLength < *kmerSize || *simReads < 1 || *simError < 0 || *simError >= 1) {
+		return fmt.Errorf("--simReadLength must be at least the k-mer size, --simReads at least 1 and --simErrorRate between 0 and 1")
+	}
+	if !*appendIndex && *numShards > len(inputFiles) {
+		return fmt.Errorf("cannot split %d graphs into %d index shards", len(inputFiles), *numShards)
+	}
+	// setup the indexDir (this must already exist if appending to an index)
+	if *appendIndex {
+		if err := misc.CheckDir(*indexDir); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(*indexDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(*indexDir, 0700); err != nil {
 			return fmt.Errorf("can't create specified output directory")
 		}
@@ -168,3 +498,269 @@ func indexParamCheck() error {
 	runtime.GOMAXPROCS(*proc)
 	return nil
 }
+
+// sharedParamCheck is a function to check the minimum similarity for tagging shared windows, the low-complexity masking level and the window selection
+func sharedParamCheck() error {
+	if *sharedSim <= 0 || *sharedSim > 1 {
+		return fmt.Errorf("--sharedSimilarity must be greater than 0 and no more than 1")
+	}
+	if *dustLevel < 0 {
+		return fmt.Errorf("--dustLevel must be 0 (no masking) or greater")
+	}
+	if *maxTravs < 0 {
+		return fmt.Errorf("--maxTraversals must be 0 (only window the reference sequences) or greater")
+	}
+	if *stride < 1 {
+		return fmt.Errorf("--stride must be at least 1")
+	}
+	if *minimizers && *stride < 2 {
+		return fmt.Errorf("--minimizers needs a --stride greater than 1")
+	}
+	if *coarseScale < 0 {
+		return fmt.Errorf("--coarseScale must be 0 (no coarse index) or greater")
+	}
+	if *maxBackground <= 0 || *maxBackground > 1 {
+		return fmt.Errorf("--maxBackground must be greater than 0 and no more than 1")
+	}
+	if *background != "" {
+		if err := misc.CheckFile(*background); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadBackground is a function to load the background sequences into the runtime info, if they were given
+func loadBackground(info *pipeline.Info) error {
+	if *background == "" {
+		return nil
+	}
+	return info.LoadBackground(*background, *maxBackground)
+}
+
+// logBackground is a function to log the background subtracted from the graph windows, if there is one
+func logBackground(info *pipeline.Info) {
+	if info.Background == nil {
+		return
+	}
+	log.Printf("\tbackground: %v (%d sequences, %d k-mers)", info.Background.Path, info.Background.Sequences, info.Background.Kmers)
+	log.Printf("\tmax. background k-mers per window: %.2f", info.Background.MaxBackground)
+}
+
+// tagSharedWindows is a function to tag the windows shared by several graphs in the index, and write a report of the regions that the graphs share to the index directory
+func tagSharedWindows(info *pipeline.Info) error {
+	log.Printf("tagging windows shared by several graphs (minimum similarity: %.2f)...", info.GetSharedSimilarity())
+	numShared, err := info.TagSharedWindows()
+	if err != nil {
+		return err
+	}
+	regions, err := info.SharedRegions()
+	if err != nil {
+		return err
+	}
+	pairs := make(map[[2]string]struct{})
+	for _, region := range regions {
+		pair := [2]string{region.Graph, region.SharedWith}
+		if pair[0] > pair[1] {
+			pair[0], pair[1] = pair[1], pair[0]
+		}
+		pairs[pair] = struct{}{}
+	}
+	log.Printf("\tnumber of shared windows: %d", numShared)
+	log.Printf("\tnumber of graph pairs sharing windows: %d", len(pairs))
+	return pipeline.WriteSharedRegions(filepath.Join(*indexDir, pipeline.SharedRegionsFile), regions)
+}
+
+// windowParamCheck is a function to check the window sizes, which must all be at least the k-mer size and must not be repeated
+func windowParamCheck() error {
+	if len(*windowSize) == 0 {
+		return fmt.Errorf("please specify at least one window size")
+	}
+	seen := make(map[int]struct{})
+	for _, size := range *windowSize {
+		if *kmerSize > size {
+			return fmt.Errorf("supplied k-mer size greater than read length")
+		}
+		if _, ok := seen[size]; ok {
+			return fmt.Errorf("window size given more than once: %d", size)
+		}
+		seen[size] = struct{}{}
+	}
+	return nil
+}
+
+// getWindowSizes is a function to return the smallest window size and, if more than one window size was given, all of the window sizes (smallest first)
+func getWindowSizes() (int, []int) {
+	windowSizes := append([]int{}, *windowSize...)
+	sort.Ints(windowSizes)
+	if len(windowSizes) == 1 {
+		return windowSizes[0], nil
+	}
+	return windowSizes[0], windowSizes
+}
+
+// formatWindowSizes is a function to format a list of window sizes for logging
+func formatWindowSizes(windowSizes []int) string {
+	return strings.Trim(fmt.Sprint(windowSizes), "[]")
+}
+
+// formatWindowSelection is a function to describe which windows of each path are indexed
+func formatWindowSelection(info *pipeline.Info) string {
+	switch {
+	case info.MinimizerWindows:
+		return fmt.Sprintf("minimizer of every %d windows", info.GetWindowStride())
+	case info.GetWindowStride() > 1:
+		return fmt.Sprintf("stride of %d", info.GetWindowStride())
+	default:
+		return "every window"
+	}
+}
+
+// msaParamCheck is a function to collect the MSA files (aligned FASTA, Clustal or Stockholm, with any extension) from the msaDir and its subdirectories
+func msaParamCheck() error {
+	log.Printf("\tdirectory containing MSA files: %v", *msaDir)
+	if err := misc.CheckDir(*msaDir); err != nil {
+		return err
+	}
+	skipped, err := collectInputFiles(*msaDir, func(path string) (bool, error) {
+		format, err := graph.SniffMSA(path)
+		return format != graph.UnknownMSA, err
+	})
+	if err != nil {
+		return err
+	}
+	if len(inputFiles) == 0 {
+		return fmt.Errorf("no MSA files found in the supplied directory (aligned FASTA, Clustal and Stockholm formats are accepted)")
+	}
+	log.Printf("\tnumber of MSA files: %d", len(inputFiles))
+	log.Printf("\tnumber of files skipped (not an MSA): %d", skipped)
+	return nil
+}
+
+// collectInputFiles is a function to walk an input directory and its subdirectories, collecting the files that are kept by a function and returning the number of files that were skipped
+// hidden files and directories are ignored
+func collectInputFiles(inputDir string, keep func(path string) (bool, error)) (int, error) {
+	skipped := 0
+	err := filepath.Walk(inputDir, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(fileInfo.Name(), ".") && path != inputDir {
+			if fileInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fileInfo.Mode().IsRegular() {
+			return nil
+		}
+		ok, err := keep(path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			skipped++
+			return nil
+		}
+		inputFiles = append(inputFiles, path)
+		return nil
+	})
+	return skipped, err
+}
+
+// nameInputFiles is a function to assign a graph name to each of the collected input files
+// names are taken from the manifest if one is supplied, otherwise the file path (relative to the input directory and without the extension) is used
+func nameInputFiles(inputDir string) error {
+	inputNames = make(map[string]string)
+	for _, inputFile := range inputFiles {
+		relPath, err := filepath.Rel(inputDir, inputFile)
+		if err != nil {
+			return err
+		}
+		relPath = strings.TrimSuffix(relPath, filepath.Ext(relPath))
+		inputNames[inputFile] = strings.Replace(filepath.ToSlash(relPath), "/", "_", -1)
+	}
+	if *manifest != "" {
+		log.Printf("\tmanifest: %v", *manifest)
+		if err := readManifest(inputDir); err != nil {
+			return err
+		}
+	}
+
+	// names are used for the output filenames, so they must be unique and filename friendly
+	seen := make(map[string]string)
+	for _, inputFile := range inputFiles {
+		name := inputNames[inputFile]
+		if name == "" || strings.ContainsAny(name, "/\\ \t") {
+			return fmt.Errorf("graph names can't be empty or contain whitespace or slashes: \"%v\" (%v)", name, inputFile)
+		}
+		if existing, ok := seen[name]; ok {
+			return fmt.Errorf("graph name \"%v\" is used for more than one file (%v and %v)", name, existing, inputFile)
+		}
+		seen[name] = inputFile
+	}
+	return nil
+}
+
+// readManifest is a function to read the manifest file, which has a line for each input file: <path relative to input directory> <tab> <cluster name>
+func readManifest(inputDir string) error {
+	if err := misc.CheckFile(*manifest); err != nil {
+		return err
+	}
+	fh, err := os.Open(*manifest)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	collected := make(map[string]string)
+	for _, inputFile := range inputFiles {
+		collected[filepath.Clean(inputFile)] = inputFile
+	}
+	named := 0
+	scanner := bufio.NewScanner(fh)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			return fmt.Errorf("manifest line %d does not have 2 tab separated columns (path and name)", lineNum)
+		}
+		path := fields[0]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(inputDir, path)
+		}
+		inputFile, ok := collected[filepath.Clean(path)]
+		if !ok {
+			return fmt.Errorf("manifest line %d refers to a file that is not in the input directory: %v", lineNum, fields[0])
+		}
+		inputNames[inputFile] = strings.TrimSpace(fields[1])
+		named++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Printf("\tnumber of files named by the manifest: %d", named)
+	return nil
+}
+
+// gfaParamCheck is a function to collect the GFA files (ending with '.gfa') from the gfaDir and its subdirectories when indexing existing variation graphs
+func gfaParamCheck() error {
+	log.Printf("\tdirectory containing GFA files: %v", *gfaDir)
+	if err := misc.CheckDir(*gfaDir); err != nil {
+		return err
+	}
+	skipped, err := collectInputFiles(*gfaDir, func(path string) (bool, error) {
+		return filepath.Ext(path) == ".gfa", nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(inputFiles) == 0 {
+		return fmt.Errorf("no GFA files found in the supplied directory (make sure filenames end with '.gfa')")
+	}
+	log.Printf("\tnumber of GFA files: %d", len(inputFiles))
+	log.Printf("\tnumber of files skipped (not a GFA): %d", skipped)
+	return nil
+}
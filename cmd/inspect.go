@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/misc"
+	"github.com/will-rowe/baby-groot/src/pipeline"
+)
+
+// the command line arguments
+var (
+	inspectGraph *string // the graph to inspect (name or graphID)
+	listPaths    *bool   // list the paths in each graph
+	gfaOut       *string // file to dump the selected graph to (GFA)
+	pathOut      *string // the path to dump from the selected graph
+	fastaOut     *string // file to dump the selected path to (FASTA)
+)
+
+// the inspect command (used by cobra)
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Report the contents of a GROOT index, or dump a graph/path from it",
+	Long:  `Report the contents of a GROOT index (graphs, paths, windows, parameters and memory use), or dump a graph as GFA or a path as FASTA`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runInspect()
+	},
+}
+
+// a function to initialise the command line arguments
+func init() {
+	inspectGraph = inspectCmd.Flags().StringP("graph", "g", "", "only report on this graph (name or graphID)")
+	listPaths = inspectCmd.Flags().Bool("paths", false, "list the paths in each graph")
+	gfaOut = inspectCmd.Flags().String("gfa", "", "write the selected graph (--graph) to this file in GFA format")
+	pathOut = inspectCmd.Flags().String("path", "", "name of the path to write as FASTA (requires --graph and --fasta)")
+	fastaOut = inspectCmd.Flags().String("fasta", "", "write the selected path (--graph and --path) to this file in FASTA format")
+	RootCmd.AddCommand(inspectCmd)
+}
+
+// runInspect is the main function for the inspect sub-command
+func runInspect() {
+	misc.ErrorCheck(inspectParamCheck())
+
+	// load the index files, measuring the heap used by each
+	shards, err := pipeline.FindShards(*indexDir)
+	misc.ErrorCheck(err)
+	heapBefore := heapInUse()
+	info, err := pipeline.LoadShards(shards)
+	misc.ErrorCheck(err)
+	heapGraphs := heapInUse()
+	lshes := make([]*graph.ContainmentIndex, len(shards))
+	windowStats := make(map[uint32]*graph.WindowStats)
+	numWindows, mappedSize := 0, 0
+	for i, shard := range shards {
+		lshes[i] = &graph.ContainmentIndex{}
+		misc.ErrorCheck(lshes[i].Load(shard.IndexFile))
+		defer lshes[i].Close()
+		for graphID, stats := range lshes[i].GetWindowStats() {
+			windowStats[graphID] = stats
+		}
+		numWindows += len(lshes[i].Windows)
+		mappedSize += lshes[i].MappedSize()
+	}
+	heapIndex := heapInUse()
+	runtime.KeepAlive(lshes)
+
+	// find the graphs to report on
+	graphIDs := []uint32{}
+	for graphID := range info.Store {
+		if *inspectGraph == "" || *inspectGraph == info.GraphName(graphID) || *inspectGraph == strconv.FormatUint(uint64(graphID), 10) {
+			graphIDs = append(graphIDs, graphID)
+		}
+	}
+	if len(graphIDs) == 0 {
+		misc.ErrorCheck(fmt.Errorf("graph not found in index: %v", *inspectGraph))
+	}
+	sort.Slice(graphIDs, func(i, j int) bool { return graphIDs[i] < graphIDs[j] })
+
+	// dump the selected graph or path if requested
+	if *gfaOut != "" || *fastaOut != "" {
+		if len(graphIDs) != 1 {
+			misc.ErrorCheck(fmt.Errorf("please select a single graph to dump (--graph)"))
+		}
+		misc.ErrorCheck(dumpGraph(info.Store[graphIDs[0]]))
+		return
+	}
+
+	// report the parameters
+	fmt.Printf("index directory: %v\n", *indexDir)
+	fmt.Printf("created by groot version: %v\n", info.Version)
+	fmt.Printf("k-mer size: %d\n", info.KmerSize)
+	fmt.Printf("sketch size: %d\n", info.SketchSize)
+	fmt.Printf("window size: %v\n", formatWindowSizes(info.GetWindowSizes()))
+	fmt.Printf("num. partitions: %d\n", info.NumPart)
+	fmt.Printf("max. K: %d\n", info.MaxK)
+	if info.Stranded {
+		fmt.Print("stranded: true\n")
+	}
+	if info.DustLevel > 0 {
+		fmt.Printf("low-complexity masking (DUST level): %d\n", info.DustLevel)
+	}
+	if info.MaxTraversals > 0 {
+		fmt.Printf("max. traversals per window: %d\n", info.MaxTraversals)
+	}
+	if info.GetWindowStride() > 1 {
+		fmt.Printf("window selection: %v\n", formatWindowSelection(info))
+	}
+	if info.HasCoarseIndex() {
+		fmt.Printf("coarse index scale: 1 in %d k-mers\n", info.CoarseScale)
+	}
+	if !info.HasPrefilter() {
+		fmt.Print("k-mer prefilter: none (re-index to prefilter reads)\n")
+	}
+	if info.Database != nil {
+		fmt.Printf("database: %v (%v%% identity)\n", info.Database.Name, info.Database.Identity)
+	}
+	if info.Background != nil {
+		fmt.Printf("background: %v (%d sequences, %d k-mers)\n", info.Background.Path, info.Background.Sequences, info.Background.Kmers)
+		fmt.Printf("windows left out as background: %d (at least %.2f background k-mers)\n", info.NumBackgroundWindows(), info.Background.MaxBackground)
+	}
+	if len(shards) > 1 {
+		fmt.Printf("number of index shards: %d\n", len(shards))
+	}
+	fmt.Printf("number of graphs: %d\n", len(info.Store))
+	fmt.Printf("number of sketched windows: %d\n", numWindows)
+	fmt.Printf("estimated heap footprint: %v MB (graphs: %v MB, LSH Ensemble index: %v MB)\n", bToMB(heapDelta(heapIndex, heapBefore)), bToMB(heapDelta(heapGraphs, heapBefore)), bToMB(heapDelta(heapIndex, heapGraphs)))
+	if mappedSize > 0 {
+		fmt.Printf("memory-mapped LSH Ensemble files: %v MB (not included in the heap footprint)\n", bToMB(uint64(mappedSize)))
+	}
+	fmt.Println()
+
+	// report each graph
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "graphID\tname\tpaths\tnodes\tedges\twindows\tmerged windows")
+	for _, graphID := range graphIDs {
+		g := info.Store[graphID]
+		edges := 0
+		for _, node := range g.SortedNodes {
+			edges += len(node.OutEdges)
+		}
+		stats, ok := windowStats[graphID]
+		if !ok {
+			stats = &graph.WindowStats{}
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%d\t%d\t%d\t%d\t%d\n", graphID, info.GraphName(graphID), len(g.Paths), len(g.SortedNodes), edges, stats.Windows, stats.Merged)
+	}
+	tw.Flush()
+
+	// list the paths if requested
+	if *listPaths || *inspectGraph != "" {
+		for _, graphID := range graphIDs {
+			g := info.Store[graphID]
+			fmt.Printf("\npaths in graph %v:\n", info.GraphName(graphID))
+			pathIDs := []int{}
+			for pathID := range g.Paths {
+				pathIDs = append(pathIDs, int(pathID))
+			}
+			sort.Ints(pathIDs)
+			for _, pathID := range pathIDs {
+				fmt.Printf("\t%v\t%d bp\n", string(g.Paths[uint32(pathID)]), g.Lengths[uint32(pathID)])
+			}
+		}
+	}
+}
+
+// inspectParamCheck is a function to check user supplied parameters
+func inspectParamCheck() error {
+	if *indexDir == "" {
+		return fmt.Errorf("please specify a directory with the index files (--indexDir)")
+	}
+	if err := misc.CheckDir(*indexDir); err != nil {
+		return err
+	}
+	if _, err := pipeline.FindShards(*indexDir); err != nil {
+		return err
+	}
+	if (*pathOut == "") != (*fastaOut == "") {
+		return fmt.Errorf("--path and --fasta must be used together")
+	}
+	if (*gfaOut != "" || *fastaOut != "") && *inspectGraph == "" {
+		return fmt.Errorf("please select a graph to dump (--graph)")
+	}
+	return nil
+}
+
+// dumpGraph is a function to write a graph as GFA and/or one of its paths as FASTA
+func dumpGraph(g *graph.GrootGraph) error {
+	if *gfaOut != "" {
+		if err := g.DumpGraphAsGFA(*gfaOut); err != nil {
+			return err
+		}
+		fmt.Printf("graph written to: %v\n", *gfaOut)
+	}
+	if *fastaOut == "" {
+		return nil
+	}
+	seqs, err := g.Graph2Seqs()
+	if err != nil {
+		return err
+	}
+	for pathID, pathName := range g.Paths {
+		if string(pathName) != *pathOut {
+			continue
+		}
+		fh, err := os.Create(*fastaOut)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		if _, err := fmt.Fprintf(fh, ">%v\n%v\n", string(pathName), string(seqs[pathID])); err != nil {
+			return err
+		}
+		fmt.Printf("path written to: %v\n", *fastaOut)
+		return nil
+	}
+	return fmt.Errorf("path not found in graph: %v", *pathOut)
+}
+
+// heapInUse is a function to return the bytes currently allocated on the heap, after running the garbage collector
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// heapDelta returns the growth of the heap between two readings, which is zero if the garbage collector freed more than was allocated in between
+func heapDelta(after, before uint64) uint64 {
+	if after < before {
+		return 0
+	}
+	return after - before
+}
+
+// bToMB converts bytes to megabytes
+func bToMB(b uint64) string {
+	return fmt.Sprintf("%.1f", float64(b)/1024/1024)
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/misc"
+	"github.com/will-rowe/baby-groot/src/pipeline"
+	"github.com/will-rowe/baby-groot/src/version"
+)
+
+// the command line arguments
+var (
+	mergeInputs   *[]string // the index directories to merge
+	mergePrefixes *[]string // prefixes to add to the graph names from each index
+	mergeShards   *int      // number of shards to split the merged index into
+)
+
+// the merge-index command (used by cobra)
+var mergeCmd = &cobra.Command{
+	Use:   "merge-index",
+	Short: "Merge two or more GROOT indexes into a single index",
+	Long:  `Merge two or more GROOT indexes, built with the same k-mer, sketch and window sizes, numPart, maxK, strandedness, DUST level and window selection, into a single index (without re-sketching the graphs)`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runMerge()
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return misc.CheckRequiredFlags(cmd.Flags())
+	},
+}
+
+// a function to initialise the command line arguments
+func init() {
+	mergeInputs = mergeCmd.Flags().StringSlice("indexes", []string{}, "directories of the indexes to merge (comma separated) - required")
+	mergePrefixes = mergeCmd.Flags().StringSlice("prefixes", []string{}, "prefix to add to the graph names from each index (comma separated, one per index), for indexes that use the same graph names")
+	mergeShards = mergeCmd.Flags().Int("shards", 1, "number of shards to split the merged index into")
+	mergeCmd.MarkFlagRequired("indexes")
+	RootCmd.AddCommand(mergeCmd)
+}
+
+// runMerge is the main function for the merge-index sub-command
+func runMerge() {
+
+	// check index flag is set (global flag but don't require it for all sub commands)
+	if *indexDir == "" {
+		fmt.Println("please specify a directory for the merged index files (--indexDir)")
+		os.Exit(1)
+	}
+
+	// start logging
+	if *logFile != "" {
+		logFH := misc.StartLogging(*logFile)
+		defer logFH.Close()
+		log.SetOutput(logFH)
+	} else {
+		log.SetOutput(os.Stdout)
+	}
+
+	// start the merge-index sub command
+	start := time.Now()
+	log.Printf("i am groot (version %s)", version.VERSION)
+	log.Printf("starting the merge-index subcommand")
+	log.Printf("checking parameters...")
+	misc.ErrorCheck(mergeParamCheck())
+
+	// load the graphs and LSH Ensemble records from each index
+	log.Printf("loading the indexes...")
+	infos := make([]*pipeline.Info, len(*mergeInputs))
+	dbs := make([]*graph.ContainmentIndex, len(*mergeInputs))
+	for i, inputDir := range *mergeInputs {
+		shards, err := pipeline.FindShards(inputDir)
+		misc.ErrorCheck(err)
+		infos[i], err = pipeline.LoadShards(shards)
+		misc.ErrorCheck(err)
+		dbs[i], err = pipeline.ReadShards(shards)
+		misc.ErrorCheck(err)
+		log.Printf("\t%v: %d graphs, %d sketches (groot version %v)", inputDir, len(infos[i].Store), len(dbs[i].Windows), infos[i].Version)
+	}
+
+	// merge the indexes and write the merged index
+	log.Printf("merging the indexes...")
+	var prefixes []string
+	if len(*mergePrefixes) != 0 {
+		prefixes = *mergePrefixes
+	}
+	info, err := pipeline.MergeIndexes(infos, dbs, prefixes)
+	misc.ErrorCheck(err)
+	info.Version = version.VERSION
+	info.IndexDir = *indexDir
+	log.Printf("\tnumber of graphs in the merged index: %d", len(info.Store))
+	misc.ErrorCheck(tagSharedWindows(info))
+	log.Printf("writing index files in \"%v\"...", *indexDir)
+	misc.ErrorCheck(info.WriteIndex(*indexDir, *mergeShards))
+	misc.ErrorCheck(info.WriteManifest(*indexDir + "/" + pipeline.ManifestFile))
+	log.Printf("finished in %s", time.Since(start))
+}
+
+// mergeParamCheck is a function to check user supplied parameters
+func mergeParamCheck() error {
+	if len(*mergeInputs) < 2 {
+		return fmt.Errorf("please specify at least 2 indexes to merge (--indexes)")
+	}
+	if len(*mergePrefixes) != 0 && len(*mergePrefixes) != len(*mergeInputs) {
+		return fmt.Errorf("please specify one prefix per index (--prefixes)")
+	}
+	if *mergeShards < 1 {
+		return fmt.Errorf("number of index shards must be at least 1")
+	}
+	outputDir, err := filepath.Abs(*indexDir)
+	if err != nil {
+		return err
+	}
+	for _, inputDir := range *mergeInputs {
+		if err := misc.CheckDir(inputDir); err != nil {
+			return err
+		}
+		if absDir, err := filepath.Abs(inputDir); err == nil && absDir == outputDir {
+			return fmt.Errorf("the merged index can't be written to one of the input index directories: %v", inputDir)
+		}
+		log.Printf("\tindex to merge: %v", inputDir)
+	}
+	if _, err := os.Stat(*indexDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(*indexDir, 0700); err != nil {
+			return fmt.Errorf("can't create specified output directory")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/misc"
+	"github.com/will-rowe/baby-groot/src/pipeline"
+	"github.com/will-rowe/baby-groot/src/version"
+)
+
+// the command line arguments
+var (
+	removeGraphs   *[]int    // graphIDs to remove from the index
+	removeClusters *[]string // cluster names to remove from the index
+	replaceMSA     *string   // the corrected MSA to replace a graph with
+	replaceCluster *string   // the cluster name of the graph to replace
+)
+
+// the index remove command (used by cobra)
+var removeCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove graphs from an existing index",
+	Long:  `Remove graphs (by graphID or cluster name) and their sketches from an existing index, without rebuilding it`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runRemove()
+	},
+}
+
+// the index replace command (used by cobra)
+var replaceCmd = &cobra.Command{
+	Use:   "replace",
+	Short: "Replace a graph in an existing index using a corrected MSA",
+	Long:  `Replace a graph in an existing index with one built from a corrected MSA, without rebuilding the rest of the index`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runReplace(cmd)
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return misc.CheckRequiredFlags(cmd.Flags())
+	},
+}
+
+// a function to initialise the command line arguments
+func init() {
+	removeGraphs = removeCmd.Flags().IntSlice("graph", []int{}, "graphID(s) to remove (comma separated)")
+	removeClusters = removeCmd.Flags().StringSlice("cluster", []string{}, "cluster name(s) of the graphs to remove (comma separated)")
+	replaceMSA = replaceCmd.Flags().String("msa", "", "the corrected MSA file to build the replacement graph from - required")
+	replaceCluster = replaceCmd.Flags().String("cluster", "", "cluster name of the graph to replace (defaults to the MSA filename, without the extension)")
+	replaceCmd.MarkFlagRequired("msa")
+	indexCmd.AddCommand(removeCmd, replaceCmd)
+}
+
+// runRemove is the main function for the index remove sub-command
+func runRemove() {
+	start, logFH := startIndexEdit("remove")
+	if logFH != nil {
+		defer logFH.Close()
+	}
+	if len(*removeGraphs) == 0 && len(*removeClusters) == 0 {
+		misc.ErrorCheck(fmt.Errorf("please specify the graphs to remove (--graph and/or --cluster)"))
+	}
+
+	// load the graphs and LSH Ensemble records from the index
+	log.Printf("loading the index...")
+	shards, err := pipeline.FindShards(*indexDir)
+	misc.ErrorCheck(err)
+	info, err := pipeline.LoadShards(shards)
+	misc.ErrorCheck(err)
+	lshe, err := pipeline.ReadShards(shards)
+	misc.ErrorCheck(err)
+	info.AttachDB(lshe)
+	log.Printf("\tnumber of graphs in the index: %d", len(info.Store))
+
+	// find the graphs to remove
+	graphIDs := make(map[uint32]struct{})
+	for _, graphID := range *removeGraphs {
+		if _, ok := info.Store[uint32(graphID)]; graphID < 0 || !ok {
+			misc.ErrorCheck(fmt.Errorf("graph not found in index: %d", graphID))
+		}
+		graphIDs[uint32(graphID)] = struct{}{}
+	}
+	for _, cluster := range *removeClusters {
+		graphID, ok := info.FindGraph(cluster)
+		if !ok {
+			misc.ErrorCheck(fmt.Errorf("cluster not found in index: %v", cluster))
+		}
+		graphIDs[graphID] = struct{}{}
+	}
+	if len(graphIDs) == len(info.Store) {
+		misc.ErrorCheck(fmt.Errorf("cannot remove every graph from the index"))
+	}
+	for graphID := range graphIDs {
+		log.Printf("\tremoving graph %d (%v)", graphID, info.GraphName(graphID))
+	}
+	removed, err := info.RemoveGraphs(graphIDs)
+	misc.ErrorCheck(err)
+	log.Printf("\tnumber of sketches removed: %d", removed)
+
+	// keep the number of shards, unless there are now fewer graphs than shards
+	numShards := len(shards)
+	if numShards > len(info.Store) {
+		numShards = len(info.Store)
+	}
+	finishIndexEdit(info, numShards, start)
+}
+
+// runReplace is the main function for the index replace sub-command
+func runReplace(cmd *cobra.Command) {
+	start, logFH := startIndexEdit("replace")
+	if logFH != nil {
+		defer logFH.Close()
+	}
+	misc.ErrorCheck(misc.CheckFile(*replaceMSA))
+	format, err := graph.SniffMSA(*replaceMSA)
+	misc.ErrorCheck(err)
+	if format == graph.UnknownMSA {
+		misc.ErrorCheck(fmt.Errorf("not an MSA file (aligned FASTA, Clustal and Stockholm formats are accepted): %v", *replaceMSA))
+	}
+	if *replaceCluster == "" {
+		*replaceCluster = strings.TrimSuffix(filepath.Base(*replaceMSA), filepath.Ext(*replaceMSA))
+	}
+	log.Printf("\tcorrected MSA: %v", *replaceMSA)
+	log.Printf("\tcluster to replace: %v", *replaceCluster)
+
+	// check the graph is in the index, so that the MSA isn't added as a new graph
+	shards, err := pipeline.FindShards(*indexDir)
+	misc.ErrorCheck(err)
+	existing, err := pipeline.LoadShards(shards)
+	misc.ErrorCheck(err)
+	if _, ok := existing.FindGraph(*replaceCluster); !ok {
+		misc.ErrorCheck(fmt.Errorf("cluster not found in index: %v (use groot index --append to add new graphs)", *replaceCluster))
+	}
+
+	// load the index without the old graph and sketch the replacement
+	log.Printf("loading the existing index...")
+	inputFiles = []string{*replaceMSA}
+	inputNames = map[string]string{*replaceMSA: *replaceCluster}
+	info, err := loadExistingIndex(cmd.Flags())
+	misc.ErrorCheck(err)
+	buildIndex(info)
+	finishIndexEdit(info, *numShards, start)
+}
+
+// startIndexEdit is a function to check the index directory and start logging for the index remove and replace sub-commands
+// it returns the start time and the log file, which the caller should close (nil if logging to STDOUT)
+func startIndexEdit(subcommand string) (time.Time, *os.File) {
+	if *indexDir == "" {
+		fmt.Println("please specify a directory with the index files (--indexDir)")
+		os.Exit(1)
+	}
+	misc.ErrorCheck(misc.CheckDir(*indexDir))
+	var logFH *os.File
+	if *logFile != "" {
+		logFH = misc.StartLogging(*logFile)
+		log.SetOutput(logFH)
+	} else {
+		log.SetOutput(os.Stdout)
+	}
+	log.Printf("i am groot (version %s)", version.VERSION)
+	log.Printf("starting the index %v subcommand", subcommand)
+	return time.Now(), logFH
+}
+
+// finishIndexEdit is a function to write the edited index back to the index directory
+func finishIndexEdit(info *pipeline.Info, numShards int, start time.Time) {
+	info.Version = version.VERSION
+	info.IndexDir = *indexDir
+	misc.ErrorCheck(tagSharedWindows(info))
+	log.Printf("\tnumber of graphs in the index: %d", len(info.Store))
+	log.Printf("writing index files in \"%v\"...", *indexDir)
+	misc.ErrorCheck(info.WriteIndex(*indexDir, numShards))
+	misc.ErrorCheck(info.WriteManifest(*indexDir + "/" + pipeline.ManifestFile))
+	log.Printf("finished in %s", time.Since(start))
+}
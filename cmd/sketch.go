@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"os"
@@ -21,6 +22,9 @@ import (
 // there is also the bottleneck of accessing the LSH Forest (and to some extent the graphs)
 const MinionMultiplier = 1
 
+// mappingsFile is the name of the mapping report written to the graphDir
+const mappingsFile = "groot-mappings.tsv"
+
 // the command line arguments
 var (
 	fastq                *[]string                                                         // list of FASTQ files to align
@@ -28,6 +32,12 @@ var (
 	containmentThreshold *float64                                                          // the containment threshold for the LSH ensemble
 	minKmerCoverage      *float64                                                          // the minimum k-mer coverage per base of a segment
 	graphDir             *string                                                           // directory to save gfa graphs to
+	shardMode            *string                                                           // how to query a sharded index (concurrent or sequential)
+	sharedPolicy         *string                                                           // how to map reads that hit windows shared by several graphs (distribute, drop or flag)
+	minSharedKmers       *int                                                              // the number of k-mers a read must share with the index to be sketched
+	hierarchical         *bool                                                             // query reads in two stages, choosing the graphs with the coarse index first
+	coarseThreshold      *float64                                                          // the proportion of sampled read k-mers a graph must hold to be chosen by the coarse index
+	reportMappings       *bool                                                             // write the graph window and strand of each read mapping to the graphDir
 	defaultGraphDir      = "./groot-graphs-" + string(time.Now().Format("20060102150405")) // a default graphDir
 )
 
@@ -51,6 +61,12 @@ func init() {
 	containmentThreshold = sketchCmd.Flags().Float64P("contThresh", "t", 0.95, "containment threshold for the LSH ensemble")
 	minKmerCoverage = sketchCmd.Flags().Float64P("minKmerCov", "c", 1.0, "minimum number of k-mers covering each base of a graph segment")
 	graphDir = sketchCmd.PersistentFlags().StringP("graphDir", "g", defaultGraphDir, "directory to save variation graphs to")
+	shardMode = sketchCmd.Flags().String("shardMode", "concurrent", "how to query a sharded index: concurrent (hold all shards in memory) or sequential (hold one shard at a time)")
+	sharedPolicy = sketchCmd.Flags().String("sharedPolicy", pipeline.SharedFlag, "how to map reads that hit windows shared by several graphs: distribute (split the read k-mers between the graphs), drop (ignore the shared windows) or flag (project onto every graph and report the shared k-mers)")
+	minSharedKmers = sketchCmd.Flags().Int("minSharedKmers", pipeline.DefaultMinSharedKmers, fmt.Sprintf("minimum number of k-mers a read must share with the index (according to its k-mer prefilter) to be sketched, which speeds up mapping but can drop a few mappable reads (0 sketches every read, %d is a good starting point)", pipeline.SuggestedMinSharedKmers))
+	hierarchical = sketchCmd.Flags().Bool("hierarchical", false, "query reads in two stages: choose the graphs with the coarse index (built with index --coarseScale), then only query the windows from those graphs")
+	coarseThreshold = sketchCmd.Flags().Float64("coarseThresh", pipeline.DefaultCoarseThreshold, "proportion of the sampled k-mers from a read that a graph must hold to be chosen by the coarse index (used with --hierarchical)")
+	reportMappings = sketchCmd.Flags().Bool("mappings", false, fmt.Sprintf("write the graph window and strand (for a stranded index) of each read mapping to %v in the graphDir", mappingsFile))
 	RootCmd.AddCommand(sketchCmd)
 }
 
@@ -95,20 +111,31 @@ func runSketch() {
 		log.Print("\tinput file format: fasta")
 	}
 	log.Print("loading the index information...")
-	info := new(pipeline.Info)
-	misc.ErrorCheck(info.Load(*indexDir + "/groot.gg"))
-	if info.Version != version.VERSION {
-		misc.ErrorCheck(fmt.Errorf("the groot index was created with a different version of groot (you are currently using version %v)", version.VERSION))
-	}
+	shards, err := pipeline.FindShards(*indexDir)
+	misc.ErrorCheck(err)
+	info, err := pipeline.LoadShards(shards)
+	misc.ErrorCheck(err)
+	log.Printf("\tindex created by groot version: %v\n", info.Version)
 	log.Printf("\tk-mer size: %d\n", info.KmerSize)
 	log.Printf("\tsketch size: %d\n", info.SketchSize)
-	log.Printf("\twindow size used in indexing: %d\n", info.WindowSize)
+	log.Printf("\twindow size used in indexing: %v\n", formatWindowSizes(info.GetWindowSizes()))
+	if info.Stranded {
+		log.Print("\tstranded index: reporting the strand of mapped reads\n")
+	}
+	if info.DustLevel > 0 {
+		log.Printf("\tmasking low-complexity reads (DUST level): %d\n", info.DustLevel)
+	}
 	log.Print("loading the graphs...")
 	log.Printf("\tnumber of variation graphs: %d\n", len(info.Store))
-	log.Print("rebuilding the LSH Ensemble...")
-	lshe := &graph.ContainmentIndex{}
-	misc.ErrorCheck(lshe.Load(*indexDir + "/groot.lshe"))
-	info.AttachDB(lshe)
+	if len(shards) == 1 {
+		log.Print("loading the LSH Ensemble...")
+		lshe := &graph.ContainmentIndex{}
+		misc.ErrorCheck(lshe.Load(shards[0].IndexFile))
+		info.AttachDB(lshe)
+	} else {
+		log.Printf("\tnumber of index shards: %d\n", len(shards))
+		log.Printf("\tshard mode: %v\n", *shardMode)
+	}
 	if *profiling {
 		log.Printf("\tloaded lshe file -> current memory usage %v", misc.PrintMemUsage())
 		runtime.GC()
@@ -119,10 +146,39 @@ func runSketch() {
 	info.Profiling = *profiling
 	info.ContainmentThreshold = *containmentThreshold
 	info.Sketch = pipeline.SketchCmd{
-		Fasta:           *fasta,
-		MinKmerCoverage: *minKmerCoverage,
+		Fasta:            *fasta,
+		MinKmerCoverage:  *minKmerCoverage,
+		SequentialShards: *shardMode == "sequential",
+		SharedPolicy:     *sharedPolicy,
+		MinSharedKmers:   *minSharedKmers,
+		Hierarchical:     *hierarchical,
+		CoarseThreshold:  *coarseThreshold,
 	}
 	log.Printf("\tcontainment threshold: %.2f\n", info.ContainmentThreshold)
+	log.Printf("\tshared window policy: %v\n", info.Sketch.SharedPolicy)
+	switch {
+	case info.Sketch.MinSharedKmers == 0:
+		log.Print("\tk-mer prefilter: off\n")
+	case !info.HasPrefilter():
+		log.Print("\tk-mer prefilter: not in index (re-index to prefilter reads)\n")
+	default:
+		log.Printf("\tk-mer prefilter: reads must share %d k-mers with the index\n", info.Sketch.MinSharedKmers)
+	}
+	if info.Sketch.Hierarchical {
+		misc.ErrorCheck(info.UseCoarseIndex())
+		log.Printf("\ttwo-stage querying: graphs must hold %.2f of the sampled read k-mers (coarse index scale: 1 in %d k-mers)\n", info.Sketch.CoarseThreshold, info.CoarseScale)
+	}
+
+	// record the mappings of each read, if requested
+	var mappingsWriter *bufio.Writer
+	if *reportMappings {
+		mappingsFH, err := os.Create(fmt.Sprintf("%v/%v", *graphDir, mappingsFile))
+		misc.ErrorCheck(err)
+		defer mappingsFH.Close()
+		mappingsWriter = bufio.NewWriter(mappingsFH)
+		misc.ErrorCheck(info.RecordMappings(mappingsWriter))
+		log.Printf("\tmapping report: %v/%v\n", *graphDir, mappingsFile)
+	}
 
 	// create the pipeline
 	log.Printf("initialising alignment pipeline...")
@@ -148,13 +204,16 @@ func runSketch() {
 	alignmentPipeline.AddProcesses(dataStream, fastqHandler, fastqChecker, readMapper, graphPruner)
 	log.Printf("\tnumber of processes added to the alignment pipeline: %d\n", alignmentPipeline.GetNumProcesses())
 	alignmentPipeline.Run()
+	if mappingsWriter != nil {
+		misc.ErrorCheck(mappingsWriter.Flush())
+	}
 
 	// once the sketching pipeline is finished, process the graph store and write the graphs to disk
 	if len(info.Store) != 0 {
 		log.Printf("saving graphs...\n")
 		stats := readMapper.CollectReadStats()
 		for graphID, g := range info.Store {
-			fileName := fmt.Sprintf("%v/groot-graph-%d.gfa", *graphDir, graphID)
+			fileName := fmt.Sprintf("%v/groot-graph-%v.gfa", *graphDir, info.GraphFileName(graphID))
 			_, err := g.SaveGraphAsGFA(fileName, stats[3])
 			misc.ErrorCheck(err)
 		}
@@ -178,8 +237,21 @@ func alignParamCheck() error {
 
 	// check the index directory and files
 	misc.ErrorCheck(misc.CheckDir(*indexDir))
-	misc.ErrorCheck(misc.CheckFile(*indexDir + "/groot.gg"))
-	misc.ErrorCheck(misc.CheckFile(*indexDir + "/groot.lshe"))
+	if _, err := pipeline.FindShards(*indexDir); err != nil {
+		return err
+	}
+	if *shardMode != "concurrent" && *shardMode != "sequential" {
+		return fmt.Errorf("--shardMode must be concurrent or sequential")
+	}
+	if *sharedPolicy != pipeline.SharedDistribute && *sharedPolicy != pipeline.SharedDrop && *sharedPolicy != pipeline.SharedFlag {
+		return fmt.Errorf("--sharedPolicy must be distribute, drop or flag")
+	}
+	if *minSharedKmers < 0 {
+		return fmt.Errorf("--minSharedKmers must not be negative")
+	}
+	if *coarseThreshold <= 0 || *coarseThreshold > 1 {
+		return fmt.Errorf("--coarseThresh must be greater than 0 and no more than 1")
+	}
 
 	// setup the graphDir
 	if _, err := os.Stat(*graphDir); os.IsNotExist(err) {
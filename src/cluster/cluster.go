@@ -0,0 +1,193 @@
+// Package cluster groups reference sequences at a sequence identity threshold. MinHash sketches are used to find candidate pairs, which are then aligned to check their identity.
+package cluster
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/will-rowe/baby-groot/src/seqio"
+)
+
+// the scoring scheme used for the pairwise alignments
+const (
+	matchScore    = 2
+	mismatchScore = -4
+	gapScore      = -4
+)
+
+// Clusterer holds the settings used to cluster sequences
+type Clusterer struct {
+	kmerSize      int
+	sketchSize    int
+	identity      float64
+	maxCandidates int
+}
+
+// NewClusterer is the constructor
+// maxCandidates is the number of cluster representatives (ranked by sketch similarity) that are aligned to a sequence before it is used to start a new cluster
+func NewClusterer(kmerSize, sketchSize int, identity float64, maxCandidates int) (*Clusterer, error) {
+	if identity <= 0.0 || identity > 1.0 {
+		return nil, fmt.Errorf("identity threshold must be between 0.0 and 1.0")
+	}
+	if kmerSize < 1 || sketchSize < 1 || maxCandidates < 1 {
+		return nil, fmt.Errorf("k-mer size, sketch size and number of candidates must be positive")
+	}
+	return &Clusterer{
+		kmerSize:      kmerSize,
+		sketchSize:    sketchSize,
+		identity:      identity,
+		maxCandidates: maxCandidates,
+	}, nil
+}
+
+// candidate is a cluster representative that shares sketch values with a query sequence
+type candidate struct {
+	rep        int
+	similarity float64
+}
+
+// Run is a method to cluster a set of sequences
+// it uses a greedy incremental approach: sequences are processed longest first and are added to the first cluster whose representative sequence they align to at or above the identity threshold
+// it returns the clusters as slices of indices for the input sequences, with the representative sequence first
+func (Clusterer *Clusterer) Run(seqs []*seqio.Sequence) ([][]int, error) {
+
+	// sketch every sequence
+	sketches := make([][]uint64, len(seqs))
+	for i, seq := range seqs {
+		if len(seq.Seq) < Clusterer.kmerSize {
+			continue
+		}
+		sketch, err := seq.RunMinHash(Clusterer.kmerSize, Clusterer.sketchSize, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		sketches[i] = append([]uint64(nil), sketch...)
+	}
+
+	// order the sequences by length (longest first)
+	order := make([]int, len(seqs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return len(seqs[order[i]].Seq) > len(seqs[order[j]].Seq) })
+
+	// assign each sequence to a cluster
+	clusters := [][]int{}
+	reps := []int{}
+	for _, seqIdx := range order {
+		assigned := -1
+		for _, cand := range Clusterer.getCandidates(sketches, reps, seqIdx) {
+			if Identity(seqs[reps[cand.rep]].Seq, seqs[seqIdx].Seq) >= Clusterer.identity {
+				assigned = cand.rep
+				break
+			}
+		}
+		if assigned == -1 {
+			reps = append(reps, seqIdx)
+			clusters = append(clusters, []int{seqIdx})
+			continue
+		}
+		clusters[assigned] = append(clusters[assigned], seqIdx)
+	}
+	return clusters, nil
+}
+
+// getCandidates is a method to find the cluster representatives which share the most sketch values with a sequence
+func (Clusterer *Clusterer) getCandidates(sketches [][]uint64, reps []int, seqIdx int) []candidate {
+	query := sketches[seqIdx]
+	if query == nil {
+		return nil
+	}
+	candidates := []candidate{}
+	for repIdx, rep := range reps {
+		if sketches[rep] == nil {
+			continue
+		}
+		shared := 0
+		for i, val := range query {
+			if sketches[rep][i] == val {
+				shared++
+			}
+		}
+		if shared == 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{rep: repIdx, similarity: float64(shared) / float64(len(query))})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].similarity > candidates[j].similarity })
+	if len(candidates) > Clusterer.maxCandidates {
+		candidates = candidates[:Clusterer.maxCandidates]
+	}
+	return candidates
+}
+
+// Identity returns the proportion of bases in the shorter sequence that are identical in a banded global alignment of two sequences
+func Identity(a, b []byte) float64 {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	if len(b) == 0 {
+		return 0.0
+	}
+
+	// the band has to cover the length difference, plus some room for indels
+	band := len(a) - len(b) + len(b)/10 + 16
+
+	// two rows of scores and match counts are enough, as only the identity is needed
+	const unset = -1 << 30
+	prevScore, currScore := make([]int, len(b)+1), make([]int, len(b)+1)
+	prevMatch, currMatch := make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range prevScore {
+		prevScore[j] = unset
+		if j <= band {
+			prevScore[j] = j * gapScore
+		}
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := range currScore {
+			currScore[j], currMatch[j] = unset, 0
+		}
+		lower, upper := i-band, i+band
+		if lower < 0 {
+			lower = 0
+		}
+		if upper > len(b) {
+			upper = len(b)
+		}
+		for j := lower; j <= upper; j++ {
+			best, matches := unset, 0
+			if j == 0 {
+				best = i * gapScore
+			}
+
+			// gap in b
+			if prevScore[j] != unset && prevScore[j]+gapScore > best {
+				best, matches = prevScore[j]+gapScore, prevMatch[j]
+			}
+			if j == 0 {
+				currScore[j], currMatch[j] = best, matches
+				continue
+			}
+
+			// gap in a
+			if currScore[j-1] != unset && currScore[j-1]+gapScore > best {
+				best, matches = currScore[j-1]+gapScore, currMatch[j-1]
+			}
+
+			// match or mismatch
+			if prevScore[j-1] != unset {
+				sub, match := mismatchScore, 0
+				if a[i-1] == b[j-1] {
+					sub, match = matchScore, 1
+				}
+				if prevScore[j-1]+sub > best || (prevScore[j-1]+sub == best && prevMatch[j-1]+match > matches) {
+					best, matches = prevScore[j-1]+sub, prevMatch[j-1]+match
+				}
+			}
+			currScore[j], currMatch[j] = best, matches
+		}
+		prevScore, currScore = currScore, prevScore
+		prevMatch, currMatch = currMatch, prevMatch
+	}
+	return float64(prevMatch[len(b)]) / float64(len(b))
+}
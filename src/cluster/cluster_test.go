@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/will-rowe/baby-groot/src/seqio"
+)
+
+var (
+	seqA = []byte("ATGAAAGGATTAAAAGGGCTATTGGTTCTGGCTTTAGGCTTTACAGGACTACAGGTTTTTGGGCAACAGAACCCTGATATTAAAATTGAAAAATTAAAAGATAATTTATACGTCTATACAACC")
+	seqB = []byte("ATGAAAGGATTAAAAGGGCTATTGGTTCTGGCTTTAGGCTTTACAGGACTACAGGTTTTTGGGCAACAGAACCCTGATATTAAAATTGAAAAATTAAAAGATAATTTATACGTCTATACTACC")
+	seqC = []byte("GCGCGCTTATATCGCGCGAAACCCGGGTTTAACCGGTTACGTACGTAGCTAGCTAGGGCCCATATATCGCGCATCGATCGGGCCATATTTAAACCGCGTATACGCGATTATACGCGTATCGCA")
+)
+
+// test Identity
+func TestIdentity(t *testing.T) {
+	if Identity(seqA, seqA) != 1.0 {
+		t.Fatal("identical sequences should have an identity of 1.0")
+	}
+	if id := Identity(seqA, seqB); id < 0.99 || id == 1.0 {
+		t.Fatalf("sequences with a single mismatch have the wrong identity: %.3f", id)
+	}
+	if id := Identity(seqA, seqA[10:]); id != 1.0 {
+		t.Fatalf("sequence should be fully contained in the longer sequence: %.3f", id)
+	}
+	if id := Identity(seqA, seqC); id > 0.75 {
+		t.Fatalf("unrelated sequences have too high an identity: %.3f", id)
+	}
+}
+
+// test Run
+func TestCluster(t *testing.T) {
+	seqs := []*seqio.Sequence{
+		{ID: []byte("a"), Seq: seqA},
+		{ID: []byte("c"), Seq: seqC},
+		{ID: []byte("b"), Seq: seqB},
+	}
+	clusterer, err := NewClusterer(11, 64, 0.9, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := clusterer.Run(seqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 2 {
+		t.Fatalf("expected 2 clusters, got %d", len(clusters))
+	}
+	for _, cluster := range clusters {
+		if cluster[0] == 0 && len(cluster) != 2 {
+			t.Fatal("similar sequences were not clustered together")
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Package ensemble is a static LSH Ensemble that is bootstrapped once, at index time, and then queried directly from its serialised form.
+// It follows the equi-depth partitioning and LSH Forest banding of github.com/ekzhu/lshensemble, but all the partitions and bands are held in a single flat byte slice.
+// This means an index can be loaded without being rebuilt, and the byte slice can be memory-mapped and shared between processes.
+package ensemble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/ekzhu/lshensemble"
+)
+
+// HASH_SIZE is the number of bytes kept from each hash value when building the band keys (32bit, as used by lshensemble.NewLshForest)
+const HASH_SIZE = 4
+
+// the serialised ensemble starts with a header (numHash, maxK, numPart, numKeys) and then a record (lower, upper, numDomains) for each partition
+// this is followed by the bands for each partition, where each band is a sorted array of fixed-size entries (band key, domain index)
+const (
+	headerSize    = 16
+	partitionSize = 12
+)
+
+// Partition is a domain size partition in the ensemble
+type Partition struct {
+	Lower      int
+	Upper      int
+	numDomains int
+	offset     int // the offset of the first band for this partition
+}
+
+// Ensemble is an LSH Ensemble that is queried directly from its serialised form
+type Ensemble struct {
+	Partitions []Partition
+	numHash    int
+	maxK       int
+	numBands   int
+	entrySize  int
+	keys       []uint32
+	data       []byte
+	paramCache sync.Map
+}
+
+// param holds the optimal LSH parameters for a partition
+type param struct {
+	k int
+	l int
+}
+
+// paramKey is used to cache the LSH parameters for a partition, query size and threshold
+type paramKey struct {
+	x int
+	q int
+	t int
+}
+
+// Build is a function to bootstrap an LSH Ensemble using equi-depth partitioning and return it in its serialised form
+// the domain records must be sorted by size and their position in the slice is used as their index in the ensemble
+func Build(numPart, numHash, maxK int, recs []*lshensemble.DomainRecord) ([]byte, error) {
+	if numPart < 1 || maxK < 1 || numHash < maxK {
+		return nil, fmt.Errorf("invalid LSH Ensemble parameters (numPart: %d, numHash: %d, maxK: %d)", numPart, numHash, maxK)
+	}
+	numBands := numHash / maxK
+
+	// assign each domain to a partition, with each partition having approximately the same number of domains
+	partitions := make([]Partition, numPart)
+	partitionIndex := make([]int, len(recs))
+	depth := len(recs) / numPart
+	currDepth, currPart, currSize := 0, 0, 0
+	for i, rec := range recs {
+		if currSize > rec.Size {
+			return nil, fmt.Errorf("domain records must be sorted by size")
+		}
+		if len(rec.Signature) < numBands*maxK {
+			return nil, fmt.Errorf("domain record signature is too short for the LSH Ensemble: %v", rec.Key)
+		}
+		if rec.Size > math.MaxUint32 {
+			return nil, fmt.Errorf("domain record is too large for the LSH Ensemble: %v", rec.Key)
+		}
+		currSize = rec.Size
+		partitionIndex[i] = currPart
+		partitions[currPart].numDomains++
+		currDepth++
+		partitions[currPart].Upper = rec.Size
+		if currDepth >= depth && currPart < numPart-1 {
+			currPart++
+			partitions[currPart].Lower = rec.Size
+			currDepth = 0
+		}
+	}
+
+	// write the header and the partitions
+	data := make([]byte, headerSize+partitionSize*numPart)
+	binary.LittleEndian.PutUint32(data[0:], uint32(numHash))
+	binary.LittleEndian.PutUint32(data[4:], uint32(maxK))
+	binary.LittleEndian.PutUint32(data[8:], uint32(numPart))
+	binary.LittleEndian.PutUint32(data[12:], uint32(len(recs)))
+	for i, partition := range partitions {
+		offset := headerSize + i*partitionSize
+		binary.LittleEndian.PutUint32(data[offset:], uint32(partition.Lower))
+		binary.LittleEndian.PutUint32(data[offset+4:], uint32(partition.Upper))
+		binary.LittleEndian.PutUint32(data[offset+8:], uint32(partition.numDomains))
+	}
+
+	// write the sorted bands for each partition
+	entrySize := maxK*HASH_SIZE + 4
+	for part := range partitions {
+		domains := []int{}
+		for i := range recs {
+			if partitionIndex[i] == part {
+				domains = append(domains, i)
+			}
+		}
+		for band := 0; band < numBands; band++ {
+			entries := make([]byte, len(domains)*entrySize)
+			for i, domain := range domains {
+				entry := entries[i*entrySize : (i+1)*entrySize]
+				putBandKey(entry, recs[domain].Signature[band*maxK:(band+1)*maxK])
+				binary.LittleEndian.PutUint32(entry[maxK*HASH_SIZE:], uint32(domain))
+			}
+			sort.Sort(&entrySorter{entries: entries, entrySize: entrySize, keySize: maxK * HASH_SIZE})
+			data = append(data, entries...)
+		}
+	}
+	return data, nil
+}
+
+// Open is a function to query a serialised LSH Ensemble, which is not copied
+// keys holds the numeric key (e.g. a window ID) for each domain index, in the order the domain records were given to Build
+func Open(data []byte, keys []uint32) (*Ensemble, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("LSH Ensemble is truncated")
+	}
+	ensemble := &Ensemble{
+		numHash: int(binary.LittleEndian.Uint32(data[0:])),
+		maxK:    int(binary.LittleEndian.Uint32(data[4:])),
+		keys:    keys,
+		data:    data,
+	}
+	numPart := int(binary.LittleEndian.Uint32(data[8:]))
+	numKeys := int(binary.LittleEndian.Uint32(data[12:]))
+	if ensemble.maxK < 1 || ensemble.numHash < ensemble.maxK {
+		return nil, fmt.Errorf("LSH Ensemble has invalid parameters (numHash: %d, maxK: %d)", ensemble.numHash, ensemble.maxK)
+	}
+	if numKeys != len(keys) {
+		return nil, fmt.Errorf("LSH Ensemble holds %d domains but %d keys were provided", numKeys, len(keys))
+	}
+	ensemble.numBands = ensemble.numHash / ensemble.maxK
+	ensemble.entrySize = ensemble.maxK*HASH_SIZE + 4
+	if len(data) < headerSize+partitionSize*numPart {
+		return nil, fmt.Errorf("LSH Ensemble is truncated")
+	}
+	ensemble.Partitions = make([]Partition, numPart)
+	offset := headerSize + partitionSize*numPart
+	for i := range ensemble.Partitions {
+		partition := data[headerSize+i*partitionSize:]
+		ensemble.Partitions[i] = Partition{
+			Lower:      int(binary.LittleEndian.Uint32(partition[0:])),
+			Upper:      int(binary.LittleEndian.Uint32(partition[4:])),
+			numDomains: int(binary.LittleEndian.Uint32(partition[8:])),
+			offset:     offset,
+		}
+		offset += ensemble.Partitions[i].numDomains * ensemble.numBands * ensemble.entrySize
+	}
+	if offset != len(data) {
+		return nil, fmt.Errorf("LSH Ensemble has the wrong size (expected %d bytes, found %d)", offset, len(data))
+	}
+	return ensemble, nil
+}
+
+// NumDomains is a method to return the number of domains held in the ensemble
+func (Ensemble *Ensemble) NumDomains() int {
+	return len(Ensemble.keys)
+}
+
+// Query is a method to return the keys of the candidate domains for a query signature, the query domain size and a containment threshold
+func (Ensemble *Ensemble) Query(sig []uint64, size int, threshold float64) ([]uint32, error) {
+	return Ensemble.QueryRange(sig, size, threshold, 0, math.MaxInt32)
+}
+
+// QueryRange is a method to query only the partitions holding domains with sizes between lower and upper (inclusive)
+// candidates from domains outside the range can still be returned if they share a partition with domains in the range
+func (Ensemble *Ensemble) QueryRange(sig []uint64, size int, threshold float64, lower, upper int) ([]uint32, error) {
+	if len(sig) < Ensemble.numBands*Ensemble.maxK {
+		return nil, fmt.Errorf("query signature is too short for the LSH Ensemble")
+	}
+	results := []uint32{}
+	seen := make(map[uint32]struct{})
+	bandKey := make([]byte, Ensemble.maxK*HASH_SIZE)
+	for _, partition := range Ensemble.Partitions {
+		if partition.numDomains == 0 || partition.Upper < lower || partition.Lower > upper {
+			continue
+		}
+		x := partition.Upper
+		if x > upper {
+			x = upper
+		}
+		params := Ensemble.getParams(x, size, threshold)
+		prefixSize := params.k * HASH_SIZE
+		for band := 0; band < params.l; band++ {
+			putBandKey(bandKey, sig[band*Ensemble.maxK:band*Ensemble.maxK+params.k])
+			prefix := bandKey[:prefixSize]
+			entries := Ensemble.getBand(partition, band)
+			first := sort.Search(partition.numDomains, func(i int) bool {
+				return bytes.Compare(entries[i*Ensemble.entrySize:i*Ensemble.entrySize+prefixSize], prefix) >= 0
+			})
+			for i := first; i < partition.numDomains; i++ {
+				entry := entries[i*Ensemble.entrySize : (i+1)*Ensemble.entrySize]
+				if !bytes.Equal(entry[:prefixSize], prefix) {
+					break
+				}
+				domain := binary.LittleEndian.Uint32(entry[Ensemble.maxK*HASH_SIZE:])
+				if _, ok := seen[domain]; ok {
+					continue
+				}
+				seen[domain] = struct{}{}
+				results = append(results, Ensemble.keys[domain])
+			}
+		}
+	}
+	return results, nil
+}
+
+// getBand is a method to return the sorted entries for one band of a partition
+func (Ensemble *Ensemble) getBand(partition Partition, band int) []byte {
+	bandSize := partition.numDomains * Ensemble.entrySize
+	return Ensemble.data[partition.offset+band*bandSize : partition.offset+(band+1)*bandSize]
+}
+
+// getParams is a method to return the optimal LSH parameters for a partition, caching the result
+func (Ensemble *Ensemble) getParams(x, q int, t float64) param {
+	key := paramKey{x: x, q: q, t: int(math.Round(t * 100))}
+	if cached, ok := Ensemble.paramCache.Load(key); ok {
+		return cached.(param)
+	}
+	k, l, _, _ := optimalKL(x, q, t, Ensemble.maxK, Ensemble.numBands)
+	computed := param{k: k, l: l}
+	Ensemble.paramCache.Store(key, computed)
+	return computed
+}
+
+// putBandKey is a function to write the truncated hash values of a signature band as a band key
+func putBandKey(dst []byte, sig []uint64) {
+	buf := make([]byte, 8)
+	for i, v := range sig {
+		binary.LittleEndian.PutUint64(buf, v)
+		copy(dst[i*HASH_SIZE:(i+1)*HASH_SIZE], buf[:HASH_SIZE])
+	}
+}
+
+// entrySorter sorts the fixed-size entries of a band by their band key, then by domain index
+type entrySorter struct {
+	entries   []byte
+	entrySize int
+	keySize   int
+	tmp       []byte
+}
+
+func (es *entrySorter) Len() int { return len(es.entries) / es.entrySize }
+func (es *entrySorter) Less(i, j int) bool {
+	a := es.entries[i*es.entrySize : (i+1)*es.entrySize]
+	b := es.entries[j*es.entrySize : (j+1)*es.entrySize]
+	if c := bytes.Compare(a[:es.keySize], b[:es.keySize]); c != 0 {
+		return c < 0
+	}
+	return binary.LittleEndian.Uint32(a[es.keySize:]) < binary.LittleEndian.Uint32(b[es.keySize:])
+}
+func (es *entrySorter) Swap(i, j int) {
+	if es.tmp == nil {
+		es.tmp = make([]byte, es.entrySize)
+	}
+	a := es.entries[i*es.entrySize : (i+1)*es.entrySize]
+	b := es.entries[j*es.entrySize : (j+1)*es.entrySize]
+	copy(es.tmp, a)
+	copy(a, b)
+	copy(b, es.tmp)
+}
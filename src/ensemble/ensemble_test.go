@@ -0,0 +1,146 @@
+package ensemble
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ekzhu/lshensemble"
+)
+
+var (
+	numPart = 4
+	numHash = 42
+	maxK    = 4
+)
+
+// makeRecords returns domain records sorted by size, where every other record shares most of its signature with the previous one
+func makeRecords(n int) []*lshensemble.DomainRecord {
+	r := rand.New(rand.NewSource(42))
+	recs := make([]*lshensemble.DomainRecord, n)
+	for i := range recs {
+		sig := make([]uint64, numHash)
+		for j := range sig {
+			sig[j] = r.Uint64()
+		}
+		if i%2 == 1 {
+			copy(sig[numHash/2:], recs[i-1].Signature[numHash/2:])
+		}
+		recs[i] = &lshensemble.DomainRecord{Key: uint32(i), Size: 100 + i/10, Signature: sig}
+	}
+	return recs
+}
+
+// test that the serialised ensemble returns the same candidates as lshensemble
+func TestQuery(t *testing.T) {
+	recs := makeRecords(200)
+	data, err := Build(numPart, numHash, maxK, recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]uint32, len(recs))
+	for i, rec := range recs {
+		keys[i] = rec.Key.(uint32)
+	}
+	ensemble, err := Open(data, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ensemble.NumDomains() != len(recs) {
+		t.Fatal("wrong number of domains in ensemble")
+	}
+	lshe, err := lshensemble.BootstrapLshEnsembleEquiDepth(numPart, numHash, maxK, len(recs), lshensemble.Recs2Chan(recs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range lshe.Partitions {
+		if lshe.Partitions[i].Lower != ensemble.Partitions[i].Lower || lshe.Partitions[i].Upper != ensemble.Partitions[i].Upper {
+			t.Fatalf("partition %d does not match lshensemble", i)
+		}
+	}
+	for _, threshold := range []float64{0.5, 0.9} {
+		for _, query := range recs[:20] {
+			results, err := ensemble.Query(query.Signature, query.Size, threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, _ := lshe.QueryTimed(query.Signature, query.Size, threshold)
+			if len(results) != len(expected) {
+				t.Fatalf("query returned %d candidates, lshensemble returned %d", len(results), len(expected))
+			}
+			found := false
+			for _, result := range results {
+				if result == query.Key {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatal("query did not return itself as a candidate")
+			}
+		}
+	}
+}
+
+// test that a range query only returns candidates from the partitions holding domains in the range
+func TestQueryRange(t *testing.T) {
+	recs := makeRecords(200)
+	data, err := Build(numPart, numHash, maxK, recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]uint32, len(recs))
+	sizes := make(map[uint32]int, len(recs))
+	for i, rec := range recs {
+		keys[i] = rec.Key.(uint32)
+		sizes[keys[i]] = rec.Size
+	}
+	ensemble, err := Open(data, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallest, largest := recs[0], recs[len(recs)-1]
+	results, err := ensemble.QueryRange(smallest.Signature, smallest.Size, 0.5, smallest.Size, smallest.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, result := range results {
+		if result == smallest.Key {
+			found = true
+		}
+		if sizes[result] > ensemble.Partitions[0].Upper {
+			t.Fatalf("range query returned a domain from outside the range: %v (size %d)", result, sizes[result])
+		}
+	}
+	if !found {
+		t.Fatal("range query did not return itself as a candidate")
+	}
+	results, err = ensemble.QueryRange(largest.Signature, largest.Size, 0.5, smallest.Size, smallest.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result == largest.Key {
+			t.Fatal("range query returned a domain from a partition outside the range")
+		}
+	}
+}
+
+// test that bad input is rejected
+func TestErrors(t *testing.T) {
+	recs := makeRecords(10)
+	recs[0].Size = 1000
+	if _, err := Build(numPart, numHash, maxK, recs); err == nil {
+		t.Fatal("unsorted domain records should be rejected")
+	}
+	recs = makeRecords(10)
+	data, err := Build(numPart, numHash, maxK, recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(data[:len(data)-1], make([]uint32, 10)); err == nil {
+		t.Fatal("truncated ensemble should be rejected")
+	}
+	if _, err := Open(data, make([]uint32, 9)); err == nil {
+		t.Fatal("wrong number of keys should be rejected")
+	}
+}
@@ -0,0 +1,71 @@
+package ensemble
+
+import "math"
+
+// the following funcs are taken from https://github.com/ekzhu/lshensemble
+
+// optimalKL returns the optimal K and L for containment search, as well as the false positive and negative probabilities, where x is the indexed domain size, q is the query domain size and t is the containment threshold.
+func optimalKL(x, q int, t float64, maxK, numBands int) (int, int, float64, float64) {
+	optimumK, optimumL := 0, 0
+	fp, fn := 0.0, 0.0
+	minError := math.MaxFloat64
+	for l := 1; l <= numBands; l++ {
+		for k := 1; k <= maxK; k++ {
+			currFp := probFalsePositive(x, q, l, k, t, 0.01)
+			currFn := probFalseNegative(x, q, l, k, t, 0.01)
+			currErr := currFn + currFp
+			if minError > currErr {
+				minError = currErr
+				optimumK = k
+				optimumL = l
+				fp = currFp
+				fn = currFn
+			}
+		}
+	}
+	return optimumK, optimumL, fp, fn
+}
+
+// integral of function f, lower limit a, upper limit l, and precision defined as the quantize step
+func integral(f func(float64) float64, a, b, precision float64) float64 {
+	var area float64
+	for x := a; x < b; x += precision {
+		area += f(x+0.5*precision) * precision
+	}
+	return area
+}
+
+// falsePositive is the probability density function for false positive
+func falsePositive(x, q, l, k int) func(float64) float64 {
+	return func(t float64) float64 {
+		return 1.0 - math.Pow(1.0-math.Pow(t/(1.0+float64(x)/float64(q)-t), float64(k)), float64(l))
+	}
+}
+
+// falseNegative is the probability density function for false negative
+func falseNegative(x, q, l, k int) func(float64) float64 {
+	return func(t float64) float64 {
+		return 1.0 - (1.0 - math.Pow(1.0-math.Pow(t/(1.0+float64(x)/float64(q)-t), float64(k)), float64(l)))
+	}
+}
+
+// probFalseNegative to compute the cummulative probability of false negative given threshold t
+func probFalseNegative(x, q, l, k int, t, precision float64) float64 {
+	xq := float64(x) / float64(q)
+	if xq >= 1.0 {
+		return integral(falseNegative(x, q, l, k), t, 1.0, precision)
+	}
+	if xq >= t {
+		return integral(falseNegative(x, q, l, k), t, xq, precision)
+	}
+	return 0.0
+}
+
+// probFalsePositive to compute the cummulative probability of false positive given threshold t
+func probFalsePositive(x, q, l, k int, t, precision float64) float64 {
+	xq := float64(x) / float64(q)
+	if xq >= t {
+		return integral(falsePositive(x, q, l, k), 0.0, t, precision)
+	}
+	return integral(falsePositive(x, q, l, k), 0.0, xq, precision)
+}
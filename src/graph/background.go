@@ -0,0 +1,171 @@
+package graph
+
+import (
+	"math"
+	"sort"
+
+	"github.com/will-rowe/baby-groot/src/minhash"
+	"github.com/will-rowe/baby-groot/src/seqio"
+)
+
+// Background holds the canonical k-mers of a set of background sequences (e.g. housekeeping genes or plasmid backbones), so that graph windows dominated by them can be left out of an index
+type Background struct {
+	KmerSize int
+	kmers    map[uint64]struct{}
+}
+
+// BackgroundRegion is a stretch of a path whose windows are dominated by background k-mers
+type BackgroundRegion struct {
+	PathID     uint32
+	WindowSize int
+	Start      int // the start of the first background window in the region
+	End        int // the end of the last background window in the region
+	Windows    int // the number of background windows in the region
+}
+
+// NewBackground is the constructor, which collects the canonical k-mers of the background sequences (k-mers containing an N are skipped)
+func NewBackground(sequences [][]byte, kmerSize int) *Background {
+	Background := &Background{
+		KmerSize: kmerSize,
+		kmers:    make(map[uint64]struct{}),
+	}
+	for _, sequence := range sequences {
+		for _, hash := range minhash.KmerHashes(sequence, uint(kmerSize)) {
+			if hash != math.MaxUint64 {
+				Background.kmers[hash] = struct{}{}
+			}
+		}
+	}
+	return Background
+}
+
+// NewBackgroundFromKmers is a constructor to rebuild a background from its k-mers (see Background.Kmers)
+func NewBackgroundFromKmers(kmers []uint64, kmerSize int) *Background {
+	Background := &Background{
+		KmerSize: kmerSize,
+		kmers:    make(map[uint64]struct{}, len(kmers)),
+	}
+	for _, hash := range kmers {
+		Background.kmers[hash] = struct{}{}
+	}
+	return Background
+}
+
+// Kmers is a method to return the background k-mers, sorted so that they can be saved with an index
+func (Background *Background) Kmers() []uint64 {
+	kmers := make([]uint64, 0, len(Background.kmers))
+	for hash := range Background.kmers {
+		kmers = append(kmers, hash)
+	}
+	sort.Slice(kmers, func(i, j int) bool { return kmers[i] < kmers[j] })
+	return kmers
+}
+
+// NumKmers is a method to return the number of distinct k-mers in the background
+func (Background *Background) NumKmers() int {
+	return len(Background.kmers)
+}
+
+// Contains is a method to check if a canonical k-mer hash is in the background
+func (Background *Background) Contains(hash uint64) bool {
+	_, ok := Background.kmers[hash]
+	return ok
+}
+
+// Fraction is a method to return the proportion of the k-mers in a sequence that are in the background (masked k-mers are not counted)
+func (Background *Background) Fraction(sequence []byte) float64 {
+	total, found := 0, 0
+	for _, hash := range minhash.KmerHashes(sequence, uint(Background.KmerSize)) {
+		if hash == math.MaxUint64 {
+			continue
+		}
+		total++
+		if Background.Contains(hash) {
+			found++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(found) / float64(total)
+}
+
+// backgroundWindows is a function to flag the windows of a path sequence that are dominated by background k-mers, returning nil if there is no background
+// a window is flagged if at least opts.MaxBackground of its unmasked k-mers are in the background
+func backgroundWindows(sequence []byte, numWindows int, opts *WindowOptions) []bool {
+	if opts.Background == nil || numWindows < 1 {
+		return nil
+	}
+
+	// count the unmasked and background k-mers up to each position, so that each window is counted in constant time
+	hashes := minhash.KmerHashes(sequence, uint(opts.KmerSize))
+	valid := make([]int, len(hashes)+1)
+	found := make([]int, len(hashes)+1)
+	for i, hash := range hashes {
+		valid[i+1], found[i+1] = valid[i], found[i]
+		if hash == math.MaxUint64 {
+			continue
+		}
+		valid[i+1]++
+		if opts.Background.Contains(hash) {
+			found[i+1]++
+		}
+	}
+	flagged := make([]bool, numWindows)
+	kmersPerWindow := opts.WindowSize - opts.KmerSize + 1
+	for i := 0; i < numWindows; i++ {
+		end := i + kmersPerWindow
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		total := valid[end] - valid[i]
+		if total == 0 {
+			continue
+		}
+		flagged[i] = float64(found[end]-found[i]) >= opts.MaxBackground*float64(total)
+	}
+	return flagged
+}
+
+// BackgroundRegions is a method to find the stretches of each path whose windows are dominated by background k-mers, which are the windows that WindowGraph leaves out
+// overlapping background windows are combined into a single region, and the regions are returned in path order (windows are counted before any stride or minimizer selection)
+func (GrootGraph *GrootGraph) BackgroundRegions(opts *WindowOptions) ([]BackgroundRegion, error) {
+	if opts.Background == nil {
+		return nil, nil
+	}
+	pathSeqs, err := GrootGraph.Graph2Seqs()
+	if err != nil {
+		return nil, err
+	}
+	pathIDs := make([]uint32, 0, len(pathSeqs))
+	for pathID := range pathSeqs {
+		pathIDs = append(pathIDs, pathID)
+	}
+	sort.Slice(pathIDs, func(i, j int) bool { return pathIDs[i] < pathIDs[j] })
+	regions := []BackgroundRegion{}
+	for _, pathID := range pathIDs {
+		sequence := pathSeqs[pathID]
+		if opts.DustLevel > 0 {
+			sequence, _ = seqio.DustMask(sequence, opts.DustLevel)
+		}
+		var region *BackgroundRegion
+		for i, flagged := range backgroundWindows(sequence, len(sequence)-opts.WindowSize+1, opts) {
+			if !flagged {
+				continue
+			}
+			if region != nil && i <= region.End {
+				region.End = i + opts.WindowSize
+				region.Windows++
+				continue
+			}
+			if region != nil {
+				regions = append(regions, *region)
+			}
+			region = &BackgroundRegion{PathID: pathID, WindowSize: opts.WindowSize, Start: i, End: i + opts.WindowSize, Windows: 1}
+		}
+		if region != nil {
+			regions = append(regions, *region)
+		}
+	}
+	return regions, nil
+}
@@ -0,0 +1,91 @@
+package graph
+
+import (
+	"math"
+	"sort"
+
+	"github.com/will-rowe/baby-groot/src/minhash"
+)
+
+// CoarseIndex relates the k-mers sampled from each graph (a scaled sketch, which keeps the k-mers with hashes below a maximum) to the graphs that hold them
+// it is used to choose the graphs that a read could map to, so that only the windows from these graphs need to be queried
+type CoarseIndex struct {
+	kmerSize int
+	maxHash  uint64
+	lookup   map[uint64][]uint32
+}
+
+// CoarseSketch is a function to sample roughly 1 in scale of the k-mer hashes (see GrootGraph.KmerHashes), keeping those below the maximum hash for the scale
+func CoarseSketch(hashes []uint64, kmerSize, scale int) []uint64 {
+	maxHash := coarseMaxHash(kmerSize, scale)
+	sketch := []uint64{}
+	for _, hash := range hashes {
+		if hash < maxHash {
+			sketch = append(sketch, hash)
+		}
+	}
+	return sketch
+}
+
+// coarseMaxHash is a function to return the maximum hash kept in a coarse sketch at a scale, given that the k-mer hashes have 2 bits per base
+func coarseMaxHash(kmerSize, scale int) uint64 {
+	if scale < 1 {
+		scale = 1
+	}
+	return ((uint64(1) << uint64(2*kmerSize)) - 1) / uint64(scale)
+}
+
+// NewCoarseIndex is the constructor, taking the coarse sketch of each graph
+func NewCoarseIndex(sketches map[uint32][]uint64, kmerSize, scale int) *CoarseIndex {
+	CoarseIndex := &CoarseIndex{
+		kmerSize: kmerSize,
+		maxHash:  coarseMaxHash(kmerSize, scale),
+		lookup:   make(map[uint64][]uint32),
+	}
+	for graphID, sketch := range sketches {
+		for _, hash := range sketch {
+			CoarseIndex.lookup[hash] = append(CoarseIndex.lookup[hash], graphID)
+		}
+	}
+	return CoarseIndex
+}
+
+// Query is a method to return the graphs that hold at least minContainment of the sampled k-mers from a read, sorted by graphID
+// the bool is false if none of the k-mers in the read were sampled, in which case the coarse index can't choose the graphs
+func (CoarseIndex *CoarseIndex) Query(read []byte, minContainment float64) ([]uint32, bool) {
+	sampled := []uint64{}
+	for _, hash := range minhash.KmerHashes(read, uint(CoarseIndex.kmerSize)) {
+		if hash < CoarseIndex.maxHash {
+			sampled = append(sampled, hash)
+		}
+	}
+	if len(sampled) == 0 {
+		return nil, false
+	}
+
+	// count the graphs holding each distinct sampled k-mer
+	sort.Slice(sampled, func(i, j int) bool { return sampled[i] < sampled[j] })
+	numSampled := 0
+	counts := make(map[uint32]int)
+	for i, hash := range sampled {
+		if i > 0 && hash == sampled[i-1] {
+			continue
+		}
+		numSampled++
+		for _, graphID := range CoarseIndex.lookup[hash] {
+			counts[graphID]++
+		}
+	}
+	minShared := int(math.Ceil(minContainment * float64(numSampled)))
+	if minShared < 1 {
+		minShared = 1
+	}
+	graphIDs := []uint32{}
+	for graphID, count := range counts {
+		if count >= minShared {
+			graphIDs = append(graphIDs, graphID)
+		}
+	}
+	sort.Slice(graphIDs, func(i, j int) bool { return graphIDs[i] < graphIDs[j] })
+	return graphIDs, true
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 
 	"github.com/will-rowe/baby-groot/src/lshforest"
+	"github.com/will-rowe/baby-groot/src/minhash"
 	"github.com/will-rowe/baby-groot/src/seqio"
 	"github.com/will-rowe/gfa"
 )
@@ -22,6 +23,8 @@ type GrootGraph struct {
 	Lengths      map[uint32]int     // lengths of sequences held in graph (lookup key corresponds to key in Paths)
 	NodeLookup   map[uint64]int     // this map returns a the position of a node in the SortedNodes array, using the node segmentID as the locator
 	KmerTotal    uint64             // the total number of k-mers projected onto the graph
+	StrandKmers  [2]uint64          // the number of k-mers projected onto the graph from reads on the forward and reverse strand of its paths (stranded indexes only)
+	SharedKmers  uint64             // the number of k-mers projected onto the graph from windows shared with other graphs
 	EMiterations int                // the number of EM iterations ran
 	alpha        []float64          // indices match the Paths
 	abundances   map[uint32]float64 // abundances of kept paths, relative to total k-mers processed during sketching
@@ -91,8 +94,14 @@ func CreateGrootGraph(gfaInstance *gfa.GFA, id int) (*GrootGraph, error) {
 		if err != nil {
 			return nil, fmt.Errorf("could not convert toSegID name from GFA into an int for groot graph: %v", link.To)
 		}
+		if _, ok := newGraph.NodeLookup[uint64(toSegID)]; !ok {
+			return nil, fmt.Errorf("link references a segment that is not in the graph: %v", string(link.To))
+		}
 		// add the outEdges
-		nodeLocator := newGraph.NodeLookup[uint64(fromSegID)]
+		nodeLocator, ok := newGraph.NodeLookup[uint64(fromSegID)]
+		if !ok {
+			return nil, fmt.Errorf("link references a segment that is not in the graph: %v", string(link.From))
+		}
 		newGraph.SortedNodes[nodeLocator].OutEdges = append(newGraph.SortedNodes[nodeLocator].OutEdges, uint64(toSegID))
 	}
 	// collect all the paths from the GFA instance and add pathIDs to each node
@@ -110,7 +119,10 @@ func CreateGrootGraph(gfaInstance *gfa.GFA, id int) (*GrootGraph, error) {
 			if err != nil {
 				return nil, fmt.Errorf("could not convert segment name from GFA path into an int for groot graph: %v\n%v", string(seg), string(paths[pathIterator].PathName))
 			}
-			nodeLocator := newGraph.NodeLookup[uint64(segID)]
+			nodeLocator, ok := newGraph.NodeLookup[uint64(segID)]
+			if !ok {
+				return nil, fmt.Errorf("path references a segment that is not in the graph: %v\n%v", string(seg), string(paths[pathIterator].PathName))
+			}
 			newGraph.SortedNodes[nodeLocator].PathIDs = append(newGraph.SortedNodes[nodeLocator].PathIDs, pathIterator)
 			// add the first segment of this path to the start nodes
 			//if i == 0 {
@@ -120,7 +132,9 @@ func CreateGrootGraph(gfaInstance *gfa.GFA, id int) (*GrootGraph, error) {
 	}
 	// return without toposort if only one node present (graph with single sequence)
 	if len(newGraph.SortedNodes) > 1 {
-		err = newGraph.topoSort()
+		if err := newGraph.topoSort(); err != nil {
+			return nil, err
+		}
 	}
 	// get and store the lengths of each sequence held in the graph
 	seqs, err := newGraph.Graph2Seqs()
@@ -163,17 +177,30 @@ func (GrootGraph *GrootGraph) topoSort() error {
 	// run the topological sort  - try starting from each node that was in the first slot of the nodeholder (start of the MSA)
 	seen := make(map[uint64]struct{})
 	for len(nodeMap) > 1 {
+		remaining := len(nodeMap)
 		for _, start := range toposortStart {
 			if _, ok := nodeMap[start]; !ok {
 				continue
 			}
 			GrootGraph.traverse(nodeMap[start], nodeMap, seen)
 		}
+		// stop if the remaining nodes can't be reached from any start node
+		if len(nodeMap) == remaining {
+			break
+		}
 	}
 	// check all traversals have been taken
 	if len(nodeMap) > 0 {
 		return fmt.Errorf("topological sort failed - too many nodes remaining in the pre-sort list")
 	}
+	// check that every edge now points forward in the sorted nodes (i.e. the graph is acyclic)
+	for i, node := range GrootGraph.SortedNodes {
+		for _, outEdge := range node.OutEdges {
+			if GrootGraph.NodeLookup[outEdge] <= i {
+				return fmt.Errorf("topological sort failed - graph contains a cycle (segment %d -> %d)", node.SegmentID, outEdge)
+			}
+		}
+	}
 	return nil
 }
 
@@ -206,121 +233,315 @@ func (GrootGraph *GrootGraph) traverse(node *GrootGraphNode, nodeMap map[uint64]
 }
 
 // WindowGraph is a method to slide a window over each path through the graph, sketching the paths and getting window information
-func (GrootGraph *GrootGraph) WindowGraph(windowSize, kmerSize, sketchSize int) chan *lshforest.Key {
-	// get the linear sequences for this graph
-	pathSeqs, err := GrootGraph.Graph2Seqs()
+// the paths are windowed by a pool of opts.NumWorkers go routines and the windows are sent as they are made, so identical windows are not combined (see WindowMerger)
+// see WindowJobs for how the paths are windowed
+func (GrootGraph *GrootGraph) WindowGraph(opts *WindowOptions) chan *lshforest.Key {
+
+	// this method returns a channel, which receives windows as they are made
+	windowChan := make(chan *lshforest.Key)
+	jobs, err := GrootGraph.WindowJobs(opts, func(window *lshforest.Key) {
+		windowChan <- window
+	})
 	if err != nil {
 		panic(err)
 	}
+	jobQueue := make(chan func(), len(jobs))
+	for _, job := range jobs {
+		jobQueue <- job
+	}
+	close(jobQueue)
 
-	// this method returns a channel, which receives windows as they are made
-	windowChan := make(chan *lshforest.Key)
+	// run the jobs with the worker pool
+	numWorkers := opts.NumWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
 	var wg sync.WaitGroup
-	wg.Add(len(GrootGraph.Paths))
-
-	// window each path
-	for pathID := range GrootGraph.Paths {
-		go func(pathID uint32) {
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
 			defer wg.Done()
-			// get the length of the linear reference for this path
-			pathLength := GrootGraph.Lengths[pathID]
-
-			// for each base in the linear reference sequence, get the segmentID and offset of its location in the graph
-			segs := make([]uint64, pathLength, pathLength)
-			offSets := make([]uint32, pathLength, pathLength)
-			iterator := 0
-			for _, node := range GrootGraph.SortedNodes {
-				for _, id := range node.PathIDs {
-					if id == pathID {
-						for offset := uint32(0); offset < uint32(len(node.Sequence)); offset++ {
-							segs[iterator] = node.SegmentID
-							offSets[iterator] = offset
-							iterator++
-						}
+			for job := range jobQueue {
+				job()
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(windowChan)
+	}()
+	return windowChan
+}
+
+// WindowJobs is a method to split the windowing of the graph into jobs, one for each path plus one for the traversals, which call send with each window as it is made
+// the jobs can be run concurrently (e.g. by a worker pool shared by several graphs), so send must be safe to call from several go routines
+// each path is sketched with a sliding window (see minhash.KHFslider), so that each k-mer is only hashed once
+// if opts.Stranded is true, the forward k-mers of each window and of its reverse complement are sketched separately, giving a window for each strand
+// if opts.DustLevel is greater than 0, low-complexity regions of the paths are masked before windowing, and windows without any unmasked k-mers are skipped
+// if opts.Stride is greater than 1, only every Stride-th window of each path is kept, or the windows anchored by a minimizer if opts.Minimizers is set
+// if opts.MaxTraversals is greater than 0, every traversal of the graph is also windowed (up to MaxTraversals from each window start), so that combinations of bubbles not seen in the paths are sketched
+// if opts.Background is set, windows where at least opts.MaxBackground of the k-mers are background k-mers are skipped (see BackgroundRegions)
+func (GrootGraph *GrootGraph) WindowJobs(opts *WindowOptions, send func(*lshforest.Key)) ([]func(), error) {
+	// get the linear sequences for this graph
+	pathSeqs, err := GrootGraph.Graph2Seqs()
+	if err != nil {
+		return nil, err
+	}
+	windowSize, kmerSize := opts.WindowSize, opts.KmerSize
+
+	// sendWindow sends a sketched window, along with a window for the reverse strand if stranded
+	sendWindow := func(window *lshforest.Key, rcSketch []uint64) {
+		send(window)
+		if !opts.Stranded {
+			return
+		}
+
+		// the reverse strand window covers the same nodes
+		rcNodes := make(map[uint64]float64, len(window.ContainedNodes))
+		for node, count := range window.ContainedNodes {
+			rcNodes[node] = count
+		}
+		send(&lshforest.Key{
+			GraphID:        window.GraphID,
+			Node:           window.Node,
+			OffSet:         window.OffSet,
+			ContainedNodes: rcNodes,
+			Ref:            append([]uint32{}, window.Ref...),
+			Sketch:         rcSketch,
+			WindowSize:     window.WindowSize,
+			RC:             true,
+		})
+	}
+
+	// windowPath windows a single path
+	windowPath := func(pathID uint32) {
+		// get the length of the linear reference for this path
+		pathLength := GrootGraph.Lengths[pathID]
+
+		// for each base in the linear reference sequence, get the segmentID and offset of its location in the graph
+		segs := make([]uint64, pathLength, pathLength)
+		offSets := make([]uint32, pathLength, pathLength)
+		iterator := 0
+		for _, node := range GrootGraph.SortedNodes {
+			for _, id := range node.PathIDs {
+				if id == pathID {
+					for offset := uint32(0); offset < uint32(len(node.Sequence)); offset++ {
+						segs[iterator] = node.SegmentID
+						offSets[iterator] = offset
+						iterator++
 					}
 				}
 			}
+		}
 
-			// get the sequence for this path
-			sequence := pathSeqs[pathID]
+		// get the sequence for this path, masking any low-complexity regions
+		sequence := pathSeqs[pathID]
+		if opts.DustLevel > 0 {
+			sequence, _ = seqio.DustMask(sequence, opts.DustLevel)
+		}
 
-			// window the sequence
-			numWindows := pathLength - windowSize + 1
-			for i := 0; i < numWindows; i++ {
+		// if stranded, sketch the windows of the reverse strand first (these are in reverse order to the forward windows)
+		numWindows := pathLength - windowSize + 1
+		var rcSketches [][]uint64
+		if opts.Stranded && numWindows > 0 {
+			rcSketches = make([][]uint64, numWindows)
+			err := minhash.NewKHFslider(uint(kmerSize), uint(opts.SketchSize), uint(windowSize), true).SkipMaskedKmers(opts.DustLevel > 0).SketchWindows(seqio.ReverseComplement(sequence), func(start int, sketch []uint64, numKmers int) {
+				rcSketches[numWindows-1-start] = sketch
+			})
+			if err != nil {
+				panic(err)
+			}
+		}
 
-				// sketch the window
-				windowSeq := seqio.Sequence{Seq: sequence[i : i+windowSize]}
-				sketch, err := windowSeq.RunMinHash(kmerSize, sketchSize, false, nil)
-				if err != nil {
-					panic(err)
-				}
+		// slide the window along the sequence, sketching each window as it goes and skipping those that aren't selected
+		keep := selectWindows(sequence, numWindows, opts)
+		background := backgroundWindows(sequence, numWindows, opts)
+		err := minhash.NewKHFslider(uint(kmerSize), uint(opts.SketchSize), uint(windowSize), opts.Stranded).SkipMaskedKmers(opts.DustLevel > 0).SketchWindows(sequence, func(i int, sketch []uint64, numKmers int) {
 
-				// get the nodes in this window (i.e. the graph subpath)
-				subPath := segs[i : i+windowSize]
+			// skip the window if it has been masked
+			if opts.DustLevel > 0 && numKmers == 0 {
+				return
+			}
+			if keep != nil && !keep[i] {
+				return
+			}
+			if background != nil && background[i] {
+				return
+			}
 
-				// convert the subPath to a map of contained nodes in this window
-				ContainedNodes := make(map[uint64]float64)
-				for _, y := range subPath {
-					ContainedNodes[uint64(y)]++
-				}
+			// get the nodes in this window (i.e. the graph subpath)
+			subPath := segs[i : i+windowSize]
 
-				// populate the window struct
-				newWindow := &lshforest.Key{
-					GraphID:        GrootGraph.GraphID,
-					Node:           segs[i],
-					OffSet:         offSets[i],
-					ContainedNodes: ContainedNodes,
-					Ref:            []uint32{pathID},
-					Sketch:         sketch,
-				}
+			// convert the subPath to a map of contained nodes in this window
+			ContainedNodes := make(map[uint64]float64)
+			for _, y := range subPath {
+				ContainedNodes[uint64(y)]++
+			}
 
-				// send this window
-				windowChan <- newWindow
+			// populate the window struct and send it
+			var rcSketch []uint64
+			if opts.Stranded {
+				rcSketch = rcSketches[i]
 			}
-		}(pathID)
+			sendWindow(&lshforest.Key{
+				GraphID:        GrootGraph.GraphID,
+				Node:           segs[i],
+				OffSet:         offSets[i],
+				ContainedNodes: ContainedNodes,
+				Ref:            []uint32{pathID},
+				Sketch:         sketch,
+				WindowSize:     uint32(windowSize),
+			}, rcSketch)
+		})
+		if err != nil {
+			panic(err)
+		}
 	}
-	go func() {
-		wg.Wait()
-		close(windowChan)
-	}()
 
-	// receive each window from the graph, combining windows with the same sketch
-	sketchSeen := make(map[string]*lshforest.Key)
-	for window := range windowChan {
-		stringifiedSketch := lshforest.CompressSketch2String(window.Sketch)
+	// make a job for each path, plus one to window the traversals that are not already covered by a path
+	jobs := make([]func(), 0, len(GrootGraph.Paths)+1)
+	for pathID := range GrootGraph.Paths {
+		pathID := pathID
+		jobs = append(jobs, func() { windowPath(pathID) })
+	}
+	if opts.MaxTraversals > 0 {
+		jobs = append(jobs, func() {
+			GrootGraph.windowTraversals(windowSize, opts.MaxTraversals, func(window *lshforest.Key, seq []byte) {
+				if opts.DustLevel > 0 {
+					seq, _ = seqio.DustMask(seq, opts.DustLevel)
+					if seqio.UnmaskedKmers(seq, kmerSize) == 0 {
+						return
+					}
+				}
+				if opts.Background != nil && opts.Background.Fraction(seq) >= opts.MaxBackground {
+					return
+				}
 
-		// identical sketch found, so combine windows
-		if existingWindow, ok := sketchSeen[stringifiedSketch]; ok {
+				// each traversal window is sketched on its own, as the traversals from a window start don't share a sliding window
+				var rcSketch []uint64
+				var err error
+				if opts.Stranded {
+					window.Sketch, err = minhash.GetStrandedReadSketch(seq, uint(kmerSize), uint(opts.SketchSize), opts.DustLevel > 0)
+					if err == nil {
+						rcSketch, err = minhash.GetStrandedReadSketch(seqio.ReverseComplement(seq), uint(kmerSize), uint(opts.SketchSize), opts.DustLevel > 0)
+					}
+				} else {
+					window.Sketch, err = minhash.GetReadSketch(seq, uint(kmerSize), uint(opts.SketchSize), false, opts.DustLevel > 0)
+				}
+				if err != nil {
+					panic(err)
+				}
+				sendWindow(window, rcSketch)
+			})
+		})
+	}
+	return jobs, nil
+}
 
-			// add nodes to the contained nodes list
-			for node := range window.ContainedNodes {
-				existingWindow.ContainedNodes[node]++
-			}
+// windowTraversals is a method to enumerate the traversals of the graph that span a window, following the out edges from every base of every node
+// up to maxTraversals novel traversals (including those that reach the end of the graph before filling the window) are followed from each window start
+// traversals that are covered by a path (i.e. the nodes in the traversal follow on from each other in the path) are skipped and don't count towards maxTraversals, as these are already windowed; the rest are sent without a Ref
+func (GrootGraph *GrootGraph) windowTraversals(windowSize, maxTraversals int, send func(*lshforest.Key, []byte)) {
+	seq := make([]byte, 0, windowSize)
+	nodes := make([]*GrootGraphNode, 0, windowSize)
+	bases := make([]int, 0, windowSize)
+	for _, startNode := range GrootGraph.SortedNodes {
+		for offset := 0; offset < len(startNode.Sequence); offset++ {
+			traversals := 0
+			var extend func(node *GrootGraphNode, from int)
+			extend = func(node *GrootGraphNode, from int) {
+				if traversals >= maxTraversals {
+					return
+				}
 
-			// add pathID
-			existingWindow.Ref = append(existingWindow.Ref, window.Ref...)
-		} else {
-			sketchSeen[stringifiedSketch] = window
+				// add as much of the node as fits in the window
+				to := from + windowSize - len(seq)
+				if to > len(node.Sequence) {
+					to = len(node.Sequence)
+				}
+				seq = append(seq, node.Sequence[from:to]...)
+				nodes = append(nodes, node)
+				bases = append(bases, to-from)
+				defer func() {
+					seq = seq[:len(seq)-(to-from)]
+					nodes = nodes[:len(nodes)-1]
+					bases = bases[:len(bases)-1]
+				}()
+
+				// send the window once it is filled, otherwise follow the out edges
+				if len(seq) == windowSize || len(node.OutEdges) == 0 {
+					if GrootGraph.traversalOnPath(nodes) {
+						return
+					}
+					traversals++
+					if len(seq) < windowSize {
+						return
+					}
+					ContainedNodes := make(map[uint64]float64, len(nodes))
+					for i, n := range nodes {
+						ContainedNodes[n.SegmentID] += float64(bases[i])
+					}
+					send(&lshforest.Key{
+						GraphID:        GrootGraph.GraphID,
+						Node:           startNode.SegmentID,
+						OffSet:         uint32(offset),
+						ContainedNodes: ContainedNodes,
+						WindowSize:     uint32(windowSize),
+					}, append([]byte{}, seq...))
+					return
+				}
+				for _, edge := range node.OutEdges {
+					if next, err := GrootGraph.GetNode(edge); err == nil {
+						extend(next, 0)
+					}
+				}
+			}
+			extend(startNode, offset)
 		}
 	}
+}
 
-	// this method returns a channel, which receives windows as they are made
-	windowChan2 := make(chan *lshforest.Key)
-	var wg2 sync.WaitGroup
-	wg2.Add(1)
-	go func() {
-		for _, window := range sketchSeen {
-			windowChan2 <- window
+// traversalOnPath is a method to check if a traversal is covered by one of the graph paths
+// every node in the traversal must be on the same path, and each pair of consecutive nodes must be neighbours in that path (so an edge that skips part of the path, such as a deletion, is not covered)
+func (GrootGraph *GrootGraph) traversalOnPath(nodes []*GrootGraphNode) bool {
+	for _, pathID := range nodes[0].PathIDs {
+		onPath := true
+		for i := 1; i < len(nodes) && onPath; i++ {
+			onPath = nodeOnPath(nodes[i], pathID) && GrootGraph.pathNeighbours(nodes[i-1], nodes[i], pathID)
 		}
-		wg2.Done()
-	}()
+		if onPath {
+			return true
+		}
+	}
+	return false
+}
 
-	go func() {
-		wg2.Wait()
-		close(windowChan2)
-	}()
+// pathNeighbours is a method to check that no node of a path sits between two of its nodes in the sorted graph
+func (GrootGraph *GrootGraph) pathNeighbours(from, to *GrootGraphNode, pathID uint32) bool {
+	start, ok := GrootGraph.NodeLookup[from.SegmentID]
+	if !ok {
+		return false
+	}
+	end, ok := GrootGraph.NodeLookup[to.SegmentID]
+	if !ok || end <= start {
+		return false
+	}
+	for _, node := range GrootGraph.SortedNodes[start+1 : end] {
+		if nodeOnPath(node, pathID) {
+			return false
+		}
+	}
+	return true
+}
 
-	return windowChan2
+// nodeOnPath is a function to check if a node is on a path
+func nodeOnPath(node *GrootGraphNode, pathID uint32) bool {
+	for _, id := range node.PathIDs {
+		if id == pathID {
+			return true
+		}
+	}
+	return false
 }
 
 /*
@@ -639,3 +860,12 @@ func (GrootGraph *GrootGraph) Graph2Seqs() (map[uint32][]byte, error) {
 func (GrootGraph *GrootGraph) IncrementKmerCount(increment uint64) {
 	GrootGraph.KmerTotal += increment
 }
+
+// IncrementStrandCount is a method to increment the counter for the number of kmers projected onto the graph from one strand
+func (GrootGraph *GrootGraph) IncrementStrandCount(rc bool, increment uint64) {
+	if rc {
+		GrootGraph.StrandKmers[1] += increment
+	} else {
+		GrootGraph.StrandKmers[0] += increment
+	}
+}
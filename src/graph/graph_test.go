@@ -1,10 +1,17 @@
 package graph
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/will-rowe/baby-groot/src/lshforest"
+	"github.com/will-rowe/baby-groot/src/seqio"
 	"github.com/will-rowe/gfa"
 )
 
@@ -40,6 +47,62 @@ func TestCreateGrootGraph(t *testing.T) {
 	}
 }
 
+// test CreateGrootGraph rejects graphs that can't be topologically sorted
+func TestCreateGrootGraphCycle(t *testing.T) {
+	myGFA := gfa.NewGFA()
+	_ = myGFA.AddVersion(1)
+	for _, segID := range []string{"1", "2"} {
+		seg, err := gfa.NewSegment([]byte(segID), []byte("ACTG"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seg.Add(myGFA)
+	}
+	for _, edge := range [][2]string{{"1", "2"}, {"2", "1"}} {
+		link, err := gfa.NewLink([]byte(edge[0]), []byte("+"), []byte(edge[1]), []byte("+"), []byte("0M"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		link.Add(myGFA)
+	}
+	path, err := gfa.NewPath([]byte("cycle"), [][]byte{[]byte("1+"), []byte("2+")}, [][]byte{[]byte("4M"), []byte("4M")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path.Add(myGFA)
+	if _, err := CreateGrootGraph(myGFA, 1); err == nil {
+		t.Fatal("graph with a cycle should not be created")
+	}
+}
+
+// test CreatePOAgraph
+func TestCreatePOAgraph(t *testing.T) {
+	seqs := []*seqio.Sequence{
+		{ID: []byte("seqA"), Seq: []byte("ACTGACTGACTTTGACAAAGTC")},
+		{ID: []byte("seqB"), Seq: []byte("ACTGACTGCCTTTGACAAAGTC")},
+		{ID: []byte("seqC"), Seq: []byte("ACTGACTGACTTTGAAAGTCGG")},
+	}
+	grootGraph, err := CreatePOAgraph(seqs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grootGraph.Paths) != len(seqs) {
+		t.Fatal("graph should have a path for each sequence")
+	}
+	pathSeqs, err := grootGraph.Graph2Seqs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pathID, seq := range seqs {
+		if string(pathSeqs[uint32(pathID)]) != string(seq.Seq) {
+			t.Fatalf("path %d does not spell out the input sequence", pathID)
+		}
+	}
+	if len(grootGraph.SortedNodes) < 3 {
+		t.Fatal("variants should have produced a branching graph")
+	}
+}
+
 // test Graph2Seq
 func TestGraph2Seqs(t *testing.T) {
 	t.Log("replace")
@@ -53,12 +116,334 @@ func TestWindowGraph(t *testing.T) {
 		t.Fatal(err)
 	}
 	counter := 0
-	for window := range grootGraph.WindowGraph(windowSize, kmerSize, sketchSize) {
+	merger := NewWindowMerger()
+	for window := range grootGraph.WindowGraph(&WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize}) {
 		//t.Log(window)
-		_ = window
+		if int(window.WindowSize) != windowSize {
+			t.Fatal("window does not record its window size")
+		}
+		if window.RC {
+			t.Fatal("canonical windows should not be marked as reverse strand")
+		}
+		merger.Add(window)
 		counter++
 	}
-	t.Log("number of windows with unique sketchs: ", counter)
+	t.Log("number of windows with unique sketchs: ", len(merger.Windows()))
+
+	// the worker pool should stream the same windows, however many workers are used
+	poolMerger := NewWindowMerger()
+	poolCounter := 0
+	for window := range grootGraph.WindowGraph(&WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize, NumWorkers: 4}) {
+		poolMerger.Add(window)
+		poolCounter++
+	}
+	if poolCounter != counter || len(poolMerger.Windows()) != len(merger.Windows()) {
+		t.Fatalf("worker pool gave different windows (%d vs. %d, with %d vs. %d unique)", poolCounter, counter, len(poolMerger.Windows()), len(merger.Windows()))
+	}
+	refs := 0
+	for _, window := range merger.Windows() {
+		refs += len(window.Ref)
+	}
+	if refs != counter {
+		t.Fatal("merged windows should keep the paths of the windows they combine")
+	}
+
+	// stranded windowing should give a window for each strand
+	strandCounts := [2]int{}
+	for window := range grootGraph.WindowGraph(&WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize, Stranded: true, NumWorkers: 4}) {
+		if window.RC {
+			strandCounts[1]++
+		} else {
+			strandCounts[0]++
+		}
+	}
+	if strandCounts[0] == 0 || strandCounts[1] == 0 {
+		t.Fatalf("stranded windowing should give windows for both strands (forward: %d, reverse: %d)", strandCounts[0], strandCounts[1])
+	}
+
+	// windowing the traversals should add windows for the combinations of bubbles that aren't in the paths
+	traversalCounter := 0
+	for window := range grootGraph.WindowGraph(&WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize, MaxTraversals: 10, NumWorkers: 4}) {
+		if len(window.Ref) != 0 {
+			continue
+		}
+		traversalCounter++
+		bases := 0.0
+		for node, count := range window.ContainedNodes {
+			if _, err := grootGraph.GetNode(node); err != nil {
+				t.Fatal(err)
+			}
+			bases += count
+		}
+		if int(bases) < windowSize {
+			t.Fatalf("traversal window should contain at least %d bases, not %v", windowSize, bases)
+		}
+	}
+	if traversalCounter == 0 {
+		t.Fatal("no windows were made from the graph traversals")
+	}
+	t.Log("number of windows from traversals not on a path: ", traversalCounter)
+
+	// a stride should only keep every few windows from each path, and minimizers should pick a similar number of windows
+	for _, minimizers := range []bool{false, true} {
+		strideCounter := 0
+		for range grootGraph.WindowGraph(&WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize, Stride: 4, Minimizers: minimizers}) {
+			strideCounter++
+		}
+		if strideCounter == 0 || strideCounter > counter/2 {
+			t.Fatalf("wrong number of windows kept with a stride of 4 (minimizers: %v): %d of %d", minimizers, strideCounter, counter)
+		}
+	}
+}
+
+// test that traversals taking an edge that skips part of a path are windowed, even when the on-path traversals from the same start already reach the cap
+func TestWindowTraversals(t *testing.T) {
+	myGFA := gfa.NewGFA()
+	_ = myGFA.AddVersion(1)
+	for _, seg := range [][2]string{{"1", "ACGT"}, {"2", "CCGG"}, {"3", "TTAA"}} {
+		segment, err := gfa.NewSegment([]byte(seg[0]), []byte(seg[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		segment.Add(myGFA)
+	}
+	for _, edge := range [][2]string{{"1", "2"}, {"2", "3"}, {"1", "3"}} {
+		link, err := gfa.NewLink([]byte(edge[0]), []byte("+"), []byte(edge[1]), []byte("+"), []byte("0M"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		link.Add(myGFA)
+	}
+	path, err := gfa.NewPath([]byte("seqA"), [][]byte{[]byte("1+"), []byte("2+"), []byte("3+")}, [][]byte{[]byte("4M"), []byte("4M"), []byte("4M")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path.Add(myGFA)
+	grootGraph, err := CreateGrootGraph(myGFA, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletions := 0
+	grootGraph.windowTraversals(6, 1, func(window *lshforest.Key, seq []byte) {
+		if _, ok := window.ContainedNodes[2]; ok {
+			t.Fatalf("traversal on the path should not be windowed: %v", string(seq))
+		}
+		deletions++
+	})
+	if deletions != 3 {
+		t.Fatalf("expected a traversal window across the deletion from the first three starts in the first node, not %d", deletions)
+	}
+}
+
+// test the sliding window sketches match sketching each window sequence on its own
+func TestWindowGraphSketches(t *testing.T) {
+	myGFA := loadMSA()
+	grootGraph, err := CreateGrootGraph(myGFA, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathSeqs, err := grootGraph.Graph2Seqs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get the start of each node in each path, so that the window sequences can be found
+	nodeStarts := make(map[uint32]map[uint64]int)
+	lengths := make(map[uint32]int)
+	for _, node := range grootGraph.SortedNodes {
+		for _, pathID := range node.PathIDs {
+			if _, ok := nodeStarts[pathID]; !ok {
+				nodeStarts[pathID] = make(map[uint64]int)
+			}
+			nodeStarts[pathID][node.SegmentID] = lengths[pathID]
+			lengths[pathID] += len(node.Sequence)
+		}
+	}
+	for pathID, seq := range pathSeqs {
+		pathSeqs[pathID], _ = seqio.DustMask(seq, seqio.DefaultDustLevel)
+	}
+	for _, stranded := range []bool{false, true} {
+		for window := range grootGraph.WindowGraph(&WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize, Stranded: stranded, DustLevel: seqio.DefaultDustLevel, NumWorkers: 2}) {
+			start := nodeStarts[window.Ref[0]][window.Node] + int(window.OffSet)
+			windowSeq := seqio.Sequence{Seq: pathSeqs[window.Ref[0]][start : start+windowSize]}
+			var sketch []uint64
+			switch {
+			case window.RC:
+				windowSeq.Seq = seqio.ReverseComplement(windowSeq.Seq)
+				sketch, err = windowSeq.RunStrandedMinHash(kmerSize, sketchSize)
+			case stranded:
+				sketch, err = windowSeq.RunStrandedMinHash(kmerSize, sketchSize)
+			default:
+				sketch, err = windowSeq.RunMinHash(kmerSize, sketchSize, false, nil)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(sketch) != fmt.Sprint(window.Sketch) {
+				t.Fatalf("sketch of window at node %d offset %d does not match the window sequence (stranded: %v, reverse strand: %v)", window.Node, window.OffSet, stranded, window.RC)
+			}
+		}
+	}
+}
+
+// benchmark windowing and sketching a graph
+func BenchmarkWindowGraph(b *testing.B) {
+	myGFA := loadMSA()
+	grootGraph, err := CreateGrootGraph(myGFA, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for n := 0; n < b.N; n++ {
+		for range grootGraph.WindowGraph(&WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize}) {
+		}
+	}
+}
+
+// benchmark building the window lookup used to find the windows returned by the LSH Ensemble, with a window ID for each domain
+func BenchmarkWindowLookup(b *testing.B) {
+	windows := benchmarkWindows(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		lookup := make([]*lshforest.Key, len(windows))
+		domains := make([]uint32, len(windows))
+		for windowID, window := range windows {
+			lookup[windowID] = window
+			domains[windowID] = uint32(windowID)
+		}
+		for _, windowID := range domains {
+			_ = lookup[windowID]
+		}
+	}
+}
+
+// benchmark building the window lookup with the string keys used before window IDs (g<graphID>n<node>o<offset>p<number of paths>w<window size>), for comparison with BenchmarkWindowLookup
+func BenchmarkWindowLookupStringKeys(b *testing.B) {
+	windows := benchmarkWindows(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		lookup := make(map[string]*lshforest.Key, len(windows))
+		domains := make([]string, len(windows))
+		for windowID, window := range windows {
+			key := fmt.Sprintf("g%dn%do%dp%dw%d", window.GraphID, window.Node, window.OffSet, len(window.Ref), window.WindowSize)
+			if _, ok := lookup[key]; ok {
+				key = fmt.Sprintf("%vt%d", key, windowID)
+			}
+			lookup[key] = window
+			domains[windowID] = key
+		}
+		for _, key := range domains {
+			_ = lookup[key]
+		}
+	}
+}
+
+// benchmarkWindows is a helper function to window the test graph for the window lookup benchmarks
+func benchmarkWindows(b *testing.B) []*lshforest.Key {
+	grootGraph, err := CreateGrootGraph(loadMSA(), 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	windows := []*lshforest.Key{}
+	for window := range grootGraph.WindowGraph(&WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize}) {
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// test that the coarse index chooses the graph that a read came from
+func TestCoarseIndex(t *testing.T) {
+	grootGraph, err := CreateGrootGraph(loadMSA(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := grootGraph.KmerHashes(kmerSize, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(hashes); i++ {
+		if hashes[i] <= hashes[i-1] {
+			t.Fatal("graph k-mer hashes are not sorted and unique")
+		}
+	}
+	sketch := CoarseSketch(hashes, kmerSize, 4)
+	if len(sketch) == 0 || len(sketch) >= len(hashes) {
+		t.Fatalf("coarse sketch did not sample the k-mers (%d of %d kept)", len(sketch), len(hashes))
+	}
+	coarse := NewCoarseIndex(map[uint32][]uint64{1: sketch, 2: {}}, kmerSize, 4)
+	pathSeqs, err := grootGraph.Graph2Seqs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphIDs, sampled := coarse.Query(pathSeqs[0][:windowSize], 0.5)
+	if !sampled || len(graphIDs) != 1 || graphIDs[0] != 1 {
+		t.Fatalf("coarse index did not choose the graph for a read from it: %v", graphIDs)
+	}
+	if graphIDs, _ := coarse.Query([]byte("ACGTTGCAACGTTGCAACGTTGCAACGTTGCA"), 0.5); len(graphIDs) != 0 {
+		t.Fatalf("coarse index chose a graph for an unrelated read: %v", graphIDs)
+	}
+}
+
+// test that windows dominated by background k-mers are left out, and that the left out regions are reported
+func TestBackground(t *testing.T) {
+	grootGraph, err := CreateGrootGraph(loadMSA(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathSeqs, err := grootGraph.Graph2Seqs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	background := NewBackground([][]byte{pathSeqs[0][:2*windowSize]}, kmerSize)
+	if background.Fraction(pathSeqs[0][:windowSize]) != 1.0 {
+		t.Fatal("a window taken from the background sequence should only hold background k-mers")
+	}
+	if fraction := background.Fraction([]byte("NNNNNNNNNNNNNNNNNNNNNNNNNNNNNN")); fraction != 0 {
+		t.Fatalf("a sequence without any valid k-mers should have no background k-mers, not %.2f", fraction)
+	}
+	if NewBackgroundFromKmers(background.Kmers(), kmerSize).NumKmers() != background.NumKmers() {
+		t.Fatal("background was not rebuilt from its k-mers")
+	}
+
+	// every window left out should be recorded in a region, and the first path should have a region at its start
+	opts := &WindowOptions{WindowSize: windowSize, KmerSize: kmerSize, SketchSize: sketchSize}
+	allWindows := 0
+	for range grootGraph.WindowGraph(opts) {
+		allWindows++
+	}
+	opts.Background, opts.MaxBackground = background, 0.5
+	keptWindows := 0
+	for range grootGraph.WindowGraph(opts) {
+		keptWindows++
+	}
+	regions, err := grootGraph.BackgroundRegions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) == 0 || regions[0].PathID != 0 || regions[0].Start != 0 || regions[0].End < 2*windowSize {
+		t.Fatalf("background region was not found at the start of the first path: %+v", regions)
+	}
+	leftOut := 0
+	for _, region := range regions {
+		if region.Windows < 1 || region.End-region.Start < windowSize || region.WindowSize != windowSize {
+			t.Fatalf("bad background region: %+v", region)
+		}
+		leftOut += region.Windows
+	}
+	if keptWindows != allWindows-leftOut {
+		t.Fatalf("background regions hold %d windows, but %d of %d windows were left out", leftOut, allWindows-keptWindows, allWindows)
+	}
+}
+
+// test choosing the window set for a read length
+func TestGetWindowSet(t *testing.T) {
+	index := &ContainmentIndex{WindowSizes: []int{100, 150, 1000}}
+	for readLength, expected := range map[int]int{50: 100, 120: 100, 125: 150, 151: 150, 900: 1000, 5000: 1000} {
+		if windowSize := index.GetWindowSet(readLength); windowSize != expected {
+			t.Fatalf("reads of length %d should use window set %d, not %d", readLength, expected, windowSize)
+		}
+	}
 }
 
 /*
@@ -124,3 +509,101 @@ func TestGraphDump(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// test DumpGraphAsGFA writes an unweighted graph that can be read back in
+func TestGraphDumpUnweighted(t *testing.T) {
+	myGFA, err := LoadGFA(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grootGraph, err := CreateGrootGraph(myGFA, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := grootGraph.DumpGraphAsGFA("./tmp-graph.gfa"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("./tmp-graph.gfa")
+	dumpedGFA, err := LoadGFA("./tmp-graph.gfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpedGraph, err := CreateGrootGraph(dumpedGFA, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumpedGraph.SortedNodes) != len(grootGraph.SortedNodes) || len(dumpedGraph.Paths) != len(grootGraph.Paths) {
+		t.Fatal("dumped graph does not match the original")
+	}
+}
+
+// test ReadMSA
+func TestReadMSA(t *testing.T) {
+	fastaMSA, err := ReadMSA(inputFile2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gfaMSA, err := gfa.ReadMSA(inputFile2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fastaMSA.Rows() != gfaMSA.Rows() || fastaMSA.Len() != gfaMSA.Len() {
+		t.Fatal("aligned FASTA was not read correctly")
+	}
+
+	// write the alignment as Clustal and Stockholm, then check they are read back the same
+	tmpDir, err := ioutil.TempDir("", "groot-msa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	clustal, stockholm := &bytes.Buffer{}, &bytes.Buffer{}
+	clustal.WriteString("CLUSTAL W (1.83) multiple sequence alignment\n\n")
+	stockholm.WriteString("# STOCKHOLM 1.0\n#=GF ID test\n")
+	for start := 0; start < fastaMSA.Len(); start += 60 {
+		end := start + 60
+		if end > fastaMSA.Len() {
+			end = fastaMSA.Len()
+		}
+		for i := 0; i < fastaMSA.Rows(); i++ {
+			row := fastaMSA.Row(i)
+			block := make([]byte, 0, end-start)
+			for j := start; j < end; j++ {
+				block = append(block, byte(row.At(j).L))
+			}
+			fmt.Fprintf(clustal, "%v    %v\n", row.Name(), string(block))
+			fmt.Fprintf(stockholm, "%v %v\n", row.Name(), strings.Replace(string(block), "-", ".", -1))
+		}
+		clustal.WriteString("                     ***** ***\n\n")
+		stockholm.WriteString("\n")
+	}
+	stockholm.WriteString("//\n")
+	for fileName, content := range map[string][]byte{"test.aln": clustal.Bytes(), "test.sto": stockholm.Bytes()} {
+		path := filepath.Join(tmpDir, fileName)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		msa, err := ReadMSA(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msa.Rows() != fastaMSA.Rows() || msa.Len() != fastaMSA.Len() {
+			t.Fatalf("%v was not read correctly", fileName)
+		}
+		for i := 0; i < msa.Rows(); i++ {
+			if msa.Row(i).Name() != fastaMSA.Row(i).Name() {
+				t.Fatalf("%v has the wrong sequence order", fileName)
+			}
+			for j := 0; j < msa.Len(); j++ {
+				if msa.Row(i).At(j).L != fastaMSA.Row(i).At(j).L {
+					t.Fatalf("%v has the wrong sequence for %v", fileName, msa.Row(i).Name())
+				}
+			}
+		}
+	}
+
+	// unknown formats should be rejected
+	if _, err := ReadMSA(inputFile); err == nil {
+		t.Fatal("GFA file should not be read as an MSA")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/will-rowe/baby-groot/src/indexio"
 	"github.com/will-rowe/baby-groot/src/version"
 	"github.com/will-rowe/gfa"
 )
@@ -14,18 +15,105 @@ import (
 // Store stores the GROOT graphs, using the graphID as the lookup key
 type Store map[uint32]*GrootGraph
 
+// Records is a method to convert the graphs in a Store to their on-disk records
+func (Store Store) Records() []*indexio.GraphRecord {
+	records := make([]*indexio.GraphRecord, 0, len(Store))
+	for _, g := range Store {
+		record := &indexio.GraphRecord{
+			GrootVersion: g.GrootVersion,
+			GraphID:      g.GraphID,
+			SortedNodes:  make([]*indexio.NodeRecord, len(g.SortedNodes)),
+			Paths:        g.Paths,
+			Lengths:      g.Lengths,
+		}
+		for i, node := range g.SortedNodes {
+			record.SortedNodes[i] = &indexio.NodeRecord{
+				SegmentID:     node.SegmentID,
+				SegmentLength: node.SegmentLength,
+				Sequence:      node.Sequence,
+				OutEdges:      node.OutEdges,
+				PathIDs:       node.PathIDs,
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// NewStoreFromRecords is a Store constructor that takes the on-disk graph records
+func NewStoreFromRecords(records []*indexio.GraphRecord) Store {
+	store := make(Store, len(records))
+	for _, record := range records {
+		g := &GrootGraph{
+			GrootVersion: record.GrootVersion,
+			GraphID:      record.GraphID,
+			SortedNodes:  make([]*GrootGraphNode, len(record.SortedNodes)),
+			Paths:        record.Paths,
+			Lengths:      record.Lengths,
+			NodeLookup:   make(map[uint64]int, len(record.SortedNodes)),
+		}
+		if g.Paths == nil {
+			g.Paths = make(map[uint32][]byte)
+		}
+		if g.Lengths == nil {
+			g.Lengths = make(map[uint32]int)
+		}
+		for i, node := range record.SortedNodes {
+			g.SortedNodes[i] = &GrootGraphNode{
+				SegmentID:     node.SegmentID,
+				SegmentLength: node.SegmentLength,
+				Sequence:      node.Sequence,
+				OutEdges:      node.OutEdges,
+				PathIDs:       node.PathIDs,
+			}
+			g.NodeLookup[node.SegmentID] = i
+		}
+		store[g.GraphID] = g
+	}
+	return store
+}
+
 // SaveGraphAsGFA is a method to convert and save a GrootGraph in GFA format
+// graphs which have had no reads mapped are not saved
 func (GrootGraph *GrootGraph) SaveGraphAsGFA(fileName string, totalKmers int) (int, error) {
+	msg := fmt.Sprintf("this graph is approximately weighted using k-mer frequencies from projected read sketches (total k-mers projected across all graphs: %d)", totalKmers)
+	newGFA, graphUsed, err := GrootGraph.graph2GFA(msg)
+	if err != nil {
+		return 0, err
+	}
+	// don't save the graph if no reads aligned
+	if graphUsed == false {
+		return 0, nil
+	}
+	return 1, writeGFA(fileName, newGFA)
+}
+
+// DumpGraphAsGFA is a method to save a GrootGraph in GFA format, regardless of whether any reads have been mapped
+func (GrootGraph *GrootGraph) DumpGraphAsGFA(fileName string) error {
+	newGFA, _, err := GrootGraph.graph2GFA("this graph was dumped from a groot index")
+	if err != nil {
+		return err
+	}
+	return writeGFA(fileName, newGFA)
+}
+
+// graph2GFA is a method to convert a GrootGraph to a GFA instance, it also returns true if any reads have been mapped to the graph
+func (GrootGraph *GrootGraph) graph2GFA(msg string) (*gfa.GFA, bool, error) {
 	// a flag to prevent dumping graphs which had no reads map
 	graphUsed := false
 	t := time.Now()
 	stamp := fmt.Sprintf("variation graph created by groot (version %v) at: %v", version.VERSION, t.Format("Mon Jan _2 15:04:05 2006"))
-	msg := fmt.Sprintf("this graph is approximately weighted using k-mer frequencies from projected read sketches (total k-mers projected across all graphs: %d)", totalKmers)
 	// create a GFA instance
 	newGFA := gfa.NewGFA()
 	_ = newGFA.AddVersion(1)
 	newGFA.AddComment([]byte(stamp))
 	newGFA.AddComment([]byte(msg))
+	if GrootGraph.StrandKmers[0]+GrootGraph.StrandKmers[1] > 0 {
+		newGFA.AddComment([]byte(fmt.Sprintf("k-mers projected from reads on the forward strand: %d, reverse strand: %d", GrootGraph.StrandKmers[0], GrootGraph.StrandKmers[1])))
+	}
+	if GrootGraph.SharedKmers > 0 {
+		newGFA.AddComment([]byte(fmt.Sprintf("k-mers projected from windows shared with other graphs: %d", GrootGraph.SharedKmers)))
+	}
 	// transfer all the GrootGraphNode content to the GFA instance
 	for _, node := range GrootGraph.SortedNodes {
 
@@ -42,13 +130,13 @@ func (GrootGraph *GrootGraph) SaveGraphAsGFA(fileName string, totalKmers int) (i
 		// create the segment
 		seg, err := gfa.NewSegment([]byte(segID), []byte(node.Sequence))
 		if err != nil {
-			return 0, err
+			return nil, false, err
 		}
 		// the k-mer count corresponds to the node weight, which is its share of the k-mers from the projected sketches
 		kmerCount := fmt.Sprintf("KC:i:%d", int((node.KmerFreq)))
 		ofs, err := gfa.NewOptionalFields([]byte(kmerCount))
 		if err != nil {
-			return 0, err
+			return nil, false, err
 		}
 		seg.AddOptionalFields(ofs)
 		seg.Add(newGFA)
@@ -57,15 +145,11 @@ func (GrootGraph *GrootGraph) SaveGraphAsGFA(fileName string, totalKmers int) (i
 			toSeg := strconv.FormatUint(outEdge, 10)
 			link, err := gfa.NewLink([]byte(segID), []byte("+"), []byte(toSeg), []byte("+"), []byte("0M"))
 			if err != nil {
-				return 0, err
+				return nil, false, err
 			}
 			link.Add(newGFA)
 		}
 	}
-	// don't save the graph if no reads aligned
-	if graphUsed == false {
-		return 0, nil
-	}
 	// create the paths
 	for pathID, pathName := range GrootGraph.Paths {
 		// some paths won't have complete coverage, and have had their lengths set to 0 - ignore these paths
@@ -92,22 +176,25 @@ func (GrootGraph *GrootGraph) SaveGraphAsGFA(fileName string, totalKmers int) (i
 		// add the path
 		path, err := gfa.NewPath(pathName, segments, overlaps)
 		if err != nil {
-			return 0, err
+			return nil, false, err
 		}
 		path.Add(newGFA)
 	}
-	// create a gfaWriter and write the GFA instance
+	return newGFA, graphUsed, nil
+}
+
+// writeGFA is a function to write a GFA instance to file
+func writeGFA(fileName string, newGFA *gfa.GFA) error {
 	outfile, err := os.Create(fileName)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	defer outfile.Close()
 	writer, err := gfa.NewWriter(outfile, newGFA)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	err = newGFA.WriteGFAContent(writer)
-	return 1, nil
+	return newGFA.WriteGFAContent(writer)
 }
 
 // LoadGFA reads a GFA file into a GFA struct
@@ -3,23 +3,24 @@ package graph
 
 import (
 	"bytes"
-	"encoding/gob"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"runtime"
+	"path/filepath"
 	"sort"
 	"sync"
 
 	"github.com/ekzhu/lshensemble"
+	"github.com/will-rowe/baby-groot/src/ensemble"
+	"github.com/will-rowe/baby-groot/src/indexio"
 	"github.com/will-rowe/baby-groot/src/lshforest"
 )
 
 // ContainmentIndex is a wrapper for the LSH Ensemble data structure
 type ContainmentIndex struct {
 
-	// LookupMap relates the stringified keys in the index to the graph windows
-	LookupMap map[string]*lshforest.Key
+	// Windows holds the graph windows in the index, where the position of a window is its window ID (which is the key for its domain record)
+	Windows []*lshforest.Key
 
 	// DomainRecords is used for construction of the index, then destroyed
 	DomainRecords []*lshensemble.DomainRecord
@@ -36,12 +37,24 @@ type ContainmentIndex struct {
 	// SketchSize is the size of the sketches being indexed (num hash funcs)
 	SketchSize int
 
-	// LSHensemble is the LSH Ensemble index
-	LSHensemble *lshensemble.LshEnsemble
+	// KmerSize is the k-mer size used to sketch the graph windows (zero for indexes built before multiple window sizes were supported)
+	KmerSize int
+
+	// WindowSizes is the length of the windows in each window set of a multi-resolution index
+	WindowSizes []int
+
+	// LSHensemble is the bootstrapped LSH Ensemble index
+	LSHensemble *ensemble.Ensemble
 
 	// unexported:
 	numSketches int          // number of sketches in the containment index
-	lock        sync.RWMutex // lock is a mutex to manage RW access to the LookupMap
+	lock        sync.RWMutex // lock is a mutex to manage RW access to the Windows
+	mapped      []byte       // the memory-mapped index file (if the index was loaded with Load)
+
+	// graphEnsembles holds an LSH Ensemble of the windows from each graph, which is built the first time that QueryGraphs is used
+	graphEnsembles map[uint32]*ensemble.Ensemble
+	partitionOnce  sync.Once
+	partitionErr   error
 }
 
 /*
@@ -53,13 +66,13 @@ add the Key struct here and remove LSH Forest guff
 
 */
 
-// PrepareIndex prepares a LSH Ensemble index from a map of domain records and a map of keys-windows
-func PrepareIndex(domainRecMap map[int]*lshensemble.DomainRecord, lookupMap map[string]*lshforest.Key, numPart, maxK, windowSize, sketchSize int) *ContainmentIndex {
+// PrepareIndex prepares a LSH Ensemble index from a map of domain records and the windows, where the domain record keys are window IDs
+func PrepareIndex(domainRecMap map[int]*lshensemble.DomainRecord, windows []*lshforest.Key, numPart, maxK, windowSize, sketchSize int) *ContainmentIndex {
 
 	// setup the struct
 	ci := &ContainmentIndex{
 		DomainRecords: make([]*lshensemble.DomainRecord, len(domainRecMap)),
-		LookupMap:     lookupMap,
+		Windows:       windows,
 		NumPart:       numPart,
 		MaxK:          maxK,
 		WindowSize:    windowSize,
@@ -78,7 +91,7 @@ func PrepareIndex(domainRecMap map[int]*lshensemble.DomainRecord, lookupMap map[
 func (ContainmentIndex *ContainmentIndex) Dump(filePath string) error {
 
 	// make sure it has had the prepare method run
-	if ContainmentIndex.DomainRecords == nil || ContainmentIndex.LookupMap == nil {
+	if ContainmentIndex.DomainRecords == nil || ContainmentIndex.Windows == nil {
 		return fmt.Errorf("must run PrepareIndex before dumping index to disk")
 	}
 
@@ -87,107 +100,441 @@ func (ContainmentIndex *ContainmentIndex) Dump(filePath string) error {
 		return fmt.Errorf("this index cannot be dumped a second time")
 	}
 
-	// write to disk
-	fh, err := os.Create(filePath)
-	defer fh.Close()
+	// write to a tmp file and then move it into place, so that any process which has the old index memory-mapped is unaffected
+	fh, err := ioutil.TempFile(filepath.Dir(filePath), ".groot-lshe-")
 	if err != nil {
 		return err
 	}
-	encoder := gob.NewEncoder(fh)
-	return encoder.Encode(ContainmentIndex)
+	defer os.Remove(fh.Name())
+	if err := indexio.WriteIndex(fh, ContainmentIndex.record()); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(fh.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(fh.Name(), filePath)
 }
 
-// Load is a method to load a containment index from disk and populate the LSH Ensemble
+// Populate is a method to build the LSH Ensemble for a prepared containment index in memory, so that it can be queried without writing it to disk
+func (ContainmentIndex *ContainmentIndex) Populate() error {
+	if ContainmentIndex.DomainRecords == nil || ContainmentIndex.Windows == nil {
+		return fmt.Errorf("must run PrepareIndex before populating the index")
+	}
+	if ContainmentIndex.numSketches != 0 {
+		return fmt.Errorf("this index has already been populated")
+	}
+	var buf bytes.Buffer
+	if err := indexio.WriteIndex(&buf, ContainmentIndex.record()); err != nil {
+		return err
+	}
+	return ContainmentIndex.LoadFromBytes(buf.Bytes())
+}
+
+// record is a method to convert a containment index to an index record, ready for encoding
+func (ContainmentIndex *ContainmentIndex) record() *indexio.IndexRecord {
+	return &indexio.IndexRecord{
+		NumPart:       ContainmentIndex.NumPart,
+		MaxK:          ContainmentIndex.MaxK,
+		WindowSize:    ContainmentIndex.WindowSize,
+		SketchSize:    ContainmentIndex.SketchSize,
+		KmerSize:      ContainmentIndex.KmerSize,
+		WindowSizes:   ContainmentIndex.WindowSizes,
+		Windows:       ContainmentIndex.Windows,
+		DomainRecords: ContainmentIndex.DomainRecords,
+	}
+}
+
+// Load is a method to load a containment index from disk, along with its bootstrapped LSH Ensemble
+// where supported, the index file is memory-mapped so that the LSH Ensemble is shared by all the processes using the index
 func (ContainmentIndex *ContainmentIndex) Load(filePath string) error {
-	data, err := ioutil.ReadFile(filePath)
+	data, err := mapFile(filePath)
 	if err != nil {
 		return err
 	}
 	if len(data) == 0 {
 		return fmt.Errorf("index appears empty")
 	}
-
+	ContainmentIndex.mapped = data
 	return ContainmentIndex.LoadFromBytes(data)
 }
 
-// LoadFromBytes is a method to load the containment index from a byte array
-func (ContainmentIndex *ContainmentIndex) LoadFromBytes(data []byte) error {
-	buf := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buf)
-	var err error
-	if err := decoder.Decode(ContainmentIndex); err != nil {
+// Close is a method to release a loaded containment index, unmapping the index file if it was memory-mapped
+// the index cannot be queried once it has been closed
+func (ContainmentIndex *ContainmentIndex) Close() error {
+	ContainmentIndex.LSHensemble = nil
+	ContainmentIndex.Windows = nil
+	ContainmentIndex.graphEnsembles = nil
+	if ContainmentIndex.mapped == nil {
+		return nil
+	}
+	data := ContainmentIndex.mapped
+	ContainmentIndex.mapped = nil
+	return unmapFile(data)
+}
+
+// MappedSize is a method to return the size of the memory-mapped index file, which is not counted in the heap (zero if the index was not loaded with Load or mapping is unsupported)
+func (ContainmentIndex *ContainmentIndex) MappedSize() int {
+	return len(ContainmentIndex.mapped)
+}
+
+// Read is a method to load a containment index from disk without populating the LSH Ensemble
+// the domain records are retained so that the index can be updated and then dumped again
+func (ContainmentIndex *ContainmentIndex) Read(filePath string) error {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
 		return err
 	}
-	if ContainmentIndex.DomainRecords == nil {
-		return fmt.Errorf("loaded an empty index file")
+	if len(data) == 0 {
+		return fmt.Errorf("index appears empty")
 	}
+	_, err = ContainmentIndex.decode(data)
+	return err
+}
 
-	/*
-		// populate the LSH Ensemble
-		ContainmentIndex.LSHensemble, err = lshensemble.BootstrapLshEnsembleOptimal(ContainmentIndex.NumPart, ContainmentIndex.SketchSize, ContainmentIndex.MaxK,
-			func() <-chan *lshensemble.DomainRecord {
-				return lshensemble.Recs2Chan(ContainmentIndex.DomainRecords)
-			})
-	*/
+// decode is a method to decode a containment index, migrating older index formats, and return its LSH Ensemble
+func (ContainmentIndex *ContainmentIndex) decode(data []byte) (*ensemble.Ensemble, error) {
+	index, err := indexio.ReadIndex(data)
+	if err != nil {
+		return nil, err
+	}
+	if index.DomainRecords == nil {
+		return nil, fmt.Errorf("loaded an empty index file")
+	}
+	ContainmentIndex.NumPart = index.NumPart
+	ContainmentIndex.MaxK = index.MaxK
+	ContainmentIndex.WindowSize = index.WindowSize
+	ContainmentIndex.SketchSize = index.SketchSize
+	ContainmentIndex.KmerSize = index.KmerSize
+	ContainmentIndex.WindowSizes = index.WindowSizes
+	ContainmentIndex.Windows = index.Windows
+	ContainmentIndex.DomainRecords = index.DomainRecords
+	return index.Ensemble, nil
+}
+
+// RemoveGraphs is a method to remove all the windows belonging to a set of graphs from a containment index that has not been populated
+// the remaining windows are given new window IDs so that they stay dense, and it returns the number of domain records that were removed
+func (ContainmentIndex *ContainmentIndex) RemoveGraphs(graphIDs map[uint32]struct{}) (int, error) {
+	if ContainmentIndex.numSketches != 0 {
+		return 0, fmt.Errorf("cannot remove graphs from an index once the LSH Ensemble has been populated")
+	}
+	if len(graphIDs) == 0 {
+		return 0, nil
+	}
+	ContainmentIndex.lock.Lock()
+	defer ContainmentIndex.lock.Unlock()
+	windows, err := ContainmentIndex.selectWindows(func(window *lshforest.Key) bool {
+		_, ok := graphIDs[window.GraphID]
+		return !ok
+	})
+	if err != nil {
+		return 0, err
+	}
+	removed := len(ContainmentIndex.DomainRecords) - len(windows.DomainRecords)
+	ContainmentIndex.Windows = windows.Windows
+	ContainmentIndex.DomainRecords = windows.DomainRecords
+	return removed, nil
+}
+
+// Subset is a method to create a new containment index holding only the windows from a set of graphs, from an index that has not been populated
+// the domain records keep their sorted order, so the new index can be dumped straight away
+func (ContainmentIndex *ContainmentIndex) Subset(graphIDs map[uint32]struct{}) (*ContainmentIndex, error) {
+	if ContainmentIndex.numSketches != 0 {
+		return nil, fmt.Errorf("cannot subset an index once the LSH Ensemble has been populated")
+	}
+	ContainmentIndex.lock.RLock()
+	defer ContainmentIndex.lock.RUnlock()
+	subset, err := ContainmentIndex.selectWindows(func(window *lshforest.Key) bool {
+		_, ok := graphIDs[window.GraphID]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	subset.KmerSize = ContainmentIndex.KmerSize
+	subset.WindowSizes = ContainmentIndex.WindowSizes
+	return subset, nil
+}
 
-	// Create index using equi-depth partitioning
-	// You can also use BootstrapLshEnsemblePlusEquiDepth for better accuracy
-	ContainmentIndex.LSHensemble, err = lshensemble.BootstrapLshEnsembleEquiDepth(ContainmentIndex.NumPart, ContainmentIndex.SketchSize, ContainmentIndex.MaxK, len(ContainmentIndex.DomainRecords), recs2Chan(ContainmentIndex.DomainRecords))
+// selectWindows is a method to create a new containment index holding the windows that are kept by a function, giving them new window IDs in the order of the existing window IDs
+// the domain records are copied in their sorted order with their keys changed to the new window IDs
+func (ContainmentIndex *ContainmentIndex) selectWindows(keep func(*lshforest.Key) bool) (*ContainmentIndex, error) {
+	selected := PrepareIndex(make(map[int]*lshensemble.DomainRecord), []*lshforest.Key{}, ContainmentIndex.NumPart, ContainmentIndex.MaxK, ContainmentIndex.WindowSize, ContainmentIndex.SketchSize)
+	newIDs := make(map[uint32]uint32)
+	for windowID, window := range ContainmentIndex.Windows {
+		if keep(window) {
+			newIDs[uint32(windowID)] = uint32(len(selected.Windows))
+			selected.Windows = append(selected.Windows, window)
+		}
+	}
+	for _, rec := range ContainmentIndex.DomainRecords {
+		windowID, ok := rec.Key.(uint32)
+		if !ok {
+			return nil, fmt.Errorf("LSH Ensemble domain record key is not a window ID: %v", rec.Key)
+		}
+		if newID, ok := newIDs[windowID]; ok {
+			selected.DomainRecords = append(selected.DomainRecords, &lshensemble.DomainRecord{Key: newID, Size: rec.Size, Signature: rec.Signature})
+		}
+	}
+	return selected, nil
+}
 
-	ContainmentIndex.numSketches = len(ContainmentIndex.DomainRecords)
+// AddIndex is a method to add the windows from another containment index, where neither index has been populated
+// the windows from the other index are given the next window IDs, and the indexes must have been built with the same parameters and from graphs with different graphIDs
+func (ContainmentIndex *ContainmentIndex) AddIndex(other *ContainmentIndex) error {
+	if ContainmentIndex.numSketches != 0 || other.numSketches != 0 {
+		return fmt.Errorf("cannot add to an index once the LSH Ensemble has been populated")
+	}
+	if ContainmentIndex.Windows == nil {
+		ContainmentIndex.Windows = []*lshforest.Key{}
+		ContainmentIndex.NumPart = other.NumPart
+		ContainmentIndex.MaxK = other.MaxK
+		ContainmentIndex.WindowSize = other.WindowSize
+		ContainmentIndex.SketchSize = other.SketchSize
+		ContainmentIndex.KmerSize = other.KmerSize
+		ContainmentIndex.WindowSizes = other.WindowSizes
+	}
+	if ContainmentIndex.NumPart != other.NumPart || ContainmentIndex.MaxK != other.MaxK || ContainmentIndex.WindowSize != other.WindowSize || ContainmentIndex.SketchSize != other.SketchSize || ContainmentIndex.KmerSize != other.KmerSize || !sameWindowSizes(ContainmentIndex.WindowSizes, other.WindowSizes) {
+		return fmt.Errorf("cannot combine indexes built with different parameters")
+	}
+	graphIDs := make(map[uint32]struct{})
+	for _, window := range ContainmentIndex.Windows {
+		graphIDs[window.GraphID] = struct{}{}
+	}
+	for _, window := range other.Windows {
+		if _, ok := graphIDs[window.GraphID]; ok {
+			return fmt.Errorf("graph %d has windows in both indexes", window.GraphID)
+		}
+	}
+	offset := uint32(len(ContainmentIndex.Windows))
+	for _, rec := range other.DomainRecords {
+		windowID, ok := rec.Key.(uint32)
+		if !ok {
+			return fmt.Errorf("LSH Ensemble domain record key is not a window ID: %v", rec.Key)
+		}
+		ContainmentIndex.DomainRecords = append(ContainmentIndex.DomainRecords, &lshensemble.DomainRecord{Key: windowID + offset, Size: rec.Size, Signature: rec.Signature})
+	}
+	ContainmentIndex.Windows = append(ContainmentIndex.Windows, other.Windows...)
+	sort.Stable(lshensemble.BySize(ContainmentIndex.DomainRecords))
+	return nil
+}
+
+// RenumberGraphs is a method to change the graphIDs of the windows in a containment index that has not been populated, along with the graphIDs recorded for shared windows
+func (ContainmentIndex *ContainmentIndex) RenumberGraphs(newIDs map[uint32]uint32) error {
+	if ContainmentIndex.numSketches != 0 {
+		return fmt.Errorf("cannot renumber graphs in an index once the LSH Ensemble has been populated")
+	}
+	ContainmentIndex.lock.Lock()
+	defer ContainmentIndex.lock.Unlock()
+	for _, window := range ContainmentIndex.Windows {
+		for i, graphID := range window.SharedWith {
+			if newSharedID, ok := newIDs[graphID]; ok {
+				window.SharedWith[i] = newSharedID
+			}
+		}
+		if newID, ok := newIDs[window.GraphID]; ok {
+			window.GraphID = newID
+		}
+	}
+	return nil
+}
+
+// LoadFromBytes is a method to load the containment index from a byte array
+// the LSH Ensemble is queried from the byte array, so it must not be modified while the index is in use
+func (ContainmentIndex *ContainmentIndex) LoadFromBytes(data []byte) error {
+	lshe, err := ContainmentIndex.decode(data)
+	if err != nil {
+		return err
+	}
+	ContainmentIndex.LSHensemble = lshe
+	ContainmentIndex.numSketches = lshe.NumDomains()
 
 	// get rid of the domain records as they are not needed anymore
 	ContainmentIndex.DomainRecords = nil
-
-	return err
+	return nil
 }
 
 // Query is temp function to check the the index can be queried
+// for a multi-resolution index, only the window set that best matches the query length is queried
 func (ContainmentIndex *ContainmentIndex) Query(querySig []uint64, querySize int, containmentThreshold float64) ([]*lshforest.Key, error) {
-	done := make(chan struct{})
-	defer close(done)
+	return ContainmentIndex.queryEnsemble(ContainmentIndex.LSHensemble, querySig, querySize, containmentThreshold)
+}
+
+// QueryGraphs is a method to query only the windows from a set of graphs, using an LSH Ensemble for each graph
+// the LSH Ensembles for the graphs are built from the windows the first time this method is used, and graphs without windows in the index are ignored
+func (ContainmentIndex *ContainmentIndex) QueryGraphs(querySig []uint64, querySize int, containmentThreshold float64, graphIDs []uint32) ([]*lshforest.Key, error) {
+	ContainmentIndex.partitionOnce.Do(func() {
+		ContainmentIndex.partitionErr = ContainmentIndex.partitionGraphs()
+	})
+	if ContainmentIndex.partitionErr != nil {
+		return nil, ContainmentIndex.partitionErr
+	}
+	results := []*lshforest.Key{}
+	for _, graphID := range graphIDs {
+		lshe, ok := ContainmentIndex.graphEnsembles[graphID]
+		if !ok {
+			continue
+		}
+		hits, err := ContainmentIndex.queryEnsemble(lshe, querySig, querySize, containmentThreshold)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, hits...)
+	}
+	return results, nil
+}
+
+// partitionGraphs is a method to build an LSH Ensemble for the windows from each graph, using the same sketch size and maxK as the index
+func (ContainmentIndex *ContainmentIndex) partitionGraphs() error {
+	ContainmentIndex.lock.Lock()
+	graphRecs := make(map[uint32][]*lshensemble.DomainRecord)
+	for windowID, window := range ContainmentIndex.Windows {
+		size := int(window.WindowSize)
+		if ContainmentIndex.KmerSize != 0 {
+			size += ContainmentIndex.KmerSize - 1
+		}
+		graphRecs[window.GraphID] = append(graphRecs[window.GraphID], &lshensemble.DomainRecord{
+			Key:       uint32(windowID),
+			Size:      size,
+			Signature: window.Sketch,
+		})
+	}
+	ContainmentIndex.lock.Unlock()
+	ContainmentIndex.graphEnsembles = make(map[uint32]*ensemble.Ensemble, len(graphRecs))
+	for graphID, recs := range graphRecs {
+
+		// sort the windows by size and then window ID, so that the ensemble is the same each time it is built
+		sort.Slice(recs, func(i, j int) bool {
+			if recs[i].Size != recs[j].Size {
+				return recs[i].Size < recs[j].Size
+			}
+			return recs[i].Key.(uint32) < recs[j].Key.(uint32)
+		})
+		// each graph ensemble only needs a partition for each window size, as the windows in a window set all have the same size
+		numPart := 1
+		for i := 1; i < len(recs); i++ {
+			if recs[i].Size != recs[i-1].Size {
+				numPart++
+			}
+		}
+		data, err := ensemble.Build(numPart, ContainmentIndex.SketchSize, ContainmentIndex.MaxK, recs)
+		if err != nil {
+			return err
+		}
+		keys := make([]uint32, len(recs))
+		for i, rec := range recs {
+			keys[i] = rec.Key.(uint32)
+		}
+		if ContainmentIndex.graphEnsembles[graphID], err = ensemble.Open(data, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryEnsemble is a method to query an LSH Ensemble built from the windows in the index, checking the containment of each candidate window
+func (ContainmentIndex *ContainmentIndex) queryEnsemble(lshe *ensemble.Ensemble, querySig []uint64, querySize int, containmentThreshold float64) ([]*lshforest.Key, error) {
+	windowSize, domainSize := 0, ContainmentIndex.WindowSize
+	var hits []uint32
+	var err error
+	if len(ContainmentIndex.WindowSizes) > 1 && ContainmentIndex.KmerSize != 0 {
+		windowSize = ContainmentIndex.GetWindowSet(querySize - ContainmentIndex.KmerSize + 1)
+		domainSize = windowSize + ContainmentIndex.KmerSize - 1
+		hits, err = lshe.QueryRange(querySig, querySize, containmentThreshold, domainSize, domainSize)
+	} else {
+		hits, err = lshe.Query(querySig, querySize, containmentThreshold)
+	}
+	if err != nil {
+		return nil, err
+	}
 	results := []*lshforest.Key{}
-	for hit := range ContainmentIndex.LSHensemble.Query(querySig, querySize, containmentThreshold, done) {
+	for _, hit := range hits {
 
-		key, err := ContainmentIndex.getKey(hit.(string))
+		key, err := ContainmentIndex.getKey(hit)
 		if err != nil {
 			return nil, err
 		}
 
+		// skip windows from other window sets which share a partition with the queried window set
+		if windowSize != 0 && int(key.WindowSize) != windowSize {
+			continue
+		}
+
 		// full containment check
 		// TODO: this should be optional
-		if lshensemble.Containment(querySig, key.Sketch, querySize, ContainmentIndex.WindowSize) > containmentThreshold {
+		if lshensemble.Containment(querySig, key.Sketch, querySize, domainSize) > containmentThreshold {
 			results = append(results, key)
 		}
 	}
 	return results, nil
 }
 
-// getKey will return the Key for the stringified version
-func (ContainmentIndex *ContainmentIndex) getKey(keystring string) (*lshforest.Key, error) {
-	ContainmentIndex.lock.Lock()
-	returnKey, ok := ContainmentIndex.LookupMap[keystring]
-	ContainmentIndex.lock.Unlock()
-	if ok {
-		return returnKey, nil
+// GetWindowSet is a method to return the window size of the window set that best matches a read length
+// this is the window set with the closest window size, preferring the larger window set if two are equally close
+func (ContainmentIndex *ContainmentIndex) GetWindowSet(readLength int) int {
+	if len(ContainmentIndex.WindowSizes) == 0 {
+		return 0
 	}
-	return nil, fmt.Errorf("key not found in LSH Ensemble: %v", keystring)
+	best := ContainmentIndex.WindowSizes[0]
+	for _, windowSize := range ContainmentIndex.WindowSizes[1:] {
+		if diff, bestDiff := abs(windowSize-readLength), abs(best-readLength); diff < bestDiff || (diff == bestDiff && windowSize > best) {
+			best = windowSize
+		}
+	}
+	return best
 }
 
-// recs2Chan is a utility function that converts a DomainRecord slice in memory to a DomainRecord channel
-func recs2Chan(recs []*lshensemble.DomainRecord) <-chan *lshensemble.DomainRecord {
-	c := make(chan *lshensemble.DomainRecord, 1000)
-	go func() {
-		for i, r := range recs {
+// sameWindowSizes returns true if two indexes have the same window sets
+func sameWindowSizes(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
 
-			// this is a massive kludge to fix the out of memory issue on index load in wasm
-			// see: https://github.com/golang/go/issues/32840#issuecomment-506929883
-			if runtime.GOOS == "js" && i%10000 == 0 {
-				runtime.GC()
-			}
-			c <- r
+// abs returns the absolute value of an int
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// getKey will return the Key (graph window) for a window ID
+func (ContainmentIndex *ContainmentIndex) getKey(windowID uint32) (*lshforest.Key, error) {
+	ContainmentIndex.lock.RLock()
+	defer ContainmentIndex.lock.RUnlock()
+	if int(windowID) < len(ContainmentIndex.Windows) {
+		return ContainmentIndex.Windows[windowID], nil
+	}
+	return nil, fmt.Errorf("window not found in LSH Ensemble: %d", windowID)
+}
+
+// WindowStats records the number of sketched windows held in the index for a graph
+type WindowStats struct {
+	Windows int // number of windows in the index
+	Merged  int // number of windows that were merged into another window with an identical sketch
+}
+
+// GetWindowStats is a method to count the windows held in the index for each graph
+func (ContainmentIndex *ContainmentIndex) GetWindowStats() map[uint32]*WindowStats {
+	stats := make(map[uint32]*WindowStats)
+	for _, window := range ContainmentIndex.Windows {
+		if _, ok := stats[window.GraphID]; !ok {
+			stats[window.GraphID] = &WindowStats{}
 		}
-		close(c)
-	}()
-	return c
+		stats[window.GraphID].Windows++
+		if len(window.Ref) > 1 {
+			stats[window.GraphID].Merged += len(window.Ref) - 1
+		}
+	}
+	return stats
 }
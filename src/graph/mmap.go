@@ -0,0 +1,31 @@
+//go:build !js && !windows
+// +build !js,!windows
+
+package graph
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile is a function to memory-map a file as read-only, so that the pages are shared with any other process mapping the same file
+func mapFile(filePath string) ([]byte, error) {
+	fh, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(fh.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile is a function to release a memory-mapped file
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,16 @@
+//go:build js || windows
+// +build js windows
+
+package graph
+
+import "io/ioutil"
+
+// mapFile is a function to read a file into memory, as memory-mapping is not supported on this platform
+func mapFile(filePath string) ([]byte, error) {
+	return ioutil.ReadFile(filePath)
+}
+
+// unmapFile is a function to release a file read by mapFile, which is left to the garbage collector on this platform
+func unmapFile(data []byte) error {
+	return nil
+}
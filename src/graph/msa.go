@@ -0,0 +1,162 @@
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/biogo/biogo/alphabet"
+	"github.com/biogo/biogo/seq"
+	"github.com/biogo/biogo/seq/linear"
+	"github.com/biogo/biogo/seq/multi"
+)
+
+// the MSA formats that can be read
+const (
+	UnknownMSA = iota
+	FastaMSA
+	ClustalMSA
+	StockholmMSA
+)
+
+// SniffMSA is a function to identify the format of an MSA file from its first non-empty line
+func SniffMSA(fileName string) (int, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return UnknownMSA, err
+	}
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		switch {
+		case line[0] == '>':
+			return FastaMSA, nil
+		case bytes.HasPrefix(line, []byte("# STOCKHOLM")):
+			return StockholmMSA, nil
+		case bytes.HasPrefix(line, []byte("CLUSTAL")), bytes.HasPrefix(line, []byte("MUSCLE")), bytes.HasPrefix(line, []byte("PROBCONS")):
+			return ClustalMSA, nil
+		}
+		return UnknownMSA, nil
+	}
+	return UnknownMSA, scanner.Err()
+}
+
+// ReadMSA is a function to read an MSA file (aligned FASTA, Clustal or Stockholm) and store it as a Multi, ready for conversion to a GFA
+func ReadMSA(fileName string) (*multi.Multi, error) {
+	format, err := SniffMSA(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if format == UnknownMSA {
+		return nil, fmt.Errorf("could not identify MSA format (aligned FASTA, Clustal or Stockholm): %v", fileName)
+	}
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	// collect the aligned sequences, keeping the order they appear in the file
+	names := []string{}
+	seqs := make(map[string][]byte)
+	addBlock := func(name string, block []byte) {
+		if _, ok := seqs[name]; !ok {
+			names = append(names, name)
+		}
+		seqs[name] = append(seqs[name], block...)
+	}
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+	currentName, firstLine := "", true
+lines:
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue
+		}
+
+		// skip the Clustal/Stockholm header line
+		if firstLine && format != FastaMSA {
+			firstLine = false
+			continue
+		}
+		switch format {
+		case FastaMSA:
+			if trimmed[0] == '>' {
+				fields := bytes.Fields(trimmed[1:])
+				if len(fields) == 0 {
+					return nil, fmt.Errorf("unnamed sequence in MSA: %v", fileName)
+				}
+				currentName = string(fields[0])
+				if _, ok := seqs[currentName]; ok {
+					return nil, fmt.Errorf("duplicate sequence name in MSA: %v (%v)", currentName, fileName)
+				}
+				addBlock(currentName, nil)
+				continue
+			}
+			if currentName == "" {
+				return nil, fmt.Errorf("sequence found before header in MSA: %v", fileName)
+			}
+			addBlock(currentName, trimmed)
+		case ClustalMSA:
+
+			// conservation lines start with whitespace
+			if line[0] == ' ' || line[0] == '\t' {
+				continue
+			}
+			fields := bytes.Fields(trimmed)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("malformed Clustal line in MSA: %v", fileName)
+			}
+			addBlock(string(fields[0]), fields[1])
+		case StockholmMSA:
+			if trimmed[0] == '#' {
+				continue
+			}
+			if bytes.Equal(trimmed, []byte("//")) {
+				break lines
+			}
+			fields := bytes.Fields(trimmed)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("malformed Stockholm line in MSA: %v", fileName)
+			}
+			addBlock(string(fields[0]), fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no sequences found in MSA: %v", fileName)
+	}
+
+	// check the alignment and convert it to a Multi, using upper case bases and '-' for all gaps
+	msa, err := multi.NewMulti(fileName, nil, seq.DefaultConsensus)
+	if err != nil {
+		return nil, err
+	}
+	alignmentLength := len(seqs[names[0]])
+	for _, name := range names {
+		aligned := seqs[name]
+		if len(aligned) != alignmentLength {
+			return nil, fmt.Errorf("aligned sequences are not all the same length: %v (%v)", name, fileName)
+		}
+		letters := make([]alphabet.Letter, len(aligned))
+		for i, base := range bytes.ToUpper(aligned) {
+			if base == '.' || base == '~' {
+				base = '-'
+			}
+			letters[i] = alphabet.Letter(base)
+		}
+		if err := msa.Add(linear.NewSeq(name, letters, alphabet.DNA)); err != nil {
+			return nil, err
+		}
+	}
+	return msa, nil
+}
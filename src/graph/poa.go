@@ -0,0 +1,347 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/will-rowe/baby-groot/src/seqio"
+)
+
+// the scoring scheme used to align sequences to the partial order graph
+const (
+	poaMatch    = 2
+	poaMismatch = -4
+	poaGap      = -4
+)
+
+// poaNode is a single base in a partial order alignment graph
+type poaNode struct {
+	base    byte
+	inEdges []int
+	outEdge map[int]struct{}
+	aligned []int    // other nodes that have been aligned to the same column as this node
+	pathIDs []uint32 // the sequences which use this node
+}
+
+// poaGraph is a partial order alignment graph, which is built one sequence at a time
+type poaGraph struct {
+	nodes []*poaNode
+	order []int // the nodes in topological order
+}
+
+// CreatePOAgraph is a GrootGraph constructor that takes a set of unaligned sequences, builds a partial order alignment graph from them and then stores it as a GrootGraph
+func CreatePOAgraph(seqs []*seqio.Sequence, id uint32) (*GrootGraph, error) {
+	if len(seqs) == 0 {
+		return nil, fmt.Errorf("no sequences supplied to build a graph from")
+	}
+	poa := &poaGraph{}
+	seenNames := make(map[string]struct{})
+	for pathID, seq := range seqs {
+		if len(seq.Seq) == 0 {
+			return nil, fmt.Errorf("empty sequence can't be added to graph: %v", string(seq.ID))
+		}
+		if _, ok := seenNames[string(seq.ID)]; ok {
+			return nil, fmt.Errorf("duplicate sequence name can't be added to graph: %v", string(seq.ID))
+		}
+		seenNames[string(seq.ID)] = struct{}{}
+		poa.addSequence(seq.Seq, uint32(pathID))
+	}
+
+	// squash the single base nodes into segments and store them as a GrootGraph
+	newGraph := &GrootGraph{
+		GraphID:    id,
+		Paths:      make(map[uint32][]byte),
+		Lengths:    make(map[uint32]int),
+		NodeLookup: make(map[uint64]int),
+	}
+	for pathID, seq := range seqs {
+		newGraph.Paths[uint32(pathID)] = append([]byte(nil), seq.ID...)
+	}
+	poa.squash(newGraph)
+
+	// get and store the lengths of each sequence held in the graph
+	pathSeqs, err := newGraph.Graph2Seqs()
+	if err != nil {
+		return nil, err
+	}
+	for pathID, pathSeq := range pathSeqs {
+		newGraph.Lengths[pathID] = len(pathSeq)
+		if string(pathSeq) != string(seqs[pathID].Seq) {
+			return nil, fmt.Errorf("partial order alignment did not preserve sequence: %v", string(seqs[pathID].ID))
+		}
+	}
+	return newGraph, nil
+}
+
+// addNode is a method to add a new base to the partial order graph, returning the node index
+func (poa *poaGraph) addNode(base byte) int {
+	poa.nodes = append(poa.nodes, &poaNode{base: base, outEdge: make(map[int]struct{})})
+	return len(poa.nodes) - 1
+}
+
+// addEdge is a method to connect two nodes in the partial order graph
+func (poa *poaGraph) addEdge(from, to int) {
+	if _, ok := poa.nodes[from].outEdge[to]; ok {
+		return
+	}
+	poa.nodes[from].outEdge[to] = struct{}{}
+	poa.nodes[to].inEdges = append(poa.nodes[to].inEdges, from)
+}
+
+// addSequence is a method to align a sequence to the partial order graph and then fuse it into the graph
+func (poa *poaGraph) addSequence(seq []byte, pathID uint32) {
+	var pathNodes []int
+
+	// the first sequence is just added as a linear chain of nodes
+	if len(poa.nodes) == 0 {
+		for _, base := range seq {
+			pathNodes = append(pathNodes, poa.addNode(base))
+		}
+	} else {
+		for _, pair := range poa.align(seq) {
+			nodeIdx, seqIdx := pair[0], pair[1]
+
+			// the graph has a base that this sequence doesn't
+			if seqIdx == -1 {
+				continue
+			}
+			base := seq[seqIdx]
+
+			// the sequence has a base that the graph doesn't
+			if nodeIdx == -1 {
+				pathNodes = append(pathNodes, poa.addNode(base))
+				continue
+			}
+
+			// match
+			if poa.nodes[nodeIdx].base == base {
+				pathNodes = append(pathNodes, nodeIdx)
+				continue
+			}
+
+			// mismatch - use an existing node from the aligned column if there is one with this base, otherwise make a new one
+			fused := -1
+			for _, alignedIdx := range poa.nodes[nodeIdx].aligned {
+				if poa.nodes[alignedIdx].base == base {
+					fused = alignedIdx
+					break
+				}
+			}
+			if fused == -1 {
+				fused = poa.addNode(base)
+				column := append([]int{nodeIdx}, poa.nodes[nodeIdx].aligned...)
+				for _, alignedIdx := range column {
+					poa.nodes[alignedIdx].aligned = append(poa.nodes[alignedIdx].aligned, fused)
+				}
+				poa.nodes[fused].aligned = column
+			}
+			pathNodes = append(pathNodes, fused)
+		}
+	}
+
+	// thread the sequence through the graph
+	for i, nodeIdx := range pathNodes {
+		poa.nodes[nodeIdx].pathIDs = append(poa.nodes[nodeIdx].pathIDs, pathID)
+		if i > 0 {
+			poa.addEdge(pathNodes[i-1], nodeIdx)
+		}
+	}
+	poa.topoSort()
+}
+
+// topoSort is a method to order the nodes of the partial order graph (Kahn's algorithm)
+func (poa *poaGraph) topoSort() {
+	inDegree := make([]int, len(poa.nodes))
+	for _, node := range poa.nodes {
+		for to := range node.outEdge {
+			inDegree[to]++
+		}
+	}
+	queue := []int{}
+	for i := range poa.nodes {
+		if inDegree[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	poa.order = poa.order[:0]
+	for len(queue) > 0 {
+		nodeIdx := queue[0]
+		queue = queue[1:]
+		poa.order = append(poa.order, nodeIdx)
+		outEdges := make([]int, 0, len(poa.nodes[nodeIdx].outEdge))
+		for to := range poa.nodes[nodeIdx].outEdge {
+			outEdges = append(outEdges, to)
+		}
+		sort.Ints(outEdges)
+		for _, to := range outEdges {
+			inDegree[to]--
+			if inDegree[to] == 0 {
+				queue = append(queue, to)
+			}
+		}
+	}
+}
+
+// align is a method to globally align a sequence to the partial order graph
+// it returns the aligned pairs of node index and sequence index (-1 indicates a gap)
+func (poa *poaGraph) align(seq []byte) [][2]int {
+	numNodes, seqLength := len(poa.order), len(seq)
+
+	// rank relates a node index to its row in the DP matrix (row 0 is the virtual start)
+	rank := make([]int, len(poa.nodes))
+	for i, nodeIdx := range poa.order {
+		rank[nodeIdx] = i + 1
+	}
+
+	// fill the DP matrix, recording the traceback (0 = diagonal, 1 = node gap, 2 = sequence gap) and the predecessor row used
+	score := make([][]int32, numNodes+1)
+	move := make([][]uint8, numNodes+1)
+	from := make([][]int32, numNodes+1)
+	for i := range score {
+		score[i] = make([]int32, seqLength+1)
+		move[i] = make([]uint8, seqLength+1)
+		from[i] = make([]int32, seqLength+1)
+	}
+	for j := 1; j <= seqLength; j++ {
+		score[0][j] = int32(j * poaGap)
+		move[0][j] = 2
+	}
+	for i := 1; i <= numNodes; i++ {
+		node := poa.nodes[poa.order[i-1]]
+		preds := []int32{0}
+		if len(node.inEdges) != 0 {
+			preds = preds[:0]
+			for _, pred := range node.inEdges {
+				preds = append(preds, int32(rank[pred]))
+			}
+		}
+		for j := 0; j <= seqLength; j++ {
+			best, bestMove, bestFrom := int32(0), uint8(1), preds[0]
+			first := true
+			for _, p := range preds {
+				if s := score[p][j] + poaGap; first || s > best {
+					best, bestMove, bestFrom = s, 1, p
+					first = false
+				}
+				if j > 0 {
+					sub := int32(poaMismatch)
+					if node.base == seq[j-1] {
+						sub = poaMatch
+					}
+					if s := score[p][j-1] + sub; s > best {
+						best, bestMove, bestFrom = s, 0, p
+					}
+				}
+			}
+			if j > 0 {
+				if s := score[i][j-1] + poaGap; s > best {
+					best, bestMove, bestFrom = s, 2, int32(i)
+				}
+			}
+			score[i][j], move[i][j], from[i][j] = best, bestMove, bestFrom
+		}
+	}
+
+	// the alignment finishes at the best scoring sink node
+	endRow, endScore := 0, int32(0)
+	for i := 1; i <= numNodes; i++ {
+		if len(poa.nodes[poa.order[i-1]].outEdge) != 0 {
+			continue
+		}
+		if endRow == 0 || score[i][seqLength] > endScore {
+			endRow, endScore = i, score[i][seqLength]
+		}
+	}
+
+	// traceback
+	pairs := [][2]int{}
+	i, j := endRow, seqLength
+	for i > 0 || j > 0 {
+		if i == 0 {
+			pairs = append(pairs, [2]int{-1, j - 1})
+			j--
+			continue
+		}
+		switch move[i][j] {
+		case 0:
+			pairs = append(pairs, [2]int{poa.order[i-1], j - 1})
+			i, j = int(from[i][j]), j-1
+		case 1:
+			pairs = append(pairs, [2]int{poa.order[i-1], -1})
+			i = int(from[i][j])
+		case 2:
+			pairs = append(pairs, [2]int{-1, j - 1})
+			j--
+		}
+	}
+	for left, right := 0, len(pairs)-1; left < right; left, right = left+1, right-1 {
+		pairs[left], pairs[right] = pairs[right], pairs[left]
+	}
+	return pairs
+}
+
+// squash is a method to merge unbranched runs of nodes (used by the same sequences) into segments and add them to a GrootGraph
+func (poa *poaGraph) squash(grootGraph *GrootGraph) {
+	segmentOf := make([]uint64, len(poa.nodes))
+	segments := []*GrootGraphNode{}
+	lastNode := make([]int, 0)
+	for _, nodeIdx := range poa.order {
+		node := poa.nodes[nodeIdx]
+
+		// extend the current segment if this node is the only successor of the previous node, and vice versa
+		if len(node.inEdges) == 1 && len(segments) != 0 {
+			pred := node.inEdges[0]
+			prevIdx := lastNode[len(lastNode)-1]
+			if pred == prevIdx && len(poa.nodes[pred].outEdge) == 1 && samePaths(poa.nodes[pred].pathIDs, node.pathIDs) {
+				segment := segments[len(segments)-1]
+				segment.Sequence = append(segment.Sequence, node.base)
+				segment.SegmentLength++
+				segmentOf[nodeIdx] = segment.SegmentID
+				lastNode[len(lastNode)-1] = nodeIdx
+				continue
+			}
+		}
+
+		// otherwise start a new segment
+		segment := &GrootGraphNode{
+			SegmentID:     uint64(len(segments) + 1),
+			SegmentLength: 1,
+			Sequence:      []byte{node.base},
+			PathIDs:       append([]uint32(nil), node.pathIDs...),
+		}
+		segmentOf[nodeIdx] = segment.SegmentID
+		segments = append(segments, segment)
+		lastNode = append(lastNode, nodeIdx)
+	}
+
+	// add the edges between segments, using the final node in each segment
+	for i, segment := range segments {
+		outEdges := []int{}
+		for to := range poa.nodes[lastNode[i]].outEdge {
+			outEdges = append(outEdges, to)
+		}
+		sort.Ints(outEdges)
+		for _, to := range outEdges {
+			segment.OutEdges = append(segment.OutEdges, segmentOf[to])
+		}
+		grootGraph.NodeLookup[segment.SegmentID] = i
+	}
+	grootGraph.SortedNodes = segments
+}
+
+// samePaths checks if two nodes are used by the same set of sequences
+func samePaths(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[uint32]struct{}, len(a))
+	for _, id := range a {
+		seen[id] = struct{}{}
+	}
+	for _, id := range b {
+		if _, ok := seen[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
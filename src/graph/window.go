@@ -0,0 +1,193 @@
+package graph
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/will-rowe/baby-groot/src/lshforest"
+	"github.com/will-rowe/baby-groot/src/minhash"
+	"github.com/will-rowe/baby-groot/src/seqio"
+)
+
+// WindowOptions holds the parameters used to window and sketch a graph
+type WindowOptions struct {
+	WindowSize    int  // the length of the windows
+	KmerSize      int  // the k-mer size used to sketch the windows
+	SketchSize    int  // the number of minimums in each sketch
+	Stranded      bool // sketch the forward k-mers of each window and of its reverse complement separately, giving a window for each strand
+	DustLevel     int  // mask low-complexity regions at this DUST level before windowing (0 turns masking off)
+	MaxTraversals int  // also window every traversal of the graph, up to this many from each window start (0 only windows the paths)
+	Stride        int  // only keep every Stride-th window of each path (the last window of a path is always kept)
+	Minimizers    bool // instead of a fixed stride, keep the windows whose first k-mer is the minimizer of Stride consecutive window starts
+	NumWorkers    int  // the number of go routines used to window the paths (at least 1 is used)
+
+	Background    *Background // leave out the windows dominated by these background k-mers (nil keeps every window)
+	MaxBackground float64     // the proportion of background k-mers at which a window is left out
+}
+
+// selectWindows is a function to choose which windows of a path sequence are kept, returning nil if every window is kept
+func selectWindows(sequence []byte, numWindows int, opts *WindowOptions) []bool {
+	if opts.Stride <= 1 || numWindows < 1 {
+		return nil
+	}
+	var keep []bool
+	if opts.Minimizers {
+		anchors := minhash.KmerHashes(sequence[:numWindows+opts.KmerSize-1], uint(opts.KmerSize))
+		keep = minhash.Minimizers(anchors, opts.Stride)
+	} else {
+		keep = make([]bool, numWindows)
+		for i := 0; i < numWindows; i += opts.Stride {
+			keep[i] = true
+		}
+	}
+
+	// keep the last window so that the end of the path is covered
+	keep[numWindows-1] = true
+	return keep
+}
+
+// KmerHashes is a method to return the hashes of the canonical k-mers in the graph (see minhash.KmerHashes), sorted and without duplicates
+// the k-mers are taken from the paths, along with the traversals that are windowed if maxTraversals is greater than 0 (up to maxTraversals k-mer long traversals from each base)
+// if dustLevel is greater than 0, low-complexity regions are masked in the same way as the windows and their k-mers are left out
+func (GrootGraph *GrootGraph) KmerHashes(kmerSize, dustLevel, maxTraversals int) ([]uint64, error) {
+	pathSeqs, err := GrootGraph.Graph2Seqs()
+	if err != nil {
+		return nil, err
+	}
+	hashes := []uint64{}
+	addSequence := func(sequence []byte) {
+		if dustLevel > 0 {
+			sequence, _ = seqio.DustMask(sequence, dustLevel)
+		}
+		for _, hash := range minhash.KmerHashes(sequence, uint(kmerSize)) {
+			if hash != math.MaxUint64 {
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	for _, sequence := range pathSeqs {
+		addSequence(sequence)
+	}
+	if maxTraversals > 0 {
+		GrootGraph.windowTraversals(kmerSize, maxTraversals, func(window *lshforest.Key, sequence []byte) {
+			addSequence(sequence)
+		})
+	}
+
+	// sort the hashes and dedup them across the paths and traversals
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	unique := 0
+	for i, hash := range hashes {
+		if i == 0 || hash != hashes[unique-1] {
+			hashes[unique] = hash
+			unique++
+		}
+	}
+	return hashes[:unique], nil
+}
+
+// WindowMerger combines windows from the same graph, window set and strand that have identical sketches, as the windows are streamed
+// it keeps a reference to every window it is given, so a merger should be used for each graph rather than for a whole index
+// it is safe for concurrent use, and a window that has been added can still gain the paths and nodes of windows added later
+type WindowMerger struct {
+	lock    sync.Mutex // lock is a mutex to manage concurrent adds
+	buckets map[uint64][]*lshforest.Key
+	windows []*lshforest.Key
+}
+
+// NewWindowMerger is the constructor
+func NewWindowMerger() *WindowMerger {
+	return &WindowMerger{buckets: make(map[uint64][]*lshforest.Key)}
+}
+
+// Add is a method to add a window to the merger, returning false if the window was combined with an existing window
+func (WindowMerger *WindowMerger) Add(window *lshforest.Key) bool {
+	hash := hashWindow(window)
+	WindowMerger.lock.Lock()
+	defer WindowMerger.lock.Unlock()
+	for _, existingWindow := range WindowMerger.buckets[hash] {
+		if !sameWindow(existingWindow, window) {
+			continue
+		}
+
+		// add nodes to the contained nodes list
+		for node := range window.ContainedNodes {
+			existingWindow.ContainedNodes[node]++
+		}
+
+		// add pathID
+		existingWindow.Ref = append(existingWindow.Ref, window.Ref...)
+		return false
+	}
+	WindowMerger.buckets[hash] = append(WindowMerger.buckets[hash], window)
+	WindowMerger.windows = append(WindowMerger.windows, window)
+	return true
+}
+
+// Windows is a method to return the combined windows, in the order they were first added
+func (WindowMerger *WindowMerger) Windows() []*lshforest.Key {
+	WindowMerger.lock.Lock()
+	defer WindowMerger.lock.Unlock()
+	return WindowMerger.windows
+}
+
+// SortWindows is a function to sort windows by graph, window size, strand, start (node and offset), number of paths and then sketch
+// the windows are streamed in an unpredictable order, so this is used to give them the same window IDs each time an index is built
+func SortWindows(windows []*lshforest.Key) {
+	sort.Slice(windows, func(i, j int) bool {
+		a, b := windows[i], windows[j]
+		switch {
+		case a.GraphID != b.GraphID:
+			return a.GraphID < b.GraphID
+		case a.WindowSize != b.WindowSize:
+			return a.WindowSize < b.WindowSize
+		case a.RC != b.RC:
+			return !a.RC
+		case a.Node != b.Node:
+			return a.Node < b.Node
+		case a.OffSet != b.OffSet:
+			return a.OffSet < b.OffSet
+		case len(a.Ref) != len(b.Ref):
+			return len(a.Ref) < len(b.Ref)
+		}
+		for k := 0; k < len(a.Sketch) && k < len(b.Sketch); k++ {
+			if a.Sketch[k] != b.Sketch[k] {
+				return a.Sketch[k] < b.Sketch[k]
+			}
+		}
+		return len(a.Sketch) < len(b.Sketch)
+	})
+}
+
+// hashWindow is a function to hash the graph, window size, strand and sketch of a window
+func hashWindow(window *lshforest.Key) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, window.GraphID)
+	binary.LittleEndian.PutUint32(buf[4:], window.WindowSize)
+	h.Write(buf)
+	if window.RC {
+		h.Write([]byte{1})
+	}
+	for _, value := range window.Sketch {
+		binary.LittleEndian.PutUint64(buf, value)
+		h.Write(buf)
+	}
+	return h.Sum64()
+}
+
+// sameWindow is a function to check if two windows are from the same graph, window size and strand, and have identical sketches
+func sameWindow(a, b *lshforest.Key) bool {
+	if a.GraphID != b.GraphID || a.WindowSize != b.WindowSize || a.RC != b.RC || len(a.Sketch) != len(b.Sketch) {
+		return false
+	}
+	for i := range a.Sketch {
+		if a.Sketch[i] != b.Sketch[i] {
+			return false
+		}
+	}
+	return true
+}
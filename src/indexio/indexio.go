@@ -0,0 +1,174 @@
+// Package indexio reads and writes the GROOT index files. Each file is framed with magic bytes, a file kind and a format version, followed by named sections that are checksummed. Files written in older formats are upgraded on load by a chain of explicit migrations.
+package indexio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Magic is written at the start of every GROOT index file
+const Magic = "GROOTIDX"
+
+// LegacySection is the name given to the contents of an unframed (format version 0) file, which was a single gob encoded struct
+const LegacySection = "GOB0"
+
+// File is a framed GROOT index file, holding named sections
+type File struct {
+	Kind     string
+	Version  uint32
+	sections map[string][]byte
+	order    []string
+}
+
+// Migration upgrades a file from one format version to the next
+type Migration func(*File) error
+
+// NewFile is the constructor
+func NewFile(kind string, version uint32) *File {
+	return &File{Kind: kind, Version: version, sections: make(map[string][]byte)}
+}
+
+// AddSection is a method to add a named section to the file, replacing any existing section with the same name
+// section names must be 4 bytes long
+func (File *File) AddSection(name string, payload []byte) error {
+	if len(name) != 4 {
+		return fmt.Errorf("section names must be 4 bytes long: %v", name)
+	}
+	if _, ok := File.sections[name]; !ok {
+		File.order = append(File.order, name)
+	}
+	File.sections[name] = payload
+	return nil
+}
+
+// GetSection is a method to return the payload of a named section
+func (File *File) GetSection(name string) ([]byte, error) {
+	payload, ok := File.sections[name]
+	if !ok {
+		return nil, fmt.Errorf("%v file is missing the %v section", File.Kind, name)
+	}
+	return payload, nil
+}
+
+// RemoveSection is a method to delete a named section from the file
+func (File *File) RemoveSection(name string) {
+	if _, ok := File.sections[name]; !ok {
+		return
+	}
+	delete(File.sections, name)
+	for i, existing := range File.order {
+		if existing == name {
+			File.order = append(File.order[:i], File.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Encode is a method to write the framed file
+func (File *File) Encode(w io.Writer) error {
+	if len(File.Kind) != 4 {
+		return fmt.Errorf("file kind must be 4 bytes long: %v", File.Kind)
+	}
+	header := make([]byte, 0, len(Magic)+12)
+	header = append(header, Magic...)
+	header = append(header, File.Kind...)
+	header = appendUint32(header, File.Version)
+	header = appendUint32(header, uint32(len(File.order)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, name := range File.order {
+		payload := File.sections[name]
+		sectionHeader := make([]byte, 0, 16)
+		sectionHeader = append(sectionHeader, name...)
+		sectionHeader = appendUint64(sectionHeader, uint64(len(payload)))
+		sectionHeader = appendUint32(sectionHeader, crc32.ChecksumIEEE(payload))
+		if _, err := w.Write(sectionHeader); err != nil {
+			return err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode is a function to read a framed file of the expected kind and then migrate it to the current format version
+// the current format version is the number of migrations, with migrations[i] upgrading a file from version i to version i+1
+// unframed files are treated as format version 0, with the whole file held in the LegacySection
+func Decode(data []byte, kind string, migrations []Migration) (*File, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%v file appears empty", kind)
+	}
+	var file *File
+	if !bytes.HasPrefix(data, []byte(Magic)) {
+		file = NewFile(kind, 0)
+		file.AddSection(LegacySection, data)
+	} else {
+		var err error
+		if file, err = decodeFrames(data); err != nil {
+			return nil, err
+		}
+	}
+	if file.Kind != kind {
+		return nil, fmt.Errorf("expected a %v file but found a %v file", kind, file.Kind)
+	}
+	currentVersion := uint32(len(migrations))
+	if file.Version > currentVersion {
+		return nil, fmt.Errorf("%v file has format version %d, but this version of groot only supports up to version %d (please upgrade groot)", kind, file.Version, currentVersion)
+	}
+	for file.Version < currentVersion {
+		if err := migrations[file.Version](file); err != nil {
+			return nil, fmt.Errorf("could not migrate %v file from format version %d: %v", kind, file.Version, err)
+		}
+		file.Version++
+	}
+	return file, nil
+}
+
+// decodeFrames is a function to read the header and sections of a framed file
+func decodeFrames(data []byte) (*File, error) {
+	headerLength := len(Magic) + 12
+	if len(data) < headerLength {
+		return nil, fmt.Errorf("index file header is truncated")
+	}
+	file := NewFile(string(data[len(Magic):len(Magic)+4]), binary.LittleEndian.Uint32(data[len(Magic)+4:]))
+	numSections := binary.LittleEndian.Uint32(data[len(Magic)+8:])
+	data = data[headerLength:]
+	for i := uint32(0); i < numSections; i++ {
+		if len(data) < 16 {
+			return nil, fmt.Errorf("%v file is truncated", file.Kind)
+		}
+		name := string(data[:4])
+		length := binary.LittleEndian.Uint64(data[4:])
+		checksum := binary.LittleEndian.Uint32(data[12:])
+		data = data[16:]
+		if uint64(len(data)) < length {
+			return nil, fmt.Errorf("%v file is truncated (%v section)", file.Kind, name)
+		}
+		payload := data[:length]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			return nil, fmt.Errorf("%v file is corrupted (checksum mismatch in %v section)", file.Kind, name)
+		}
+		file.AddSection(name, payload)
+		data = data[length:]
+	}
+	return file, nil
+}
+
+// appendUint32 appends a little endian uint32 to a byte slice
+func appendUint32(b []byte, v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	return append(b, buf...)
+}
+
+// appendUint64 appends a little endian uint64 to a byte slice
+func appendUint64(b []byte, v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	return append(b, buf...)
+}
@@ -0,0 +1,250 @@
+package indexio
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ekzhu/lshensemble"
+	"github.com/will-rowe/baby-groot/src/lshforest"
+)
+
+var (
+	testInfo = &InfoRecord{
+		Version:    "test",
+		KmerSize:   21,
+		SketchSize: 42,
+		WindowSize: 100,
+		NumPart:    8,
+		MaxK:       4,
+		Sources:    map[uint32]string{0: "cluster-1"},
+	}
+	testGraphs = []*GraphRecord{
+		{
+			GraphID:     0,
+			SortedNodes: []*NodeRecord{{SegmentID: 1, SegmentLength: 4, Sequence: []byte("ACTG"), PathIDs: []uint32{0}}},
+			Paths:       map[uint32][]byte{0: []byte("seq1")},
+			Lengths:     map[uint32]int{0: 4},
+		},
+	}
+	testIndex = &IndexRecord{
+		NumPart:    2,
+		MaxK:       1,
+		WindowSize: 120,
+		SketchSize: 3,
+		Windows: []*lshforest.Key{
+			{GraphID: 0, Node: 1, Ref: []uint32{0}, Sketch: []uint64{1, 2, 3}, ContainedNodes: map[uint64]float64{1: 1}},
+			{GraphID: 0, Node: 1, OffSet: 5, Ref: []uint32{0, 1}, Sketch: []uint64{4, 5, 6}},
+		},
+		DomainRecords: []*lshensemble.DomainRecord{
+			{Key: uint32(0), Size: 120, Signature: []uint64{1, 2, 3}},
+			{Key: uint32(1), Size: 120, Signature: []uint64{4, 5, 6}},
+		},
+	}
+
+	// the test index with string window keys, as written before window IDs were used (format version 0)
+	testStringKeyedIndex = &stringKeyedIndex{
+		NumPart:    2,
+		MaxK:       1,
+		WindowSize: 120,
+		SketchSize: 3,
+		LookupMap: map[string]*lshforest.Key{
+			"g0n1o0p1": testIndex.Windows[0],
+			"g0n1o5p2": testIndex.Windows[1],
+		},
+		DomainRecords: []*lshensemble.DomainRecord{
+			{Key: "g0n1o0p1", Size: 120, Signature: []uint64{1, 2, 3}},
+			{Key: "g0n1o5p2", Size: 120, Signature: []uint64{4, 5, 6}},
+		},
+	}
+)
+
+// test the framing
+func TestFile(t *testing.T) {
+	file := NewFile("TEST", 0)
+	if err := file.AddSection("ABCD", []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.AddSection("TOOLONG", nil); err == nil {
+		t.Fatal("section names must be 4 bytes")
+	}
+	buf := &bytes.Buffer{}
+	if err := file.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	decoded, err := Decode(data, "TEST", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := decoded.GetSection("ABCD"); err != nil || string(payload) != "payload" {
+		t.Fatal("section was not decoded")
+	}
+	if _, err := Decode(data, "INFO", nil); err == nil {
+		t.Fatal("wrong file kind should not be decoded")
+	}
+
+	// corrupt the payload
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-1] = 'X'
+	if _, err := Decode(corrupted, "TEST", nil); err == nil {
+		t.Fatal("corrupted file should not be decoded")
+	}
+	if _, err := Decode(data[:len(data)-2], "TEST", nil); err == nil {
+		t.Fatal("truncated file should not be decoded")
+	}
+}
+
+// test migrations are applied in order and that newer formats are rejected
+func TestMigrations(t *testing.T) {
+	steps := []string{}
+	migrations := []Migration{
+		func(file *File) error {
+			steps = append(steps, "0->1")
+			return nil
+		},
+		func(file *File) error {
+			steps = append(steps, "1->2")
+			return file.AddSection("NEWS", []byte("added"))
+		},
+	}
+	buf := &bytes.Buffer{}
+	if err := NewFile("TEST", 1).Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	file, err := Decode(buf.Bytes(), "TEST", migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Version != 2 || len(steps) != 1 || steps[0] != "1->2" {
+		t.Fatalf("wrong migrations applied: %v", steps)
+	}
+	if _, err := file.GetSection("NEWS"); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := NewFile("TEST", 3).Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(buf.Bytes(), "TEST", migrations); err == nil {
+		t.Fatal("newer format version should be rejected")
+	}
+}
+
+// test the info file can be written and read back
+func TestInfo(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteInfo(buf, testInfo, testGraphs); err != nil {
+		t.Fatal(err)
+	}
+	info, graphs, err := ReadInfo(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.KmerSize != testInfo.KmerSize || info.Sources[0] != "cluster-1" {
+		t.Fatal("info not read correctly")
+	}
+	if len(graphs) != 1 || string(graphs[0].SortedNodes[0].Sequence) != "ACTG" {
+		t.Fatal("graphs not read correctly")
+	}
+}
+
+// test the index file can be written and read back, with the signatures restored from the windows
+func TestIndex(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteIndex(buf, testIndex); err != nil {
+		t.Fatal(err)
+	}
+	index, err := ReadIndex(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIndex(t, index)
+}
+
+// test unframed gob files (format version 0) are migrated
+func TestLegacyMigration(t *testing.T) {
+	legacyIndex := &bytes.Buffer{}
+	if err := gob.NewEncoder(legacyIndex).Encode(testStringKeyedIndex); err != nil {
+		t.Fatal(err)
+	}
+	index, err := ReadIndex(legacyIndex.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIndex(t, index)
+
+	legacyInfo := &bytes.Buffer{}
+	if err := gob.NewEncoder(legacyInfo).Encode(struct {
+		Version  string
+		KmerSize int
+		NumProc  int
+		Store    map[uint32]*GraphRecord
+	}{Version: "0.8.0", KmerSize: 31, NumProc: 4, Store: map[uint32]*GraphRecord{0: testGraphs[0]}}); err != nil {
+		t.Fatal(err)
+	}
+	info, graphs, err := ReadInfo(legacyInfo.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "0.8.0" || info.KmerSize != 31 || len(graphs) != 1 {
+		t.Fatal("legacy info not migrated correctly")
+	}
+}
+
+// test the index files written by the baseline version of groot (format version 0) are migrated
+func TestBaselineMigration(t *testing.T) {
+	data, err := ioutil.ReadFile("test-data/baseline-dummy-db/groot.gg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, graphs, err := ReadInfo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.KmerSize == 0 || info.WindowSize == 0 || len(graphs) != 1 {
+		t.Fatalf("baseline info not migrated correctly (k-mer size %d, window size %d, %d graphs)", info.KmerSize, info.WindowSize, len(graphs))
+	}
+	data, err = ioutil.ReadFile("test-data/baseline-dummy-db/groot.lshe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ReadIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Windows) != 4091 || len(index.DomainRecords) == 0 || index.Ensemble == nil || index.Ensemble.NumDomains() != len(index.DomainRecords) {
+		t.Fatalf("baseline index not migrated correctly (%d windows, %d domain records)", len(index.Windows), len(index.DomainRecords))
+	}
+	for _, window := range index.Windows {
+		if window.GraphID != graphs[0].GraphID {
+			t.Fatal("baseline index has windows from a graph that is not in the info file")
+		}
+	}
+}
+
+// checkIndex checks a decoded index matches the test index
+func checkIndex(t *testing.T, index *IndexRecord) {
+	if index.WindowSize != testIndex.WindowSize || len(index.Windows) != len(testIndex.Windows) || len(index.DomainRecords) != len(testIndex.DomainRecords) {
+		t.Fatal("index not read correctly")
+	}
+	for i, rec := range index.DomainRecords {
+		if rec.Key != testIndex.DomainRecords[i].Key || len(rec.Signature) != 3 || rec.Signature[0] != testIndex.DomainRecords[i].Signature[0] {
+			t.Fatal("domain records not restored correctly")
+		}
+	}
+	if index.Windows[1].OffSet != 5 || len(index.Windows[1].Ref) != 2 {
+		t.Fatal("windows not read correctly")
+	}
+	if index.Ensemble == nil || index.Ensemble.NumDomains() != len(testIndex.DomainRecords) {
+		t.Fatal("LSH Ensemble not read correctly")
+	}
+	hits, err := index.Ensemble.Query([]uint64{4, 5, 6}, 120, 0.9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0] != 1 {
+		t.Fatalf("LSH Ensemble query returned wrong hits: %v", hits)
+	}
+}
@@ -0,0 +1,507 @@
+package indexio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ekzhu/lshensemble"
+	proto "github.com/golang/protobuf/proto"
+	"github.com/will-rowe/baby-groot/src/ensemble"
+	"github.com/will-rowe/baby-groot/src/lshforest"
+)
+
+// the file kinds and sections used by GROOT
+const (
+	InfoKind         = "INFO" // groot.gg
+	IndexKind        = "LSHE" // groot.lshe
+	ParameterSection = "PARM"
+	GraphSection     = "GRPH"
+	WindowSection    = "WNDW"
+	EnsembleSection  = "ENSM"
+	TreeSection      = "TREE"
+)
+
+// InfoMigrations upgrades groot.gg files to the current format version
+var InfoMigrations = []Migration{migrateLegacyInfo}
+
+// IndexMigrations upgrades groot.lshe files to the current format version
+var IndexMigrations = []Migration{migrateLegacyIndex}
+
+// InfoRecord is the on-disk record of the index parameters and graph provenance (format version 1)
+type InfoRecord struct {
+	Version    string
+	KmerSize   int
+	SketchSize int
+	WindowSize int
+	NumPart    int
+	MaxK       int
+	Sources    map[uint32]string
+	Inputs     map[uint32]InputRecord
+	Database   *ReleaseRecord
+	BuildDB    BuildDBRecord
+	NumShards  int
+	ShardID    int
+
+	// the window sizes of a multi-resolution index, which is empty if only WindowSize was used
+	WindowSizes []int
+
+	// set if the index was sketched with forward k-mers and holds a window for each strand
+	Stranded bool
+
+	// the minimum sketch similarity for windows from different graphs to be tagged as shared (zero for indexes built before this was recorded)
+	SharedSimilarity float64
+
+	// the DUST score threshold (x10) used to mask low-complexity sequence, which is zero if masking was off
+	DustLevel int
+
+	// the maximum number of graph traversals windowed from each window start, which is zero if only the paths were windowed
+	MaxTraversals int
+
+	// the stride between indexed windows (zero if every window was indexed), and whether minimizers were used to select the windows instead of a fixed stride
+	WindowStride     int
+	MinimizerWindows bool
+
+	// the encoded Bloom filter of the k-mers in the graphs, used to prefilter reads (empty for indexes built before prefiltering)
+	Prefilter []byte
+
+	// the sampling scale of the coarse index (zero if there is no coarse index), and the k-mers sampled from each graph
+	CoarseScale    int
+	CoarseSketches map[uint32][]uint64
+
+	// the background sequences subtracted from the graph windows (nil if there was no background), their k-mers and the path regions of each graph that were left out
+	Background        *BackgroundRecord
+	BackgroundKmers   []uint64
+	BackgroundRegions map[uint32][]BackgroundRegionRecord
+
+	// the settings from the last run, which are carried between the sketch and haplotype commands
+	NumProc              int
+	ContainmentThreshold float64
+	IndexDir             string
+	Sketch               SketchRecord
+	Haplotype            HaploRecord
+}
+
+// SketchRecord is the on-disk record of the sketch settings (format version 1)
+type SketchRecord struct {
+	Fasta            bool
+	BloomFilter      bool
+	MinKmerCoverage  float64
+	SequentialShards bool
+	SharedPolicy     string
+	MinSharedKmers   int
+	Hierarchical     bool
+	CoarseThreshold  float64
+}
+
+// HaploRecord is the on-disk record of the haplotype settings (format version 1)
+type HaploRecord struct {
+	Cutoff        float64
+	MinIterations int
+	MaxIterations int
+	TotalKmers    int
+	HaploDir      string
+}
+
+// InputRecord is the on-disk record of the file used to build a graph (format version 1)
+type InputRecord struct {
+	Path string
+	MD5  string
+}
+
+// ReleaseRecord is the on-disk record of a database release (format version 1)
+type ReleaseRecord struct {
+	Name       string
+	Identity   string
+	URL        string
+	MD5        string
+	Downloaded time.Time
+}
+
+// BackgroundRecord is the on-disk record of the background sequences subtracted from the graph windows (format version 1)
+type BackgroundRecord struct {
+	Path          string
+	MD5           string
+	Sequences     int
+	Kmers         int
+	MaxBackground float64
+}
+
+// BackgroundRegionRecord is the on-disk record of a path region whose windows were left out as background (format version 1)
+type BackgroundRegionRecord struct {
+	PathID     uint32
+	WindowSize int
+	Start      int
+	End        int
+	Windows    int
+}
+
+// BuildDBRecord is the on-disk record of the build-db parameters (format version 1)
+type BuildDBRecord struct {
+	Identity          float64
+	ClusterKmerSize   int
+	ClusterSketchSize int
+	MaxCandidates     int
+}
+
+// GraphRecord is the on-disk record of a graph (format version 1)
+type GraphRecord struct {
+	GrootVersion string
+	GraphID      uint32
+	SortedNodes  []*NodeRecord
+	Paths        map[uint32][]byte
+	Lengths      map[uint32]int
+}
+
+// NodeRecord is the on-disk record of a graph node (format version 1)
+type NodeRecord struct {
+	SegmentID     uint64
+	SegmentLength float64
+	Sequence      []byte
+	OutEdges      []uint64
+	PathIDs       []uint32
+}
+
+// IndexRecord holds the windows, LSH Ensemble domain records and the bootstrapped LSH Ensemble
+// the windows are numbered by their position in Windows, and the domain record keys are these window IDs (uint32)
+// on disk, the domain record signatures are not stored as they are the same as the window sketches
+// the LSH Ensemble is built from the domain records when the index is written, so it is ignored by WriteIndex
+type IndexRecord struct {
+	NumPart       int
+	MaxK          int
+	WindowSize    int
+	SketchSize    int
+	KmerSize      int   // zero for indexes written before multiple window sizes were supported
+	WindowSizes   []int // the length of the windows in each window set
+	Windows       []*lshforest.Key
+	DomainRecords []*lshensemble.DomainRecord
+	Ensemble      *ensemble.Ensemble
+}
+
+// ensembleRecord is the on-disk record of the LSH Ensemble parameters and domain records (format version 1)
+type ensembleRecord struct {
+	NumPart     int
+	MaxK        int
+	WindowSize  int
+	SketchSize  int
+	KmerSize    int
+	WindowSizes []int
+	WindowIDs   []uint32
+	Sizes       []int
+}
+
+// stringKeyedIndex matches the gob encoded graph.ContainmentIndex written before the index files were framed, which used string window keys (format version 0)
+type stringKeyedIndex struct {
+	NumPart       int
+	MaxK          int
+	WindowSize    int
+	SketchSize    int
+	LookupMap     map[string]*lshforest.Key
+	DomainRecords []*lshensemble.DomainRecord
+}
+
+// legacyInfo matches the gob encoded runtime info written before the index files were framed (format version 0)
+type legacyInfo struct {
+	Version    string
+	KmerSize   int
+	SketchSize int
+	WindowSize int
+	NumPart    int
+	MaxK       int
+	Store      map[uint32]*GraphRecord
+	Sources    map[uint32]string
+	Inputs     map[uint32]InputRecord
+	Database   *ReleaseRecord
+	BuildDB    BuildDBRecord
+
+	NumProc              int
+	ContainmentThreshold float64
+	IndexDir             string
+	Sketch               SketchRecord
+	Haplotype            HaploRecord
+}
+
+// WriteInfo is a function to write the index parameters and graphs in the current format
+func WriteInfo(w io.Writer, info *InfoRecord, graphs []*GraphRecord) error {
+	file := NewFile(InfoKind, uint32(len(InfoMigrations)))
+	if err := addInfoSections(file, info, graphs); err != nil {
+		return err
+	}
+	return file.Encode(w)
+}
+
+// ReadInfo is a function to read the index parameters and graphs, migrating older formats
+func ReadInfo(data []byte) (*InfoRecord, []*GraphRecord, error) {
+	file, err := Decode(data, InfoKind, InfoMigrations)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &InfoRecord{}
+	if err := decodeSection(file, ParameterSection, info); err != nil {
+		return nil, nil, err
+	}
+	graphs := []*GraphRecord{}
+	if err := decodeSection(file, GraphSection, &graphs); err != nil {
+		return nil, nil, err
+	}
+	return info, graphs, nil
+}
+
+// WriteIndex is a function to write the windows and LSH Ensemble domain records in the current format
+func WriteIndex(w io.Writer, index *IndexRecord) error {
+	file := NewFile(IndexKind, uint32(len(IndexMigrations)))
+	if err := addIndexSections(file, index); err != nil {
+		return err
+	}
+	if err := addTreeSection(file, index.NumPart, index.SketchSize, index.MaxK, index.DomainRecords); err != nil {
+		return err
+	}
+	return file.Encode(w)
+}
+
+// ReadIndex is a function to read the windows, LSH Ensemble domain records and the bootstrapped LSH Ensemble, migrating older formats
+// the LSH Ensemble is queried from the data, so the data must not be modified while the index is in use
+func ReadIndex(data []byte) (*IndexRecord, error) {
+	file, err := Decode(data, IndexKind, IndexMigrations)
+	if err != nil {
+		return nil, err
+	}
+	index, keys, err := readIndexSections(file)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := file.GetSection(TreeSection)
+	if err != nil {
+		return nil, err
+	}
+	if index.Ensemble, err = ensemble.Open(tree, keys); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// readIndexSections is a function to decode the windows and domain records, returning them with the window ID of each domain
+func readIndexSections(file *File) (*IndexRecord, []uint32, error) {
+	ensembleRec := &ensembleRecord{}
+	if err := decodeSection(file, EnsembleSection, ensembleRec); err != nil {
+		return nil, nil, err
+	}
+	if len(ensembleRec.WindowIDs) != len(ensembleRec.Sizes) {
+		return nil, nil, fmt.Errorf("LSH Ensemble section has mismatched window IDs and sizes")
+	}
+	index := &IndexRecord{
+		NumPart:       ensembleRec.NumPart,
+		MaxK:          ensembleRec.MaxK,
+		WindowSize:    ensembleRec.WindowSize,
+		SketchSize:    ensembleRec.SketchSize,
+		KmerSize:      ensembleRec.KmerSize,
+		WindowSizes:   ensembleRec.WindowSizes,
+		DomainRecords: make([]*lshensemble.DomainRecord, len(ensembleRec.WindowIDs)),
+	}
+
+	// read the windows, which are length prefixed protobuf encoded windows in window ID order
+	windows, err := file.GetSection(WindowSection)
+	if err != nil {
+		return nil, nil, err
+	}
+	for len(windows) != 0 {
+		encodedWindow, remaining, err := readChunk(windows)
+		if err != nil {
+			return nil, nil, err
+		}
+		window := &lshforest.Key{}
+		if err := proto.Unmarshal(encodedWindow, window); err != nil {
+			return nil, nil, err
+		}
+		index.Windows = append(index.Windows, window)
+		windows = remaining
+	}
+
+	// rebuild the domain records, using the window sketches as signatures
+	for i, windowID := range ensembleRec.WindowIDs {
+		if int(windowID) >= len(index.Windows) {
+			return nil, nil, fmt.Errorf("LSH Ensemble domain record has no window: %d", windowID)
+		}
+		index.DomainRecords[i] = &lshensemble.DomainRecord{Key: windowID, Size: ensembleRec.Sizes[i], Signature: index.Windows[windowID].Sketch}
+	}
+	return index, ensembleRec.WindowIDs, nil
+}
+
+// addInfoSections is a function to encode the parameters and graphs as sections
+func addInfoSections(file *File, info *InfoRecord, graphs []*GraphRecord) error {
+	if err := encodeSection(file, ParameterSection, info); err != nil {
+		return err
+	}
+	return encodeSection(file, GraphSection, graphs)
+}
+
+// addIndexSections is a function to encode the windows and domain records as sections
+func addIndexSections(file *File, index *IndexRecord) error {
+	ensemble := &ensembleRecord{
+		NumPart:     index.NumPart,
+		MaxK:        index.MaxK,
+		WindowSize:  index.WindowSize,
+		SketchSize:  index.SketchSize,
+		KmerSize:    index.KmerSize,
+		WindowSizes: index.WindowSizes,
+		WindowIDs:   make([]uint32, len(index.DomainRecords)),
+		Sizes:       make([]int, len(index.DomainRecords)),
+	}
+	for i, rec := range index.DomainRecords {
+		windowID, ok := rec.Key.(uint32)
+		if !ok {
+			return fmt.Errorf("LSH Ensemble domain record key is not a window ID")
+		}
+		if int(windowID) >= len(index.Windows) {
+			return fmt.Errorf("LSH Ensemble domain record has no window: %d", windowID)
+		}
+		ensemble.WindowIDs[i] = windowID
+		ensemble.Sizes[i] = rec.Size
+	}
+	if err := encodeSection(file, EnsembleSection, ensemble); err != nil {
+		return err
+	}
+	windows := []byte{}
+	for _, window := range index.Windows {
+		encodedWindow, err := proto.Marshal(window)
+		if err != nil {
+			return err
+		}
+		windows = appendChunk(windows, encodedWindow)
+	}
+	return file.AddSection(WindowSection, windows)
+}
+
+// addTreeSection is a function to bootstrap the LSH Ensemble from the domain records and add it as a section
+func addTreeSection(file *File, numPart, sketchSize, maxK int, domainRecords []*lshensemble.DomainRecord) error {
+	tree, err := ensemble.Build(numPart, sketchSize, maxK, domainRecords)
+	if err != nil {
+		return err
+	}
+	return file.AddSection(TreeSection, tree)
+}
+
+// migrateLegacyInfo upgrades a gob encoded pipeline.Info (format version 0) to format version 1
+func migrateLegacyInfo(file *File) error {
+	data, err := file.GetSection(LegacySection)
+	if err != nil {
+		return err
+	}
+	legacy := &legacyInfo{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(legacy); err != nil {
+		return err
+	}
+	info := &InfoRecord{
+		Version:    legacy.Version,
+		KmerSize:   legacy.KmerSize,
+		SketchSize: legacy.SketchSize,
+		WindowSize: legacy.WindowSize,
+		NumPart:    legacy.NumPart,
+		MaxK:       legacy.MaxK,
+		Sources:    legacy.Sources,
+		Inputs:     legacy.Inputs,
+		Database:   legacy.Database,
+		BuildDB:    legacy.BuildDB,
+
+		NumProc:              legacy.NumProc,
+		ContainmentThreshold: legacy.ContainmentThreshold,
+		IndexDir:             legacy.IndexDir,
+		Sketch:               legacy.Sketch,
+		Haplotype:            legacy.Haplotype,
+	}
+	graphs := make([]*GraphRecord, 0, len(legacy.Store))
+	for _, graph := range legacy.Store {
+		graphs = append(graphs, graph)
+	}
+	file.RemoveSection(LegacySection)
+	return addInfoSections(file, info, graphs)
+}
+
+// migrateLegacyIndex upgrades a gob encoded graph.ContainmentIndex (format version 0) to format version 1
+// the windows are given window IDs in the order of their string keys, and the LSH Ensemble is bootstrapped from the domain records
+func migrateLegacyIndex(file *File) error {
+	data, err := file.GetSection(LegacySection)
+	if err != nil {
+		return err
+	}
+	legacy := &stringKeyedIndex{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(legacy); err != nil {
+		return err
+	}
+	if legacy.DomainRecords == nil {
+		return fmt.Errorf("loaded an empty index file")
+	}
+	keys := make([]string, 0, len(legacy.LookupMap))
+	for key := range legacy.LookupMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	windowIDs := make(map[string]uint32, len(keys))
+	index := &IndexRecord{
+		NumPart:       legacy.NumPart,
+		MaxK:          legacy.MaxK,
+		WindowSize:    legacy.WindowSize,
+		SketchSize:    legacy.SketchSize,
+		Windows:       make([]*lshforest.Key, len(keys)),
+		DomainRecords: make([]*lshensemble.DomainRecord, len(legacy.DomainRecords)),
+	}
+	for i, key := range keys {
+		windowIDs[key] = uint32(i)
+		index.Windows[i] = legacy.LookupMap[key]
+	}
+	for i, rec := range legacy.DomainRecords {
+		key, ok := rec.Key.(string)
+		if !ok {
+			return fmt.Errorf("LSH Ensemble domain record key is not a string")
+		}
+		windowID, ok := windowIDs[key]
+		if !ok {
+			return fmt.Errorf("LSH Ensemble domain record has no window: %v", key)
+		}
+		index.DomainRecords[i] = &lshensemble.DomainRecord{Key: windowID, Size: rec.Size, Signature: rec.Signature}
+	}
+	file.RemoveSection(LegacySection)
+	if err := addIndexSections(file, index); err != nil {
+		return err
+	}
+	return addTreeSection(file, index.NumPart, index.SketchSize, index.MaxK, index.DomainRecords)
+}
+
+// encodeSection is a function to gob encode a value and add it to a file as a section
+func encodeSection(file *File, name string, value interface{}) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(value); err != nil {
+		return err
+	}
+	return file.AddSection(name, buf.Bytes())
+}
+
+// decodeSection is a function to gob decode a section from a file
+func decodeSection(file *File, name string, value interface{}) error {
+	data, err := file.GetSection(name)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// appendChunk appends a uvarint length prefixed chunk to a byte slice
+func appendChunk(b []byte, chunk []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(chunk)))
+	b = append(b, buf[:n]...)
+	return append(b, chunk...)
+}
+
+// readChunk reads a uvarint length prefixed chunk from a byte slice, returning the chunk and the remaining bytes
+func readChunk(b []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < length {
+		return nil, nil, fmt.Errorf("window section is truncated")
+	}
+	return b[n : n+int(length)], b[n+int(length):], nil
+}
@@ -189,6 +189,8 @@ type Key struct {
 	RC                   bool               `protobuf:"varint,6,opt,name=RC,proto3" json:"RC,omitempty"`
 	Sketch               []uint64           `protobuf:"varint,7,rep,packed,name=Sketch,proto3" json:"Sketch,omitempty"`
 	Freq                 float64            `protobuf:"fixed64,8,opt,name=Freq,proto3" json:"Freq,omitempty"`
+	WindowSize           uint32             `protobuf:"varint,9,opt,name=WindowSize,proto3" json:"WindowSize,omitempty"`
+	SharedWith           []uint32           `protobuf:"varint,10,rep,packed,name=SharedWith,proto3" json:"SharedWith,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
 	XXX_unrecognized     []byte             `json:"-"`
 	XXX_sizecache        int32              `json:"-"`
@@ -275,6 +277,20 @@ func (m *Key) GetFreq() float64 {
 	return 0
 }
 
+func (m *Key) GetWindowSize() uint32 {
+	if m != nil {
+		return m.WindowSize
+	}
+	return 0
+}
+
+func (m *Key) GetSharedWith() []uint32 {
+	if m != nil {
+		return m.SharedWith
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*LSHforest)(nil), "lshforest.LSHforest")
 	proto.RegisterMapType((map[string]*Key)(nil), "lshforest.LSHforest.KeyLookupEntry")
@@ -287,31 +303,33 @@ func init() {
 func init() { proto.RegisterFile("lshforest.proto", fileDescriptor_a8aa0917749b45ac) }
 
 var fileDescriptor_a8aa0917749b45ac = []byte{
-	// 405 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x52, 0xdb, 0x6e, 0x9b, 0x40,
-	0x10, 0xd5, 0x02, 0xc6, 0x61, 0xdc, 0xe0, 0x76, 0x5b, 0x55, 0xab, 0x3c, 0x21, 0xda, 0x4a, 0x48,
-	0x95, 0xa8, 0x94, 0xbe, 0x54, 0x7d, 0x4b, 0xdd, 0x3b, 0xa8, 0x8d, 0x86, 0x2f, 0x20, 0xce, 0x20,
-	0x23, 0x22, 0xd6, 0x81, 0xa5, 0x12, 0x7f, 0xd4, 0x1f, 0xeb, 0x7f, 0x54, 0xbb, 0x6b, 0x3b, 0xd8,
-	0xca, 0xdb, 0x39, 0xb3, 0x33, 0x67, 0x2e, 0x67, 0x61, 0x79, 0xd7, 0x6f, 0x2a, 0xd9, 0x51, 0xaf,
-	0xd2, 0x6d, 0x27, 0x95, 0xe4, 0xc1, 0x21, 0x10, 0xff, 0x63, 0x10, 0xe4, 0xc5, 0x77, 0xcb, 0xf8,
-	0x13, 0x60, 0x99, 0x60, 0x11, 0x4b, 0x66, 0xc8, 0x32, 0xcd, 0x72, 0xe1, 0x58, 0x96, 0xf3, 0x2b,
-	0x08, 0x32, 0x1a, 0x73, 0x29, 0x9b, 0x61, 0x2b, 0xdc, 0xc8, 0x4d, 0x16, 0x97, 0xaf, 0xd2, 0x07,
-	0xe5, 0x83, 0x48, 0x7a, 0xc8, 0xfa, 0xd2, 0xaa, 0x6e, 0xc4, 0x87, 0x2a, 0xfe, 0x16, 0xe6, 0x9f,
-	0x86, 0x75, 0x43, 0xaa, 0x17, 0x9e, 0x11, 0x78, 0x36, 0x11, 0xb0, 0x2f, 0xb8, 0xcf, 0xb8, 0xc8,
-	0x21, 0x3c, 0x56, 0xe2, 0x4f, 0xc1, 0x6d, 0x68, 0x34, 0xf3, 0x05, 0xa8, 0x21, 0x7f, 0x0d, 0xb3,
-	0x3f, 0xe5, 0xdd, 0x40, 0x66, 0xca, 0xc5, 0x65, 0x38, 0x91, 0xcb, 0x68, 0x44, 0xfb, 0xf8, 0xd1,
-	0xf9, 0xc0, 0xe2, 0x77, 0xe0, 0x5b, 0x61, 0xfe, 0x06, 0x66, 0xd7, 0x65, 0xdd, 0xf5, 0x82, 0x99,
-	0x11, 0x96, 0x93, 0x1a, 0x1d, 0x47, 0xfb, 0x1a, 0x57, 0xe0, 0x69, 0xc0, 0x23, 0x58, 0x14, 0xc3,
-	0x4d, 0x41, 0xf7, 0x03, 0xb5, 0x6b, 0xda, 0x35, 0x9f, 0x86, 0x38, 0x07, 0x2f, 0xa3, 0xb1, 0x17,
-	0x4e, 0xe4, 0x26, 0x01, 0x1a, 0xcc, 0x13, 0x58, 0x16, 0x0d, 0xa9, 0xf5, 0xe6, 0xba, 0xec, 0x54,
-	0xad, 0x6a, 0xd9, 0x9a, 0x93, 0x79, 0x78, 0x1a, 0x8e, 0xff, 0x3a, 0xe0, 0x66, 0x34, 0x72, 0x01,
-	0xf3, 0x6f, 0x5d, 0xb9, 0xdd, 0xfc, 0xf8, 0x6c, 0x7a, 0x9c, 0xe3, 0x9e, 0x6a, 0xfd, 0x5f, 0xf2,
-	0xd6, 0xee, 0xe8, 0xa1, 0xc1, 0xfc, 0x25, 0xf8, 0xbf, 0xab, 0xaa, 0x20, 0x25, 0x5c, 0x93, 0xbc,
-	0x63, 0xfc, 0x27, 0x84, 0x2b, 0xd9, 0xaa, 0xb2, 0x6e, 0xe9, 0x56, 0x27, 0xee, 0x0f, 0x1d, 0x1f,
-	0x5f, 0x26, 0x3d, 0x4e, 0xb2, 0x46, 0x9d, 0x54, 0xea, 0x73, 0x23, 0x55, 0x62, 0x16, 0xb9, 0xc9,
-	0x39, 0x6a, 0xc8, 0x43, 0x70, 0x70, 0x25, 0xfc, 0x88, 0x25, 0x67, 0xe8, 0xe0, 0x4a, 0x4f, 0x61,
-	0xd7, 0x11, 0x73, 0xb3, 0xdc, 0x8e, 0xe9, 0x89, 0xbf, 0x76, 0x74, 0x2f, 0xce, 0x22, 0x96, 0x30,
-	0x34, 0xf8, 0xe2, 0x0a, 0x9e, 0x3f, 0xd2, 0x74, 0xea, 0xa9, 0x67, 0x3d, 0x7d, 0x31, 0xf5, 0x94,
-	0x4d, 0x3c, 0xbc, 0xf1, 0xcd, 0xef, 0x7d, 0xff, 0x3f, 0x00, 0x00, 0xff, 0xff, 0x51, 0x35, 0x35,
-	0x59, 0xd0, 0x02, 0x00, 0x00,
+	// 434 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x52, 0x4d, 0x8f, 0xd3, 0x30,
+	0x10, 0x95, 0x93, 0xb4, 0xdd, 0x4c, 0xd9, 0x16, 0x0c, 0x42, 0xd6, 0x1e, 0x50, 0x14, 0x40, 0x8a,
+	0x84, 0x54, 0xa4, 0xe5, 0x82, 0xb8, 0x2d, 0xe5, 0x3b, 0x11, 0xac, 0x26, 0x87, 0x3d, 0x67, 0xdb,
+	0x89, 0x12, 0x75, 0x15, 0x77, 0x13, 0x07, 0x14, 0xfe, 0x2f, 0x12, 0x3f, 0x03, 0xd9, 0x6e, 0xbb,
+	0xee, 0x8a, 0xdb, 0x7b, 0x6f, 0xc6, 0xcf, 0xcf, 0xe3, 0x81, 0xf9, 0x4d, 0x57, 0x95, 0xb2, 0xa5,
+	0x4e, 0x2d, 0xb6, 0xad, 0x54, 0x92, 0x87, 0x07, 0x21, 0xfe, 0xc3, 0x20, 0xcc, 0xf2, 0x2f, 0x96,
+	0xf1, 0x07, 0xc0, 0x52, 0xc1, 0x22, 0x96, 0x8c, 0x90, 0xa5, 0x9a, 0x65, 0xc2, 0xb3, 0x2c, 0xe3,
+	0x17, 0x10, 0xa6, 0x34, 0x64, 0x52, 0x6e, 0xfa, 0xad, 0xf0, 0x23, 0x3f, 0x99, 0x9e, 0x3f, 0x5f,
+	0xdc, 0x39, 0x1f, 0x4c, 0x16, 0x87, 0xae, 0x8f, 0x8d, 0x6a, 0x07, 0xbc, 0x3b, 0xc5, 0x5f, 0xc1,
+	0xe4, 0x7d, 0xbf, 0xda, 0x90, 0xea, 0x44, 0x60, 0x0c, 0x1e, 0x39, 0x06, 0xb6, 0x82, 0xfb, 0x8e,
+	0xb3, 0x0c, 0x66, 0xc7, 0x4e, 0xfc, 0x21, 0xf8, 0x1b, 0x1a, 0x4c, 0xbe, 0x10, 0x35, 0xe4, 0x2f,
+	0x60, 0xf4, 0xb3, 0xb8, 0xe9, 0xc9, 0xa4, 0x9c, 0x9e, 0xcf, 0x1c, 0xbb, 0x94, 0x06, 0xb4, 0xc5,
+	0x77, 0xde, 0x5b, 0x16, 0xbf, 0x86, 0xb1, 0x35, 0xe6, 0x2f, 0x61, 0x74, 0x59, 0xd4, 0x6d, 0x27,
+	0x98, 0x89, 0x30, 0x77, 0xce, 0x68, 0x1d, 0x6d, 0x35, 0x2e, 0x21, 0xd0, 0x80, 0x47, 0x30, 0xcd,
+	0xfb, 0xeb, 0x9c, 0x6e, 0x7b, 0x6a, 0x56, 0xb4, 0xbb, 0xdc, 0x95, 0x38, 0x87, 0x20, 0xa5, 0xa1,
+	0x13, 0x5e, 0xe4, 0x27, 0x21, 0x1a, 0xcc, 0x13, 0x98, 0xe7, 0x1b, 0x52, 0xab, 0xea, 0xb2, 0x68,
+	0x55, 0xad, 0x6a, 0xd9, 0x98, 0x91, 0x05, 0x78, 0x5f, 0x8e, 0xff, 0x7a, 0xe0, 0xa7, 0x34, 0x70,
+	0x01, 0x93, 0xcf, 0x6d, 0xb1, 0xad, 0xbe, 0x7e, 0x30, 0x77, 0x9c, 0xe2, 0x9e, 0x6a, 0xff, 0xef,
+	0x72, 0x6d, 0xdf, 0x18, 0xa0, 0xc1, 0xfc, 0x29, 0x8c, 0x7f, 0x94, 0x65, 0x4e, 0x4a, 0xf8, 0xa6,
+	0x79, 0xc7, 0xf8, 0x37, 0x98, 0x2d, 0x65, 0xa3, 0x8a, 0xba, 0xa1, 0xb5, 0x6e, 0xdc, 0x0f, 0x3a,
+	0x3e, 0x9e, 0xcc, 0xe2, 0xb8, 0xc9, 0x7e, 0xd4, 0xbd, 0x93, 0x7a, 0xdc, 0x48, 0xa5, 0x18, 0x45,
+	0x7e, 0x72, 0x8a, 0x1a, 0xf2, 0x19, 0x78, 0xb8, 0x14, 0xe3, 0x88, 0x25, 0x27, 0xe8, 0xe1, 0x52,
+	0xa7, 0xb0, 0xcf, 0x11, 0x13, 0xf3, 0xb8, 0x1d, 0xd3, 0x89, 0x3f, 0xb5, 0x74, 0x2b, 0x4e, 0x22,
+	0x96, 0x30, 0x34, 0x98, 0x3f, 0x03, 0xb8, 0xaa, 0x9b, 0xb5, 0xfc, 0x95, 0xd7, 0xbf, 0x49, 0x84,
+	0x26, 0xb5, 0xa3, 0xe8, 0x7a, 0x5e, 0x15, 0x2d, 0xad, 0xaf, 0x6a, 0x55, 0x09, 0x30, 0x97, 0x3a,
+	0xca, 0xd9, 0x05, 0x3c, 0xfe, 0x4f, 0x68, 0x77, 0x27, 0x02, 0xbb, 0x13, 0x4f, 0xdc, 0x9d, 0x60,
+	0xce, 0x0e, 0x5c, 0x8f, 0xcd, 0xf6, 0xbf, 0xf9, 0x37, 0x00, 0x54, 0xd5, 0xb1, 0x54, 0x10, 0x03,
+	0x00, 0x00,
 }
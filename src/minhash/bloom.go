@@ -1,6 +1,10 @@
 package minhash
 
-import "sync"
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
 
 // defaultSize used to create a bloom filter
 const defaultSize = 10000
@@ -17,9 +21,10 @@ func init() {
 
 // BloomFilter is the bloom filter type
 type BloomFilter struct {
-	size   uint64
-	sketch []uint64
-	lock   sync.RWMutex // lock the node for read/write access
+	size      uint64
+	numHashes uint64 // the number of bits set for each k-mer (derived from the k-mer by double hashing)
+	sketch    []uint64
+	lock      sync.RWMutex // lock the node for read/write access
 }
 
 // Reset will clear all marked bits in the Bloom Filter sketch
@@ -31,34 +36,87 @@ func (BloomFilter *BloomFilter) Reset() {
 
 // Add is a method to add a hashed k-mer to the Bloom Filter sketch
 func (BloomFilter *BloomFilter) Add(kmer uint64) {
-	h := (kmer % BloomFilter.size)
-	c := h / 64 // cell
-	o := h % 64 // offset
 	BloomFilter.lock.Lock()
-	BloomFilter.sketch[c] = BloomFilter.sketch[c] | mask[o]
+	for i := uint64(0); i < BloomFilter.numHashes; i++ {
+		h := BloomFilter.position(kmer, i)
+		c := h / 64 // cell
+		o := h % 64 // offset
+		BloomFilter.sketch[c] = BloomFilter.sketch[c] | mask[o]
+	}
 	BloomFilter.lock.Unlock()
 }
 
 // Check is a method to check a hashed k-mer against the Bloom Filter sketch
 func (BloomFilter *BloomFilter) Check(kmer uint64) bool {
-	h := (kmer % BloomFilter.size)
-	c := h / 64 // cell
-	o := h % 64 // offset
+	BloomFilter.lock.RLock()
+	defer BloomFilter.lock.RUnlock()
+	for i := uint64(0); i < BloomFilter.numHashes; i++ {
+		h := BloomFilter.position(kmer, i)
+		c := h / 64 // cell
+		o := h % 64 // offset
+		if (BloomFilter.sketch[c] & mask[o]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// position is a method to get the bit for the ith hash of a k-mer, using the k-mer and its rotation as the two hashes for double hashing
+func (BloomFilter *BloomFilter) position(kmer, i uint64) uint64 {
+	return (kmer + i*((kmer>>32|kmer<<32)|1)) % BloomFilter.size
+}
+
+// MarshalBinary is a method to encode the Bloom Filter, which satisfies the encoding.BinaryMarshaler interface
+func (BloomFilter *BloomFilter) MarshalBinary() ([]byte, error) {
+	BloomFilter.lock.RLock()
+	defer BloomFilter.lock.RUnlock()
+	data := make([]byte, 16+8*len(BloomFilter.sketch))
+	binary.LittleEndian.PutUint64(data, BloomFilter.size)
+	binary.LittleEndian.PutUint64(data[8:], BloomFilter.numHashes)
+	for i, cell := range BloomFilter.sketch {
+		binary.LittleEndian.PutUint64(data[16+8*i:], cell)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary is a method to decode a Bloom Filter, which satisfies the encoding.BinaryUnmarshaler interface
+func (BloomFilter *BloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 || (len(data)-16)%8 != 0 {
+		return fmt.Errorf("bloom filter data is truncated")
+	}
+	size, numHashes := binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:])
+	if size != 64*uint64((len(data)-16)/8) || size == 0 {
+		return fmt.Errorf("bloom filter size (%d bits) does not match its data (%d bytes)", size, len(data)-16)
+	}
 	BloomFilter.lock.Lock()
 	defer BloomFilter.lock.Unlock()
-	return (BloomFilter.sketch[c] & mask[o]) > 0
+	BloomFilter.size, BloomFilter.numHashes = size, numHashes
+	BloomFilter.sketch = make([]uint64, (len(data)-16)/8)
+	for i := range BloomFilter.sketch {
+		BloomFilter.sketch[i] = binary.LittleEndian.Uint64(data[16+8*i:])
+	}
+	return nil
 }
 
 // NewBloomFilter is a Bloom Filter constructor, using a specified sized
 func NewBloomFilter(size int) *BloomFilter {
+	return NewMultiHashBloomFilter(size, 1)
+}
+
+// NewMultiHashBloomFilter is a Bloom Filter constructor, using a specified size and number of hashes per k-mer
+func NewMultiHashBloomFilter(size, numHashes int) *BloomFilter {
 	if size > 64 {
 		size = size / 64
 	} else {
 		size = 1
 	}
+	if numHashes < 1 {
+		numHashes = 1
+	}
 	return &BloomFilter{
-		size:   64 * uint64(size),
-		sketch: make([]uint64, size),
+		size:      64 * uint64(size),
+		numHashes: uint64(numHashes),
+		sketch:    make([]uint64, size),
 	}
 }
 
@@ -16,6 +16,8 @@ type KHFsketch struct {
 	sketch     []uint64
 	hf1        func(b []byte) uint64
 	hf2        func(b []byte) uint64
+	stranded   bool // if true, the forward k-mers are used instead of the canonical k-mers
+	skipN      bool // if true, k-mers containing an N are skipped instead of being hashed
 }
 
 // NewKHFsketch is the constructor for a KHFsketch data structure
@@ -35,7 +37,21 @@ func NewKHFsketch(k, s uint) *KHFsketch {
 	}
 }
 
-// AddSequence is a method to decompose a read to canonical kmers, hash them and add any minimums to the sketch
+// NewStrandedKHFsketch is the constructor for a KHFsketch data structure that uses the forward k-mers, so that a sequence and its reverse complement give different sketches
+func NewStrandedKHFsketch(k, s uint) *KHFsketch {
+	mh := NewKHFsketch(k, s)
+	mh.stranded = true
+	return mh
+}
+
+// SkipMaskedKmers is a method to set whether k-mers containing an N (e.g. a base masked by the low-complexity filter) are skipped, which should only be used if masking is on so that unmasked sketches are unchanged
+func (mh *KHFsketch) SkipMaskedKmers(skip bool) *KHFsketch {
+	mh.skipN = skip
+	return mh
+}
+
+// AddSequence is a method to decompose a read to canonical kmers (or forward kmers for a stranded sketch), hash them and add any minimums to the sketch
+// k-mers containing an N are only skipped if SkipMaskedKmers is set
 func (mh *KHFsketch) AddSequence(sequence []byte) error {
 
 	// check the sequence is long enough for given k
@@ -50,17 +66,20 @@ func (mh *KHFsketch) AddSequence(sequence []byte) error {
 	bitmask := (uint64(1) << uint64(2*mh.kmerSize)) - uint64(1)
 	bitshift := uint64(2 * (mh.kmerSize - 1))
 
+	// l is the number of bases since the last skipped N
+	l := uint(0)
 	for i := 0; i < len(sequence); i++ {
 
 		// get the nucleotide and convert to uint8
 		c := seqNT4table[sequence[i]]
 
-		// if the nucleotide == N
-		if c > 3 {
-
-			// TODO: handle these
-
+		// if the nucleotide == N, skip all the k-mers that contain it
+		if c > 3 && mh.skipN {
+			l = 0
+			kmers[0], kmers[1] = 0, 0
+			continue
 		}
+		l++
 
 		// get the forward k-mer
 		kmers[0] = (kmers[0]<<2 | uint64(c)) & bitmask
@@ -69,13 +88,13 @@ func (mh *KHFsketch) AddSequence(sequence []byte) error {
 		kmers[1] = (kmers[1] >> 2) | (uint64(3)-uint64(c))<<bitshift
 
 		// get the span of the k-mer
-		if uint(i+1) < mh.kmerSize {
+		if l < mh.kmerSize {
 			continue
 		}
 
-		// set the canonical k-mer
+		// set the canonical k-mer, unless the sketch is stranded
 		var strand uint
-		if kmers[0] > kmers[1] {
+		if !mh.stranded && kmers[0] > kmers[1] {
 			strand = 1
 		}
 
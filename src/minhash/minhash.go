@@ -11,14 +11,15 @@ type MinHash interface {
 }
 
 // GetReadSketch is a function to sketch a read sequence
-func GetReadSketch(seq []byte, kmerSize, sketchSize uint, kmv bool) ([]uint64, error) {
+// if skipN is set, k-mers containing an N are left out of KHF sketches (see KHFsketch.SkipMaskedKmers)
+func GetReadSketch(seq []byte, kmerSize, sketchSize uint, kmv, skipN bool) ([]uint64, error) {
 
 	// create the MinHash data structure, using the specified algorithm flavour
 	var mh MinHash
 	if kmv {
 		mh = NewKMVsketch(uint(kmerSize), uint(sketchSize))
 	} else {
-		mh = NewKHFsketch(uint(kmerSize), uint(sketchSize))
+		mh = NewKHFsketch(uint(kmerSize), uint(sketchSize)).SkipMaskedKmers(skipN)
 	}
 
 	// use the AddSequence method to populate the MinHash
@@ -41,6 +42,13 @@ func GetReadSketch(seq []byte, kmerSize, sketchSize uint, kmv bool) ([]uint64, e
 	return sketch, err
 }
 
+// GetStrandedReadSketch is a function to sketch the forward k-mers of a read sequence, using the KHF algorithm
+func GetStrandedReadSketch(seq []byte, kmerSize, sketchSize uint, skipN bool) ([]uint64, error) {
+	mh := NewStrandedKHFsketch(kmerSize, sketchSize).SkipMaskedKmers(skipN)
+	err := mh.AddSequence(seq)
+	return mh.GetSketch(), err
+}
+
 // seqNT4table is used to convert "ACGTN" to 01234 - from minimap2
 var seqNT4table = [256]uint8{
 	0, 1, 2, 3, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
@@ -1,6 +1,7 @@
 package minhash
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/adam-hanna/arrayOperations"
@@ -66,6 +67,33 @@ func TestBloomfilter(t *testing.T) {
 	}
 }
 
+// multi-hash BloomFilter test
+func TestMultiHashBloomfilter(t *testing.T) {
+	filter := NewMultiHashBloomFilter(1000, 7)
+	for i := 0; i < len(hashvalues); i++ {
+		filter.Add(hashvalues[i])
+	}
+	data, err := filter.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := new(BloomFilter)
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.size != filter.size || loaded.numHashes != 7 {
+		t.Fatal("bloom filter was not decoded correctly")
+	}
+	for i := 0; i < len(hashvalues); i++ {
+		if !loaded.Check(hashvalues[i]) {
+			t.Fatalf("'%d' should be have been marked present", hashvalues[i])
+		}
+	}
+	if err := loaded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("truncated bloom filter should not decode")
+	}
+}
+
 // Constructor test
 func TestMinHashConstructors(t *testing.T) {
 	mhKHF := NewKHFsketch(kmerSize, sketchSize)
@@ -91,6 +119,22 @@ func TestKHFadd(t *testing.T) {
 	if err := mhKHF.AddSequence(seqA); err != nil {
 		t.Fatal(err)
 	}
+
+	// k-mers containing Ns should only be skipped if masked k-mers are skipped
+	masked := append([]byte("NNNNNNNNNN"), seqA[10:]...)
+	mhUnmasked := NewKHFsketch(kmerSize, sketchSize)
+	if err := mhUnmasked.AddSequence(seqA[10:]); err != nil {
+		t.Fatal(err)
+	}
+	for _, skip := range []bool{true, false} {
+		mhMasked := NewKHFsketch(kmerSize, sketchSize).SkipMaskedKmers(skip)
+		if err := mhMasked.AddSequence(masked); err != nil {
+			t.Fatal(err)
+		}
+		if js, err := mhMasked.GetSimilarity(mhUnmasked); err != nil || (js == 1.0) != skip {
+			t.Fatalf("k-mers containing Ns were not handled correctly (skipped: %v, similarity: %.2f)", skip, js)
+		}
+	}
 }
 
 // Add test for KMV
@@ -156,6 +200,115 @@ func TestSimilarityEstimates(t *testing.T) {
 
 }
 
+// test stranded KHF sketches distinguish a sequence from its reverse complement
+func TestStrandedKHF(t *testing.T) {
+	mhKHF1 := NewStrandedKHFsketch(kmerSize, sketchSize)
+	if err := mhKHF1.AddSequence(seqA); err != nil {
+		t.Fatal(err)
+	}
+	mhKHF2 := NewStrandedKHFsketch(kmerSize, sketchSize)
+	if err := mhKHF2.AddSequence(seqArcomplement); err != nil {
+		t.Fatal(err)
+	}
+	js, err := mhKHF1.GetSimilarity(mhKHF2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if js == 1.0 {
+		t.Fatal("stranded sketches of a sequence and its reverse complement should differ")
+	}
+}
+
+// randomSeq is a helper function to make a random sequence, with a run of Ns to check masked k-mers are skipped
+func randomSeq(length int) []byte {
+	r := rand.New(rand.NewSource(1))
+	seq := make([]byte, length)
+	for i := range seq {
+		seq[i] = "ACGT"[r.Intn(4)]
+	}
+	for i := length / 3; i < length/3+30 && i < length; i++ {
+		seq[i] = 'N'
+	}
+	return seq
+}
+
+// test the sliding KHF gives the same sketches as sketching each window
+func TestKHFslider(t *testing.T) {
+	seq := randomSeq(500)
+	windowSize := uint(40)
+	for _, stranded := range []bool{false, true} {
+		for _, skipN := range []bool{false, true} {
+			numWindows := 0
+			err := NewKHFslider(kmerSize, sketchSize, windowSize, stranded).SkipMaskedKmers(skipN).SketchWindows(seq, func(start int, sketch []uint64, numKmers int) {
+				mh := NewKHFsketch(kmerSize, sketchSize)
+				if stranded {
+					mh = NewStrandedKHFsketch(kmerSize, sketchSize)
+				}
+				if err := mh.SkipMaskedKmers(skipN).AddSequence(seq[start : start+int(windowSize)]); err != nil {
+					t.Fatal(err)
+				}
+				for i, min := range mh.GetSketch() {
+					if sketch[i] != min {
+						t.Fatalf("sliding sketch of window %d does not match (stranded: %v, skipping Ns: %v)", start, stranded, skipN)
+					}
+				}
+				if skipN && start > len(seq)/3 && start+int(windowSize) < len(seq)/3+30 && numKmers != 0 {
+					t.Fatalf("window %d is masked but has %d k-mers", start, numKmers)
+				}
+				numWindows++
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numWindows != len(seq)-int(windowSize)+1 {
+				t.Fatalf("wrong number of windows sketched: %d", numWindows)
+			}
+		}
+	}
+	if err := NewKHFslider(kmerSize, sketchSize, kmerSize-1, false).SketchWindows(seq, func(int, []uint64, int) {}); err == nil {
+		t.Fatal("windows shorter than the k-mer size should not be sketched")
+	}
+}
+
+// test minimizer selection covers every run of positions and only depends on the k-mers
+func TestMinimizers(t *testing.T) {
+	seq := randomSeq(500)
+	hashes := KmerHashes(seq, kmerSize)
+	if len(hashes) != len(seq)-int(kmerSize)+1 {
+		t.Fatal("wrong number of k-mer hashes")
+	}
+	w := 8
+	selected := Minimizers(hashes, w)
+	numSelected := 0
+	for i := range selected {
+		if selected[i] {
+			numSelected++
+		}
+		if i+w > len(selected) {
+			continue
+		}
+		covered := false
+		for _, s := range selected[i : i+w] {
+			covered = covered || s
+		}
+		if !covered {
+			t.Fatalf("no position selected in the run starting at %d", i)
+		}
+	}
+	if numSelected == 0 || numSelected > len(selected)/2 {
+		t.Fatalf("unexpected number of minimizers selected: %d", numSelected)
+	}
+
+	// a sequence that shares a region should select the same minimizers in it, wherever the region is
+	shifted := append([]byte("ACGTTGCA"), seq[100:200]...)
+	shiftedSelected := Minimizers(KmerHashes(shifted, kmerSize), w)
+	for i := 8 + w; i < len(shiftedSelected)-w; i++ {
+		if shiftedSelected[i] != selected[i+92] {
+			t.Fatalf("minimizer selection depends on the position of the k-mers (position %d)", i)
+		}
+	}
+}
+
 // benchmark KHF
 func BenchmarkKHF(b *testing.B) {
 	mhKHF1 := NewKHFsketch(kmerSize, sketchSize)
@@ -179,3 +332,28 @@ func BenchmarkKMV(b *testing.B) {
 		}
 	}
 }
+
+// benchmark sketching every window of a sequence with a new KHF sketch per window
+func BenchmarkKHFwindows(b *testing.B) {
+	seq := randomSeq(2000)
+	windowSize := 100
+	for n := 0; n < b.N; n++ {
+		for i := 0; i+windowSize <= len(seq); i++ {
+			mh := NewKHFsketch(21, 42)
+			if err := mh.AddSequence(seq[i : i+windowSize]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// benchmark sketching every window of a sequence with the sliding KHF
+func BenchmarkKHFslider(b *testing.B) {
+	seq := randomSeq(2000)
+	slider := NewKHFslider(21, 42, 100, false)
+	for n := 0; n < b.N; n++ {
+		if err := slider.SketchWindows(seq, func(int, []uint64, int) {}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
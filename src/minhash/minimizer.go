@@ -0,0 +1,68 @@
+package minhash
+
+import "math"
+
+// KmerHashes is a function to hash the canonical k-mer starting at each position of a sequence, using the same hash as the KHF sketch
+// k-mers containing an N are given the maximum hash value
+func KmerHashes(sequence []byte, kmerSize uint) []uint64 {
+	if uint(len(sequence)) < kmerSize {
+		return nil
+	}
+	hashes := make([]uint64, uint(len(sequence))-kmerSize+1)
+	kmers := [2]uint64{0, 0}
+	bitmask := (uint64(1) << uint64(2*kmerSize)) - uint64(1)
+	bitshift := uint64(2 * (kmerSize - 1))
+	l := uint(0)
+	for i := 0; i < len(sequence); i++ {
+		c := seqNT4table[sequence[i]]
+		if c > 3 {
+			l = 0
+			kmers[0], kmers[1] = 0, 0
+		} else {
+			l++
+			kmers[0] = (kmers[0]<<2 | uint64(c)) & bitmask
+			kmers[1] = (kmers[1] >> 2) | (uint64(3)-uint64(c))<<bitshift
+		}
+		pos := i - int(kmerSize) + 1
+		if pos < 0 {
+			continue
+		}
+		if l < kmerSize {
+			hashes[pos] = math.MaxUint64
+			continue
+		}
+		strand := 0
+		if kmers[0] > kmers[1] {
+			strand = 1
+		}
+		hashes[pos] = hash64(kmers[strand], bitmask)
+	}
+	return hashes
+}
+
+// Minimizers is a function to select the positions that hold the minimum hash (the leftmost if tied) of at least one run of w consecutive hashes
+// every run of w consecutive positions contains a selected position, and the selection only depends on the hashes so it is the same for any sequence sharing the k-mers
+func Minimizers(hashes []uint64, w int) []bool {
+	selected := make([]bool, len(hashes))
+	if w < 1 {
+		w = 1
+	}
+
+	// keep a deque of the positions that could be the minimum of the current run
+	deque := make([]int, 0, w)
+	for pos, hash := range hashes {
+		for len(deque) > 0 && hashes[deque[len(deque)-1]] > hash {
+			deque = deque[:len(deque)-1]
+		}
+		deque = append(deque, pos)
+		if deque[0] <= pos-w {
+			deque = deque[1:]
+		}
+
+		// mark the minimum once the run is full (or the sequence is shorter than a run)
+		if pos >= w-1 || pos == len(hashes)-1 {
+			selected[deque[0]] = true
+		}
+	}
+	return selected
+}
@@ -0,0 +1,161 @@
+package minhash
+
+import (
+	"fmt"
+	"math"
+)
+
+// KHFslider is the structure for sketching every window of a sequence with the KHF algorithm, giving the same sketches as adding each window to a new KHFsketch
+// the candidate minimums for each slot of the sketch are kept in a monotonic deque as the window slides, so each k-mer is only hashed once
+type KHFslider struct {
+	kmerSize   uint
+	sketchSize uint
+	windowSize uint
+	stranded   bool // if true, the forward k-mers are used instead of the canonical k-mers
+	skipN      bool // if true, k-mers containing an N are skipped instead of being hashed
+}
+
+// slotEntry is a candidate minimum for a slot of the sketch, along with the position of the k-mer it came from
+type slotEntry struct {
+	pos   int
+	value uint64
+}
+
+// NewKHFslider is the constructor for a KHFslider data structure
+func NewKHFslider(k, s, w uint, stranded bool) *KHFslider {
+	return &KHFslider{
+		kmerSize:   k,
+		sketchSize: s,
+		windowSize: w,
+		stranded:   stranded,
+	}
+}
+
+// SkipMaskedKmers is a method to set whether k-mers containing an N are skipped (see KHFsketch.SkipMaskedKmers)
+func (slider *KHFslider) SkipMaskedKmers(skip bool) *KHFslider {
+	slider.skipN = skip
+	return slider
+}
+
+// SketchWindows is a method to sketch each window of a sequence in turn, calling fn with the start of the window, its sketch and the number of k-mers in the sketch
+// as with KHFsketch.AddSequence, k-mers containing an N are skipped if SkipMaskedKmers is set, and the slots of a window without any k-mers are left at the maximum value
+func (slider *KHFslider) SketchWindows(sequence []byte, fn func(start int, sketch []uint64, numKmers int)) error {
+	if slider.windowSize < slider.kmerSize {
+		return fmt.Errorf("window size (%d) is shorter than k-mer length (%d)", slider.windowSize, slider.kmerSize)
+	}
+
+	// if Ns are hashed, their k-mers depend on where the sketch started (as in KHFsketch.AddSequence), so each window is sketched on its own
+	if !slider.skipN && hasN(sequence) {
+		return slider.sketchEachWindow(sequence, fn)
+	}
+
+	// span is the number of k-mers in each window, which is also the capacity of each slot deque
+	span := int(slider.windowSize - slider.kmerSize + 1)
+	deques := make([]slotEntry, int(slider.sketchSize)*span)
+	heads := make([]int, slider.sketchSize)
+	lengths := make([]int, slider.sketchSize)
+
+	// valid records which k-mers in the window were sketched, so that the k-mer count can be updated as they leave the window
+	valid := make([]bool, span)
+	numKmers := 0
+
+	// a holder for evalutating two k-mers
+	kmers := [2]uint64{0, 0}
+
+	// bitmask is used to update the previous k-mer with the next base
+	bitmask := (uint64(1) << uint64(2*slider.kmerSize)) - uint64(1)
+	bitshift := uint64(2 * (slider.kmerSize - 1))
+
+	// l is the number of bases since the last skipped N
+	l := uint(0)
+	for i := 0; i < len(sequence); i++ {
+
+		// get the nucleotide and update the k-mers, resetting them if the nucleotide == N and masked k-mers are skipped
+		c := seqNT4table[sequence[i]]
+		if c > 3 && slider.skipN {
+			l = 0
+			kmers[0], kmers[1] = 0, 0
+		} else {
+			l++
+			kmers[0] = (kmers[0]<<2 | uint64(c)) & bitmask
+			kmers[1] = (kmers[1] >> 2) | (uint64(3)-uint64(c))<<bitshift
+		}
+
+		// get the position of the k-mer ending at this base, and the start of the window ending with it
+		pos := i - int(slider.kmerSize) + 1
+		if pos < 0 {
+			continue
+		}
+		start := pos - span + 1
+
+		// remove the k-mer that has left the window, along with any minimums that came from it
+		if pos >= span && valid[pos%span] {
+			numKmers--
+		}
+		valid[pos%span] = l >= slider.kmerSize
+		for j := range heads {
+			if lengths[j] > 0 && deques[j*span+heads[j]].pos < start {
+				heads[j] = (heads[j] + 1) % span
+				lengths[j]--
+			}
+		}
+
+		// add the new k-mer to the back of each slot deque, removing the candidates it replaces
+		if valid[pos%span] {
+			numKmers++
+
+			// set the canonical k-mer, unless the sketch is stranded
+			var strand uint
+			if !slider.stranded && kmers[0] > kmers[1] {
+				strand = 1
+			}
+			hv2 := hash64(kmers[strand], bitmask)<<8 | uint64(slider.kmerSize)
+			for j := range heads {
+				hv := kmers[strand] + uint64(j)*hv2
+				for lengths[j] > 0 && deques[j*span+(heads[j]+lengths[j]-1)%span].value >= hv {
+					lengths[j]--
+				}
+				deques[j*span+(heads[j]+lengths[j])%span] = slotEntry{pos, hv}
+				lengths[j]++
+			}
+		}
+
+		// once the window is full, the front of each deque holds the minimum for its slot
+		if start < 0 {
+			continue
+		}
+		sketch := make([]uint64, slider.sketchSize)
+		for j := range sketch {
+			sketch[j] = math.MaxUint64
+			if lengths[j] > 0 {
+				sketch[j] = deques[j*span+heads[j]].value
+			}
+		}
+		fn(start, sketch, numKmers)
+	}
+	return nil
+}
+
+// sketchEachWindow is a method to sketch each window of a sequence with a new KHFsketch
+func (slider *KHFslider) sketchEachWindow(sequence []byte, fn func(start int, sketch []uint64, numKmers int)) error {
+	numKmers := int(slider.windowSize - slider.kmerSize + 1)
+	for start := 0; start+int(slider.windowSize) <= len(sequence); start++ {
+		mh := NewKHFsketch(slider.kmerSize, slider.sketchSize)
+		mh.stranded = slider.stranded
+		if err := mh.AddSequence(sequence[start : start+int(slider.windowSize)]); err != nil {
+			return err
+		}
+		fn(start, mh.GetSketch(), numKmers)
+	}
+	return nil
+}
+
+// hasN is a function to check if a sequence has any bases other than ACGT
+func hasN(sequence []byte) bool {
+	for _, base := range sequence {
+		if seqNT4table[base] > 3 {
+			return true
+		}
+	}
+	return false
+}
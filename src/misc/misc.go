@@ -2,8 +2,11 @@
 package misc
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime"
@@ -99,6 +102,20 @@ func CheckFile(file string) error {
 	return nil
 }
 
+// GetMD5 is a function to calculate the md5sum of a file
+func GetMD5(file string) (string, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, fh); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16]), nil
+}
+
 // CheckExt is a function to check the extensions of a file
 func CheckExt(file string, exts []string) error {
 	splitFilename := strings.Split(file, ".")
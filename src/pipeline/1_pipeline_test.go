@@ -4,6 +4,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/will-rowe/baby-groot/src/graph"
 	"github.com/will-rowe/baby-groot/src/version"
 )
 
@@ -22,7 +23,7 @@ var fastq = []string{"test-data/test-reads-OXA90-OXA106-100bp-with-errors.fastq"
 //var fastq = []string{"test-data/test-reads-OXA90-100bp-50x-with-errors.fastq"}
 
 // the GFA produced by the sketch test
-var gfaList = []string{"test-data/tmp/groot-graph-0.gfa"}
+var gfaList = []string{"test-data/tmp/groot-graph-test-genes.gfa"}
 
 ///////////////////////////////////////////////////////////////////////////////////////////////
 
@@ -43,6 +44,7 @@ var testParameters = &Info{
 		MinKmerCoverage: 10,
 		BloomFilter:     false,
 		Fasta:           false,
+		MinSharedKmers:  SuggestedMinSharedKmers,
 	},
 	Haplotype: HaploCmd{
 		Cutoff:        1.0,
@@ -52,6 +54,30 @@ var testParameters = &Info{
 	},
 }
 
+// the settings used to validate the index with error-free simulated reads
+var validateParameters = ValidateCmd{
+	ReadLength:           100,
+	ErrorRate:            0.0,
+	ReadsPerPath:         5,
+	ContainmentThreshold: 0.9,
+}
+
+// newValidateInfo returns a copy of the test parameters with the validation settings, which has its own graph store and sources so that a test can't change them for the other tests
+func newValidateInfo() *Info {
+	info := *testParameters
+	info.Store = make(graph.Store, len(testParameters.Store))
+	for graphID, g := range testParameters.Store {
+		info.Store[graphID] = g
+	}
+	info.Sources = make(map[uint32]string, len(testParameters.Sources))
+	for graphID, source := range testParameters.Sources {
+		info.Sources[graphID] = source
+	}
+	info.sourceLookup = nil
+	info.Validate = validateParameters
+	return &info
+}
+
 func setupTmpDir() error {
 	_ = os.RemoveAll("test-data/tmp")
 	err := os.Mkdir("test-data/tmp", 0777)
@@ -1,7 +1,25 @@
 package pipeline
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/lshforest"
+	"github.com/will-rowe/baby-groot/src/minhash"
+	"github.com/will-rowe/baby-groot/src/seqio"
+	"github.com/will-rowe/gfa"
 )
 
 func TestIndexBuild(t *testing.T) {
@@ -34,14 +52,568 @@ func TestIndexBuild(t *testing.T) {
 	}
 }
 
+// test that graphIDs are derived from the graph names, rather than the order of the inputs
+func TestGraphIDs(t *testing.T) {
+	for graphID := range testParameters.Store {
+		other := &Info{}
+		if _, replace, err := other.GetGraphID("another-graph"); err != nil || replace {
+			t.Fatal("new graph name should not be reported as a replacement")
+		}
+		if newID, _, err := other.GetGraphID(testParameters.GraphName(graphID)); err != nil || newID != graphID {
+			t.Fatalf("graphID depends on the other graphs in the index (%d vs %d)", newID, graphID)
+		}
+		if newID, replace, err := other.GetGraphID(testParameters.GraphName(graphID)); err != nil || newID != graphID || !replace {
+			t.Fatal("existing graph name was not given its recorded graphID")
+		}
+		if _, ok := other.FindGraph(testParameters.GraphName(graphID)); ok {
+			t.Fatal("graph name without a graph in the store should not be found")
+		}
+		if foundID, ok := testParameters.FindGraph(testParameters.GraphName(graphID)); !ok || foundID != graphID {
+			t.Fatal("graph was not found by its name")
+		}
+	}
+
+	// graphs from indexes built before graphs were named are matched by their graphID, which then becomes their name
+	unnamed := &Info{Store: graph.Store{3: &graph.GrootGraph{GraphID: 3}}}
+	if fmt.Sprint(unnamed.UnnamedGraphs()) != "[3]" {
+		t.Fatalf("unnamed graph was not reported: %v", unnamed.UnnamedGraphs())
+	}
+	if graphID, replace, err := unnamed.GetGraphID("3"); err != nil || graphID != 3 || !replace {
+		t.Fatal("unnamed graph was not matched by its graphID")
+	}
+	if len(unnamed.UnnamedGraphs()) != 0 || unnamed.GraphName(3) != "3" {
+		t.Fatal("graphID was not recorded as the name of the unnamed graph")
+	}
+
+	// "costarring" and "liquid" have the same FNV-1a hash, so they can't both be used in one index, whichever order they are added in
+	for _, names := range [][2]string{{"costarring", "liquid"}, {"liquid", "costarring"}} {
+		clashing := &Info{}
+		if _, _, err := clashing.GetGraphID(names[0]); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := clashing.GetGraphID(names[1])
+		if err == nil || !strings.Contains(err.Error(), names[0]) || !strings.Contains(err.Error(), names[1]) {
+			t.Fatalf("graphID clash should be an error naming both graphs, not: %v", err)
+		}
+	}
+}
+
+// test that clusters with identical representatives are merged, as they would be given the same name and graphID
+func TestNameClusters(t *testing.T) {
+	seqs := []*seqio.Sequence{{ID: []byte("a"), Seq: []byte("ACGT")}, {ID: []byte("b"), Seq: []byte("ACGTA")}, {ID: []byte("c"), Seq: []byte("acgt")}}
+	names, clusters := nameClusters(seqs, [][]int{{0}, {1}, {2}})
+	if len(names) != 2 || len(clusters) != 2 || fmt.Sprint(clusters) != "[[0 2] [1]]" || names[0] == names[1] {
+		t.Fatalf("clusters with identical representatives were not merged: %v %v", names, clusters)
+	}
+}
+
+// test that every distinct window has its own window ID, including windows that share a start and number of paths
+func TestWindowIDs(t *testing.T) {
+	merger := graph.NewWindowMerger()
+	for _, g := range testParameters.Store {
+		for window := range g.WindowGraph(&graph.WindowOptions{WindowSize: testParameters.WindowSize, KmerSize: testParameters.KmerSize, SketchSize: testParameters.SketchSize, NumWorkers: 1}) {
+			merger.Add(window)
+		}
+	}
+	if len(testParameters.db.Windows) != len(merger.Windows()) {
+		t.Fatalf("index holds %d windows, but %d distinct windows were sketched", len(testParameters.db.Windows), len(merger.Windows()))
+	}
+	seen := make(map[uint32]struct{}, len(testParameters.db.DomainRecords))
+	for _, rec := range testParameters.db.DomainRecords {
+		windowID := rec.Key.(uint32)
+		if _, ok := seen[windowID]; ok {
+			t.Fatalf("window ID used by more than one domain record: %d", windowID)
+		}
+		seen[windowID] = struct{}{}
+		if testParameters.db.Windows[windowID].Sketch[0] != rec.Signature[0] {
+			t.Fatal("domain record does not match its window")
+		}
+	}
+}
+
+// test the provenance manifest
+func TestManifest(t *testing.T) {
+	if err := testParameters.WriteManifest("test-data/tmp/" + ManifestFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("test-data/tmp/" + ManifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Parameters.KmerSize != testParameters.KmerSize || len(manifest.Graphs) != len(testParameters.Store) {
+		t.Fatal("manifest does not match the index")
+	}
+	for _, g := range manifest.Graphs {
+		if g.MD5 == "" || g.Source == "" {
+			t.Fatal("manifest is missing the input file for a graph")
+		}
+		if len(g.Paths) != len(testParameters.Store[g.GraphID].Paths) {
+			t.Fatal("manifest is missing paths for a graph")
+		}
+	}
+}
+
+// test that only new and changed input files are indexed when an index is updated
+func TestCheckInputs(t *testing.T) {
+	if err := os.MkdirAll("test-data/tmp/changed", 0700); err != nil {
+		t.Fatal(err)
+	}
+	msaData, err := ioutil.ReadFile(msaList[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedFile, newFile := "test-data/tmp/changed/test-genes.msa", "test-data/tmp/changed/other-genes.msa"
+	if err := ioutil.WriteFile(changedFile, append(msaData, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(newFile, msaData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := &Info{Store: testParameters.Store, Inputs: testParameters.Inputs, Sources: make(map[uint32]string)}
+	for graphID, source := range testParameters.Sources {
+		info.Sources[graphID] = source
+	}
+
+	// the unchanged file should be left out, the changed file should replace its graph and the new file should be added
+	files, replaced, err := info.CheckInputs([]string{msaList[0], changedFile, newFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != changedFile || files[1] != newFile {
+		t.Fatalf("wrong input files chosen for indexing: %v", files)
+	}
+	graphID, _ := info.FindGraph("test-genes")
+	if _, ok := replaced[graphID]; !ok || len(replaced) != 1 {
+		t.Fatalf("changed input file should replace its graph: %v", replaced)
+	}
+	if files, replaced, err := info.CheckInputs(msaList, nil); err != nil || len(files) != 0 || len(replaced) != 0 {
+		t.Fatal("unchanged input files should be left alone")
+	}
+}
+
+// test writing and loading an index shard
+func TestShards(t *testing.T) {
+	if _, err := SplitShards(testParameters, len(testParameters.Store)+1); err == nil {
+		t.Fatal("should not be able to split the graphs into more shards than there are graphs")
+	}
+	shardInfo := *testParameters
+	if err := shardInfo.WriteIndex("test-data/tmp", 1); err != nil {
+		t.Fatal(err)
+	}
+	shards, err := FindShards("test-data/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 1 || shards[0] != GetShardFiles("test-data/tmp", 0, 1) {
+		t.Fatal("wrong index files found")
+	}
+	loadedInfo, err := LoadShards(shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loadedInfo.Store) != len(testParameters.Store) || loadedInfo.NumShards != 1 || len(loadedInfo.GetShards()) != 1 {
+		t.Fatal("index shard not loaded correctly")
+	}
+	if _, err := FindShards("test-data"); err == nil {
+		t.Fatal("should not find index files in a directory without an index")
+	}
+}
+
+// test that the k-mer prefilter keeps reads from the graphs and rejects unrelated reads
+func TestPrefilter(t *testing.T) {
+	shards, err := FindShards("test-data/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := LoadShards(shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.HasPrefilter() {
+		t.Fatal("index was written without a k-mer prefilter")
+	}
+	for _, g := range info.Store {
+		seqs, err := g.Graph2Seqs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for pathID, seq := range seqs {
+			for start := 0; start+100 <= len(seq); start += 50 {
+				if !info.prefilterRead(seq[start:start+100], SuggestedMinSharedKmers) {
+					t.Fatalf("prefilter rejected a read from path %d of graph %d", pathID, g.GraphID)
+				}
+			}
+		}
+	}
+
+	// a read of unrelated sequence should share too few k-mers with the graphs
+	read := []byte(strings.Repeat("ACCGTTAGCA", 10))
+	if info.prefilterRead(read, SuggestedMinSharedKmers) {
+		t.Fatal("prefilter did not reject an unrelated read")
+	}
+	if !info.prefilterRead(read, 0) {
+		t.Fatal("prefilter should keep every read when turned off")
+	}
+}
+
+// test merging an index with a copy of itself
+func TestMergeIndexes(t *testing.T) {
+	loadIndex := func() (*Info, *graph.ContainmentIndex) {
+		shards, err := FindShards("test-data/tmp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := LoadShards(shards)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := ReadShards(shards)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info, db
+	}
+	info1, db1 := loadIndex()
+	info2, db2 := loadIndex()
+	if _, err := MergeIndexes([]*Info{info1, info2}, []*graph.ContainmentIndex{db1, db2}, nil); err == nil {
+		t.Fatal("should not merge indexes with the same graph names")
+	}
+	info1, db1 = loadIndex()
+	info2, db2 = loadIndex()
+	numWindows, numRecords := len(db1.Windows), len(db1.DomainRecords)
+	merged, err := MergeIndexes([]*Info{info1, info2}, []*graph.ContainmentIndex{db1, db2}, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Store) != 2*len(testParameters.Store) || len(merged.db.Windows) != 2*numWindows || len(merged.db.DomainRecords) != 2*numRecords {
+		t.Fatal("merged index is missing graphs or windows")
+	}
+	for graphID, g := range merged.Store {
+		if g.GraphID != graphID {
+			t.Fatal("graph was not renumbered")
+		}
+	}
+	for _, stats := range merged.db.GetWindowStats() {
+		if stats.Windows != numWindows/len(testParameters.Store) {
+			t.Fatal("windows were not renumbered with their graphs")
+		}
+	}
+	windowIDs := make(map[uint32]struct{}, len(merged.db.DomainRecords))
+	for _, rec := range merged.db.DomainRecords {
+		windowID, ok := rec.Key.(uint32)
+		if _, seen := windowIDs[windowID]; !ok || seen || int(windowID) >= len(merged.db.Windows) {
+			t.Fatalf("domain record key is not a unique window ID: %v", rec.Key)
+		}
+		windowIDs[windowID] = struct{}{}
+	}
+
+	// the merged graphs are copies of each other, so every window should be shared
+	numShared, err := merged.TagSharedWindows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numShared != len(merged.db.Windows) {
+		t.Fatalf("all windows should be shared by the copied graphs (%d of %d tagged)", numShared, len(merged.db.Windows))
+	}
+	regions, err := merged.SharedRegions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) == 0 {
+		t.Fatal("no shared regions reported for the copied graphs")
+	}
+	for _, region := range regions {
+		if region.Graph == region.SharedWith || region.End-region.Start < testParameters.WindowSize {
+			t.Fatalf("bad shared region reported: %+v", region)
+		}
+	}
+
+	// unnamed graphs keep their graphID as their name, and unnamed graphs with the same graphID need prefixes to be merged
+	info1, db1 = loadIndex()
+	info2, db2 = loadIndex()
+	info1.Sources, info2.Sources = nil, nil
+	if _, err := MergeIndexes([]*Info{info1, info2}, []*graph.ContainmentIndex{db1, db2}, nil); err == nil {
+		t.Fatal("should not merge unnamed graphs with the same graphID without prefixes")
+	}
+	info1, db1 = loadIndex()
+	info2, db2 = loadIndex()
+	info1.Sources = nil
+	unnamed, err := MergeIndexes([]*Info{info1, info2}, []*graph.ContainmentIndex{db1, db2}, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for testID := range testParameters.Store {
+		if _, ok := unnamed.FindGraph(fmt.Sprintf("a-%d", testID)); !ok {
+			t.Fatal("unnamed graph did not keep its graphID as its name")
+		}
+	}
+
+	// the merged graphIDs come from the prefixed names, so they don't depend on the order of the indexes
+	info1, db1 = loadIndex()
+	info2, db2 = loadIndex()
+	reversed, err := MergeIndexes([]*Info{info2, info1}, []*graph.ContainmentIndex{db2, db1}, []string{"b", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for graphID := range merged.Store {
+		if reversed.GraphName(graphID) != merged.GraphName(graphID) {
+			t.Fatal("merged graphIDs depend on the order of the indexes")
+		}
+	}
+
+	// remove the first copy of the graphs
+	var graphID uint32
+	var ok bool
+	for testID := range testParameters.Store {
+		graphID, ok = merged.FindGraph("a-" + testParameters.GraphName(testID))
+	}
+	if !ok {
+		t.Fatal("could not find graph by name")
+	}
+	if _, err := merged.RemoveGraphs(map[uint32]struct{}{graphID: {}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := merged.Store[graphID]; ok || len(merged.Store) != len(testParameters.Store) || len(merged.db.Windows) != numWindows {
+		t.Fatal("graph was not removed from the index")
+	}
+	if _, err := merged.RemoveGraphs(map[uint32]struct{}{graphID: {}}); err == nil {
+		t.Fatal("should not remove a graph that is not in the index")
+	}
+}
+
+// test that near-identical windows from different graphs are tagged as shared, but not windows from the same graph or windows that are too different
+func TestSharedWindows(t *testing.T) {
+	sketchSize := 20
+	sketch := func(changed int) []uint64 {
+		s := make([]uint64, sketchSize)
+		for i := range s {
+			s[i] = uint64(i + 1)
+			if i < changed {
+				s[i] += 1000
+			}
+		}
+		return s
+	}
+	windows := []*lshforest.Key{
+		{GraphID: 1, WindowSize: 100, Sketch: sketch(0)},
+		{GraphID: 1, WindowSize: 100, Sketch: sketch(0)}, // identical, but from the same graph
+		{GraphID: 2, WindowSize: 100, Sketch: sketch(2)}, // 0.9 similar to graph 1
+		{GraphID: 3, WindowSize: 100, Sketch: sketch(6)}, // 0.7 similar to graph 1, 0.8 similar to graph 2
+		{GraphID: 4, WindowSize: 50, Sketch: sketch(0)},  // identical to graph 1, but a different window size
+	}
+	sharedInfo := &Info{SketchSize: sketchSize, SharedSimilarity: 0.9}
+	sharedInfo.AttachDB(&graph.ContainmentIndex{Windows: windows})
+	numShared, err := sharedInfo.TagSharedWindows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numShared != 3 {
+		t.Fatalf("expected 3 windows to be tagged as shared, not %d", numShared)
+	}
+	for i, expected := range [][]uint32{{2}, {2}, {1}, nil, nil} {
+		if fmt.Sprint(windows[i].SharedWith) != fmt.Sprint(expected) {
+			t.Fatalf("window %d is shared with %v, not %v", i, windows[i].SharedWith, expected)
+		}
+	}
+}
+
+// test validating the index with simulated reads
+func TestValidateIndex(t *testing.T) {
+	validateInfo := newValidateInfo()
+	if _, err := ValidateIndex(validateInfo, nil); err == nil {
+		t.Fatal("should not validate without an index")
+	}
+	lshe := &graph.ContainmentIndex{}
+	if err := lshe.Load("test-data/tmp/groot.lshe"); err != nil {
+		t.Fatal(err)
+	}
+	defer lshe.Close()
+	report, err := ValidateIndex(validateInfo, []*graph.ContainmentIndex{lshe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Graphs) != len(testParameters.Store) {
+		t.Fatal("validation report is missing graphs")
+	}
+	for _, gv := range report.Graphs {
+		if gv.Reads == 0 {
+			t.Fatalf("no reads simulated for graph %v", gv.Name)
+		}
+		t.Logf("graph %v: recall %.2f, wrong graph hit rate %.2f, %d groups of indistinguishable paths", gv.Name, gv.Recall(), gv.WrongGraphRate(), len(gv.IndistinguishablePaths))
+	}
+	if report.Recall() < 0.9 {
+		t.Fatalf("recall of error-free reads is too low: %.2f", report.Recall())
+	}
+}
+
+// test querying the simulated reads in two stages, choosing the graphs with the coarse index first
+func TestHierarchicalQuery(t *testing.T) {
+	coarseInfo := newValidateInfo()
+	coarseInfo.CoarseScale = 4
+	if err := coarseInfo.UseCoarseIndex(); err == nil {
+		t.Fatal("should not use a coarse index before it is built")
+	}
+	if err := coarseInfo.BuildKmerIndexes(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the coarse sketches should be saved with the graphs
+	if err := coarseInfo.Dump("test-data/tmp/coarse.gg"); err != nil {
+		t.Fatal(err)
+	}
+	loadedInfo := new(Info)
+	if err := loadedInfo.Load("test-data/tmp/coarse.gg"); err != nil {
+		t.Fatal(err)
+	}
+	if !loadedInfo.HasCoarseIndex() || len(loadedInfo.coarseSketches) != len(coarseInfo.Store) {
+		t.Fatal("coarse sketches were not saved with the graphs")
+	}
+	if err := coarseInfo.UseCoarseIndex(); err != nil {
+		t.Fatal(err)
+	}
+	lshe := &graph.ContainmentIndex{}
+	if err := lshe.Load("test-data/tmp/groot.lshe"); err != nil {
+		t.Fatal(err)
+	}
+	defer lshe.Close()
+	report, err := ValidateIndex(coarseInfo, []*graph.ContainmentIndex{lshe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Recall() < 0.9 {
+		t.Fatalf("recall of error-free reads is too low with two-stage querying: %.2f", report.Recall())
+	}
+}
+
+// test measuring the index size and recall of different window selections
+func TestWindowTradeoff(t *testing.T) {
+	tradeoffInfo := newValidateInfo()
+	store, db, numSources := tradeoffInfo.Store, tradeoffInfo.db, len(tradeoffInfo.Sources)
+	tradeoffs, err := MeasureWindowTradeoff(tradeoffInfo, []int{4, 4, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tradeoffInfo.WindowStride != testParameters.WindowStride || tradeoffInfo.db != db || len(tradeoffInfo.Sources) != numSources || len(tradeoffInfo.BackgroundRegions) != 0 {
+		t.Fatal("measuring the trade-off should not change the runtime info")
+	}
+	for graphID, g := range store {
+		if tradeoffInfo.Store[graphID] != g {
+			t.Fatal("measuring the trade-off should not change the graph store")
+		}
+	}
+	if len(tradeoffs) != 3 || tradeoffs[0].Selection() != "all" || tradeoffs[1].Selection() != "stride" || tradeoffs[2].Selection() != "minimizer" {
+		t.Fatalf("wrong window selections measured: %d", len(tradeoffs))
+	}
+	for _, tradeoff := range tradeoffs[1:] {
+		if tradeoff.Windows == 0 || tradeoff.Windows >= tradeoffs[0].Windows {
+			t.Fatalf("%v selection should index fewer windows than the full index (%d vs. %d)", tradeoff.Selection(), tradeoff.Windows, tradeoffs[0].Windows)
+		}
+		if tradeoff.Recall < 0.8 {
+			t.Fatalf("recall of error-free reads is too low with %v selection: %.2f", tradeoff.Selection(), tradeoff.Recall)
+		}
+		t.Logf("%v selection: %d windows, recall %.2f", tradeoff.Selection(), tradeoff.Windows, tradeoff.Recall)
+	}
+	if err := WriteWindowTradeoff("test-data/tmp/"+TradeoffFile, tradeoffs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// test that windows dominated by background k-mers are left out of the index, and that the index records what was removed
+func TestBackgroundIndex(t *testing.T) {
+	if err := os.MkdirAll("test-data/tmp/background", 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// use the start of a graph path as the background
+	var pathSeqs map[uint32][]byte
+	for _, g := range testParameters.Store {
+		var err error
+		if pathSeqs, err = g.Graph2Seqs(); err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	backgroundFile := "test-data/tmp/background/background.fna"
+	if err := ioutil.WriteFile(backgroundFile, []byte(">background\n"+string(pathSeqs[0][:250])+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := &Info{
+		NumProc:    1,
+		Version:    testParameters.Version,
+		KmerSize:   testParameters.KmerSize,
+		SketchSize: testParameters.SketchSize,
+		WindowSize: testParameters.WindowSize,
+		NumPart:    testParameters.NumPart,
+		MaxK:       testParameters.MaxK,
+	}
+	if err := info.LoadBackground(backgroundFile, 0); err == nil {
+		t.Fatal("should not accept a background proportion of 0")
+	}
+	if err := info.LoadBackground(backgroundFile, DefaultMaxBackground); err != nil {
+		t.Fatal(err)
+	}
+	if info.Background.Sequences != 1 || info.Background.Kmers != 250-info.KmerSize+1 || info.Background.MD5 == "" {
+		t.Fatalf("background was not recorded: %+v", info.Background)
+	}
+
+	// index the graphs with the background
+	msaConverter := NewMSAconverter(info)
+	graphSketcher := NewGraphSketcher(info)
+	sketchIndexer := NewSketchIndexer(info)
+	msaConverter.Connect(msaList)
+	graphSketcher.Connect(msaConverter)
+	sketchIndexer.Connect(graphSketcher)
+	indexingPipeline := NewPipeline()
+	indexingPipeline.AddProcesses(msaConverter, graphSketcher, sketchIndexer)
+	indexingPipeline.Run()
+	if info.NumBackgroundWindows() == 0 || len(info.db.Windows) >= len(testParameters.db.Windows) {
+		t.Fatalf("no windows were left out as background (%d vs. %d windows)", len(info.db.Windows), len(testParameters.db.Windows))
+	}
+
+	// the background should be saved with the index and recorded in the manifest
+	if err := info.Dump("test-data/tmp/background/groot.gg"); err != nil {
+		t.Fatal(err)
+	}
+	loaded := &Info{}
+	if err := loaded.Load("test-data/tmp/background/groot.gg"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Background == nil || *loaded.Background != *info.Background || loaded.background.NumKmers() != info.background.NumKmers() || loaded.NumBackgroundWindows() != info.NumBackgroundWindows() {
+		t.Fatal("background was not saved with the index")
+	}
+	manifest := info.GetManifest()
+	if manifest.Background == nil || manifest.Background.MD5 != info.Background.MD5 {
+		t.Fatal("manifest does not record the background")
+	}
+	numRegions := 0
+	for _, g := range manifest.Graphs {
+		for _, region := range g.BackgroundRegions {
+			if region.Path == "" || region.End-region.Start < info.WindowSize || region.Windows < 1 {
+				t.Fatalf("bad background region in the manifest: %+v", region)
+			}
+			numRegions++
+		}
+	}
+	if numRegions == 0 {
+		t.Fatal("manifest does not record the regions left out as background")
+	}
+}
+
 // benchmark indexing
 func BenchmarkIndexing(b *testing.B) {
 	// run the add method b.N times
 	for n := 0; n < b.N; n++ {
+		benchParameters := *testParameters
+		benchParameters.Store = nil
+		benchParameters.AttachDB(nil)
 		indexingPipeline := NewPipeline()
-		msaConverter := NewMSAconverter(testParameters)
-		graphSketcher := NewGraphSketcher(testParameters)
-		sketchIndexer := NewSketchIndexer(testParameters)
+		msaConverter := NewMSAconverter(&benchParameters)
+		graphSketcher := NewGraphSketcher(&benchParameters)
+		sketchIndexer := NewSketchIndexer(&benchParameters)
 		msaConverter.Connect(msaList)
 		graphSketcher.Connect(msaConverter)
 		sketchIndexer.Connect(graphSketcher)
@@ -49,3 +621,387 @@ func BenchmarkIndexing(b *testing.B) {
 		indexingPipeline.Run()
 	}
 }
+
+// the number of copies of the test graph windowed by the windowing benchmarks, to mimic a database with many clusters
+const benchGraphs = 16
+
+// the number of mutated copies of the test graph in the database used by the query benchmarks, and the proportion of alignment columns mutated in each copy
+const (
+	benchQueryGraphs = 32
+	benchMutation    = 0.1
+)
+
+// benchmark querying reads against every window in the index, reporting the recall of simulated reads
+func BenchmarkQueryFlat(b *testing.B) {
+	benchmarkQuery(b, 0)
+}
+
+// benchmark two-stage querying with several coarse thresholds, reporting the recall of simulated reads
+func BenchmarkQueryHierarchical(b *testing.B) {
+	for _, coarseThreshold := range []float64{0.25, 0.5, 0.75} {
+		b.Run(fmt.Sprintf("coarseThresh=%.2f", coarseThreshold), func(b *testing.B) {
+			benchmarkQuery(b, coarseThreshold)
+		})
+	}
+}
+
+// benchmarkQuery is a helper function to query reads simulated from a database of mutated copies of the test graph, using two-stage querying if a coarse threshold is given
+func benchmarkQuery(b *testing.B, coarseThreshold float64) {
+	info, db := benchQueryDatabase(b)
+	info.coarse = nil
+	if coarseThreshold > 0 {
+		info.Sketch.CoarseThreshold = coarseThreshold
+		if err := info.UseCoarseIndex(); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// simulate reads with errors from each graph and sketch them
+	type benchRead struct {
+		graphID uint32
+		seq     []byte
+		sketch  []uint64
+	}
+	reads := []benchRead{}
+	r := rand.New(rand.NewSource(validationSeed))
+	for graphID := uint32(0); graphID < benchQueryGraphs; graphID++ {
+		pathSeqs, err := info.Store[graphID].Graph2Seqs()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for pathID := uint32(0); pathID < uint32(len(pathSeqs)); pathID += 8 {
+			for _, seq := range seqio.SimulateReads(pathSeqs[pathID], 100, 4, 0.005, r) {
+				sketch, err := minhash.GetReadSketch(seq, uint(info.KmerSize), uint(info.SketchSize), false, info.DustLevel > 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				reads = append(reads, benchRead{graphID, seq, sketch})
+			}
+		}
+	}
+
+	// query every read b.N times, counting the reads that hit their own graph
+	recalled := 0
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		recalled = 0
+		for _, read := range reads {
+			hits, _, err := info.queryRead([]*graph.ContainmentIndex{db}, read.seq, read.sketch, len(read.seq)+info.KmerSize-1, info.ContainmentThreshold)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, hit := range hits {
+				if hit.GraphID == read.graphID {
+					recalled++
+					break
+				}
+			}
+		}
+	}
+	b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N*len(reads)), "ns/read")
+	b.ReportMetric(float64(recalled)/float64(len(reads)), "recall")
+}
+
+// the database used by the query benchmarks, which is only built once
+var (
+	benchQueryOnce sync.Once
+	benchQueryInfo *Info
+	benchQueryDB   *graph.ContainmentIndex
+)
+
+// benchQueryDatabase is a helper function to index mutated copies of the test graph, with a coarse index, to mimic a database with many clusters
+// the fine index is populated and its per-graph LSH Ensembles are built before it is returned, so that the benchmarks only time querying
+func benchQueryDatabase(b *testing.B) (*Info, *graph.ContainmentIndex) {
+	benchQueryOnce.Do(func() {
+		msaData, err := ioutil.ReadFile(msaList[0])
+		if err != nil {
+			b.Fatal(err)
+		}
+		tmpDir, err := ioutil.TempDir("", "groot-bench-")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+		r := rand.New(rand.NewSource(validationSeed))
+		graphs := make([]*graph.GrootGraph, benchQueryGraphs)
+		for i := range graphs {
+			fileName := fmt.Sprintf("%v/mutated-%d.msa", tmpDir, i)
+			if err := ioutil.WriteFile(fileName, mutateMSA(msaData, benchMutation, r), 0644); err != nil {
+				b.Fatal(err)
+			}
+			msa, err := graph.ReadMSA(fileName)
+			if err != nil {
+				b.Fatal(err)
+			}
+			newGFA, err := gfa.MSA2GFA(msa)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if graphs[i], err = graph.CreateGrootGraph(newGFA, i); err != nil {
+				b.Fatal(err)
+			}
+		}
+		info := *testParameters
+		info.NumProc = runtime.NumCPU()
+		info.CoarseScale = 8
+		info.ContainmentThreshold = 0.9
+		info.Store = nil
+		info.AttachDB(nil)
+		graphSketcher := NewGraphSketcher(&info)
+		graphSketcher.input = streamGraphs(graphs)
+		sketchIndexer := NewSketchIndexer(&info)
+		sketchIndexer.Connect(graphSketcher)
+		indexingPipeline := NewPipeline()
+		indexingPipeline.AddProcesses(graphSketcher, sketchIndexer)
+		indexingPipeline.Run()
+		if err := info.BuildKmerIndexes(); err != nil {
+			b.Fatal(err)
+		}
+		if err := info.db.Populate(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := info.db.QueryGraphs(make([]uint64, info.SketchSize), 100, info.ContainmentThreshold, nil); err != nil {
+			b.Fatal(err)
+		}
+		benchQueryInfo, benchQueryDB = &info, info.db
+	})
+	if benchQueryInfo == nil {
+		b.Fatal("could not build the query benchmark database")
+	}
+	info := *benchQueryInfo
+	return &info, benchQueryDB
+}
+
+// mutateMSA is a helper function to substitute the bases in a proportion of the columns of an aligned FASTA file, giving every sequence the same substitution
+func mutateMSA(msaData []byte, rate float64, r *rand.Rand) []byte {
+	substitute := map[byte]byte{'A': 'C', 'C': 'G', 'G': 'T', 'T': 'A'}
+	mutated := make(map[int]bool)
+	lines := strings.Split(string(msaData), "\n")
+	column := 0
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") {
+			column = 0
+			continue
+		}
+		seq := []byte(line)
+		for j := range seq {
+			if _, ok := mutated[column]; !ok {
+				mutated[column] = r.Float64() < rate
+			}
+			if replacement, ok := substitute[seq[j]]; ok && mutated[column] {
+				seq[j] = replacement
+			}
+			column++
+		}
+		lines[i] = string(seq)
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// benchmark windowing with the bounded worker pool used by the GraphSketcher, reporting the peak RSS on Linux
+func BenchmarkWindowingPool(b *testing.B) {
+	benchmarkWindowing(b, func(info *Info, graphs []*graph.GrootGraph) {
+		graphSketcher := NewGraphSketcher(info)
+		graphSketcher.input = streamGraphs(graphs)
+		sketchIndexer := NewSketchIndexer(info)
+		sketchIndexer.Connect(graphSketcher)
+		windowingPipeline := NewPipeline()
+		windowingPipeline.AddProcesses(graphSketcher, sketchIndexer)
+		windowingPipeline.Run()
+	})
+}
+
+// benchmark windowing with the previous design, which started a go routine per graph and per path, sketched each window from scratch and held every window of a graph before sending any on
+func BenchmarkWindowingUnbounded(b *testing.B) {
+	benchmarkWindowing(b, func(info *Info, graphs []*graph.GrootGraph) {
+		windows := make(chan *lshforest.Key, BUFFERSIZE)
+		var wg sync.WaitGroup
+		wg.Add(len(graphs))
+		for _, g := range graphs {
+			go func(g *graph.GrootGraph) {
+				defer wg.Done()
+				for window := range baselineWindowGraph(g, info.WindowSize, info.KmerSize, info.SketchSize) {
+					windows <- window
+				}
+			}(g)
+		}
+		go func() {
+			wg.Wait()
+			close(windows)
+		}()
+		sketchIndexer := NewSketchIndexer(info)
+		sketchIndexer.input = windows
+		sketchIndexer.Run()
+	})
+}
+
+// baselineWindowGraph is a helper function that windows a graph in the same way as the WindowGraph method did before windowing was bounded by a worker pool
+func baselineWindowGraph(g *graph.GrootGraph, windowSize, kmerSize, sketchSize int) chan *lshforest.Key {
+	pathSeqs, err := g.Graph2Seqs()
+	if err != nil {
+		panic(err)
+	}
+	windowChan := make(chan *lshforest.Key)
+	var wg sync.WaitGroup
+	wg.Add(len(g.Paths))
+	for pathID := range g.Paths {
+		go func(pathID uint32) {
+			defer wg.Done()
+			pathLength := g.Lengths[pathID]
+			segs := make([]uint64, pathLength)
+			offSets := make([]uint32, pathLength)
+			iterator := 0
+			for _, node := range g.SortedNodes {
+				for _, id := range node.PathIDs {
+					if id == pathID {
+						for offset := uint32(0); offset < uint32(len(node.Sequence)); offset++ {
+							segs[iterator] = node.SegmentID
+							offSets[iterator] = offset
+							iterator++
+						}
+					}
+				}
+			}
+			sequence := pathSeqs[pathID]
+			for i := 0; i < pathLength-windowSize+1; i++ {
+				windowSeq := seqio.Sequence{Seq: sequence[i : i+windowSize]}
+				sketch, err := windowSeq.RunMinHash(kmerSize, sketchSize, false, nil)
+				if err != nil {
+					panic(err)
+				}
+				ContainedNodes := make(map[uint64]float64)
+				for _, y := range segs[i : i+windowSize] {
+					ContainedNodes[y]++
+				}
+				windowChan <- &lshforest.Key{
+					GraphID:        g.GraphID,
+					Node:           segs[i],
+					OffSet:         offSets[i],
+					ContainedNodes: ContainedNodes,
+					Ref:            []uint32{pathID},
+					Sketch:         sketch,
+					WindowSize:     uint32(windowSize),
+				}
+			}
+		}(pathID)
+	}
+	go func() {
+		wg.Wait()
+		close(windowChan)
+	}()
+
+	// combine the windows with the same sketch, then send them on once the whole graph is windowed
+	sketchSeen := make(map[string]*lshforest.Key)
+	for window := range windowChan {
+		stringifiedSketch := lshforest.CompressSketch2String(window.Sketch)
+		if existingWindow, ok := sketchSeen[stringifiedSketch]; ok {
+			for node := range window.ContainedNodes {
+				existingWindow.ContainedNodes[node]++
+			}
+			existingWindow.Ref = append(existingWindow.Ref, window.Ref...)
+		} else {
+			sketchSeen[stringifiedSketch] = window
+		}
+	}
+	mergedChan := make(chan *lshforest.Key)
+	go func() {
+		for _, window := range sketchSeen {
+			mergedChan <- window
+		}
+		close(mergedChan)
+	}()
+	return mergedChan
+}
+
+// benchmarkWindowing is a helper function to window and index copies of the test graph, reporting the highest peak RSS of the runs
+// the RSS is read from /proc, so it is only reported on Linux
+func benchmarkWindowing(b *testing.B, windowGraphs func(*Info, []*graph.GrootGraph)) {
+	msa, err := graph.ReadMSA(msaList[0])
+	if err != nil {
+		b.Fatal(err)
+	}
+	peak := 0.0
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		graphs := make([]*graph.GrootGraph, benchGraphs)
+		for i := range graphs {
+			newGFA, err := gfa.MSA2GFA(msa)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if graphs[i], err = graph.CreateGrootGraph(newGFA, i); err != nil {
+				b.Fatal(err)
+			}
+		}
+		benchParameters := *testParameters
+		benchParameters.NumProc = runtime.NumCPU()
+		benchParameters.Store = nil
+		benchParameters.AttachDB(nil)
+		b.StartTimer()
+		if runtime.GOOS != "linux" {
+			windowGraphs(&benchParameters, graphs)
+			continue
+		}
+		if rss := measurePeakRSS(func() { windowGraphs(&benchParameters, graphs) }); rss > peak {
+			peak = rss
+		}
+	}
+	if runtime.GOOS == "linux" {
+		b.ReportMetric(peak, "peak-RSS-MB")
+	}
+}
+
+// streamGraphs is a helper function to send graphs down a channel
+func streamGraphs(graphs []*graph.GrootGraph) chan *graph.GrootGraph {
+	graphChan := make(chan *graph.GrootGraph)
+	go func() {
+		for _, g := range graphs {
+			graphChan <- g
+		}
+		close(graphChan)
+	}()
+	return graphChan
+}
+
+// measurePeakRSS is a helper function to run f while sampling the resident set size of the process, returning the peak in MB (Linux only)
+func measurePeakRSS(f func()) float64 {
+	debug.FreeOSMemory()
+	done, peak := make(chan struct{}), make(chan int)
+	go func() {
+		max := readRSS()
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				peak <- max
+				return
+			case <-ticker.C:
+				if rss := readRSS(); rss > max {
+					max = rss
+				}
+			}
+		}
+	}()
+	f()
+	close(done)
+	return float64(<-peak) / 1024
+}
+
+// readRSS is a helper function to read the current resident set size (in kB) of the process from /proc
+func readRSS() int {
+	fh, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 1 && fields[0] == "VmRSS:" {
+			rss, _ := strconv.Atoi(fields[1])
+			return rss
+		}
+	}
+	return 0
+}
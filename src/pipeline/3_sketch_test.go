@@ -1,10 +1,13 @@
 package pipeline
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/lshforest"
 	"github.com/will-rowe/baby-groot/src/misc"
 )
 
@@ -20,6 +23,10 @@ func TestSketching(t *testing.T) {
 		t.Fatal(err)
 	}
 	testParameters.AttachDB(lshe)
+	mappings := &bytes.Buffer{}
+	if err := testParameters.RecordMappings(mappings); err != nil {
+		t.Fatal(err)
+	}
 
 	// run the pipeline
 	sketchingPipeline := NewPipeline()
@@ -44,6 +51,25 @@ func TestSketching(t *testing.T) {
 	t.Logf("total number of test reads = %d", readStats[0])
 	t.Logf("number which mapped = %d", readStats[1])
 
+	// check that each mapped read is in the mapping report
+	mappedReads := make(map[string]struct{})
+	for i, line := range strings.Split(strings.TrimSpace(mappings.String()), "\n") {
+		fields := strings.Split(line, "\t")
+		if i == 0 {
+			if line+"\n" != MappingsHeader {
+				t.Fatalf("mapping report has the wrong header: %v", line)
+			}
+			continue
+		}
+		if len(fields) != 5 || fields[4] != "." {
+			t.Fatalf("mapping report has a badly formatted line (or a strand for an unstranded index): %v", line)
+		}
+		mappedReads[fields[0]] = struct{}{}
+	}
+	if len(mappedReads) != readStats[1] {
+		t.Fatalf("mapping report has %d reads, but %d reads mapped", len(mappedReads), readStats[1])
+	}
+
 	// check that we got the right allele in the approximately weighted graph
 	foundPaths := graphPruner.CollectOutput()
 	correctPath := false
@@ -59,8 +85,61 @@ func TestSketching(t *testing.T) {
 		t.Fatal(err)
 	}
 	for graphID, g := range testParameters.Store {
-		fileName := fmt.Sprintf("test-data/tmp/groot-graph-%d.gfa", graphID)
+		fileName := fmt.Sprintf("test-data/tmp/groot-graph-%v.gfa", testParameters.GraphFileName(graphID))
 		_, err := g.SaveGraphAsGFA(fileName, readStats[3])
 		misc.ErrorCheck(err)
 	}
 }
+
+// test the strand of each mapping is reported for a stranded index
+func TestWriteMappings(t *testing.T) {
+	mappings := &bytes.Buffer{}
+	info := &Info{Stranded: true, Sources: map[uint32]string{3: "blaA"}}
+	if err := info.RecordMappings(mappings); err != nil {
+		t.Fatal(err)
+	}
+	hits := readHits{
+		id:   0,
+		name: []byte("@read1 extra"),
+		mappings: []*lshforest.Key{
+			{GraphID: 3, Node: 7, OffSet: 2},
+			{GraphID: 3, Node: 9, OffSet: 0, RC: true},
+		},
+	}
+	if err := info.writeMappings(hits); err != nil {
+		t.Fatal(err)
+	}
+	if err := info.writeMappings(readHits{id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expected := MappingsHeader + "read1\tblaA\t7\t2\t+\nread1\tblaA\t9\t0\t-\n"
+	if mappings.String() != expected {
+		t.Fatalf("wrong mapping report:\n%v", mappings.String())
+	}
+}
+
+// test the shared window policies: flag projects every k-mer, distribute splits the k-mers between the graphs and drop skips the hit
+func TestSharedPolicies(t *testing.T) {
+	kmerCount := 90.0
+	shared := &lshforest.Key{GraphID: 1, SharedWith: []uint32{2, 3}}
+	unshared := &lshforest.Key{GraphID: 1}
+	for _, test := range []struct {
+		policy string
+		freq   float64
+		keep   bool
+	}{
+		{"", kmerCount, true},
+		{SharedFlag, kmerCount, true},
+		{SharedDistribute, kmerCount / 3, true},
+		{SharedDrop, 0, false},
+	} {
+		sketchCmd := &SketchCmd{SharedPolicy: test.policy}
+		freq, keep := sketchCmd.sharedHitFreq(shared, kmerCount)
+		if freq != test.freq || keep != test.keep {
+			t.Fatalf("%v policy gave %v k-mers (keep: %v) for a shared window, not %v (keep: %v)", sketchCmd.GetSharedPolicy(), freq, keep, test.freq, test.keep)
+		}
+		if freq, keep := sketchCmd.sharedHitFreq(unshared, kmerCount); freq != kmerCount || !keep {
+			t.Fatalf("%v policy should not change hits on windows that aren't shared", sketchCmd.GetSharedPolicy())
+		}
+	}
+}
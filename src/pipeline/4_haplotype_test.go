@@ -30,7 +30,7 @@ func TestHaplotyping(t *testing.T) {
 	}
 
 	for graphID, g := range testParameters.Store {
-		fileName := fmt.Sprintf("test-data/tmp/groot-graph-%d-haplotype", graphID)
+		fileName := fmt.Sprintf("test-data/tmp/groot-graph-%v-haplotype", testParameters.GraphFileName(graphID))
 		_, err := g.SaveGraphAsGFA(fileName+".gfa", 0)
 		if err != nil {
 			t.Fatal(err)
@@ -69,10 +69,10 @@ func TestHaplotyping(t *testing.T) {
 	if err := os.Remove("test-data/tmp/groot.lshe"); err != nil {
 		t.Fatal("indexing did not create index file: ", err)
 	}
-	if err := os.Remove("test-data/tmp/groot-graph-0.gfa"); err != nil {
+	if err := os.Remove("test-data/tmp/groot-graph-test-genes.gfa"); err != nil {
 		t.Fatal("sketching did not create graph file: ", err)
 	}
-	if err := os.Remove("test-data/tmp/groot-graph-0-haplotype.fna"); err != nil {
+	if err := os.Remove("test-data/tmp/groot-graph-test-genes-haplotype.fna"); err != nil {
 		t.Fatal("haplotyping did not create fasta file: ", err)
 	}
 	if err := os.RemoveAll("test-data/tmp"); err != nil {
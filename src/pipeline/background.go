@@ -0,0 +1,80 @@
+package pipeline
+
+/*
+ this part of the pipeline subtracts background k-mers (e.g. from housekeeping genes or plasmid backbones) at index time, so that graph windows dominated by them don't attract reads from the background
+ the background k-mers are saved with the index, so that graphs added to the index later are windowed in the same way
+*/
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/misc"
+	"github.com/will-rowe/baby-groot/src/seqio"
+)
+
+// DefaultMaxBackground is the default proportion of background k-mers at which a graph window is left out of the index
+const DefaultMaxBackground = 0.5
+
+// BackgroundSet records the background sequences that were subtracted from the graph windows
+type BackgroundSet struct {
+	Path          string  `json:"path"`
+	MD5           string  `json:"md5"`
+	Sequences     int     `json:"sequences"`
+	Kmers         int     `json:"kmers"`
+	MaxBackground float64 `json:"maxBackground"`
+}
+
+// LoadBackground is a method to read the background sequences from a FASTA file and collect their k-mers, so that graph windows where at least maxBackground of the k-mers are background are left out of the index
+func (Info *Info) LoadBackground(fastaFile string, maxBackground float64) error {
+	if maxBackground <= 0 || maxBackground > 1 {
+		return fmt.Errorf("the background proportion must be greater than 0 and no more than 1")
+	}
+	sequences, err := seqio.ReadFASTA(fastaFile)
+	if err != nil {
+		return err
+	}
+	if len(sequences) == 0 {
+		return fmt.Errorf("no sequences found in the background file: %v", fastaFile)
+	}
+	md5sum, err := misc.GetMD5(fastaFile)
+	if err != nil {
+		return err
+	}
+	seqs := make([][]byte, len(sequences))
+	for i, sequence := range sequences {
+		seqs[i] = sequence.Seq
+	}
+	Info.background = graph.NewBackground(seqs, Info.KmerSize)
+	if absPath, err := filepath.Abs(fastaFile); err == nil {
+		fastaFile = absPath
+	}
+	Info.Background = &BackgroundSet{
+		Path:          fastaFile,
+		MD5:           md5sum,
+		Sequences:     len(sequences),
+		Kmers:         Info.background.NumKmers(),
+		MaxBackground: maxBackground,
+	}
+	return nil
+}
+
+// sameBackground is a method to check that two indexes subtracted the same background (or no background)
+func (Info *Info) sameBackground(other *Info) bool {
+	if Info.Background == nil || other.Background == nil {
+		return Info.Background == nil && other.Background == nil
+	}
+	return Info.Background.MD5 == other.Background.MD5 && Info.Background.MaxBackground == other.Background.MaxBackground
+}
+
+// NumBackgroundWindows is a method to return the number of path windows that were left out of the index as background (counted across every window set)
+func (Info *Info) NumBackgroundWindows() int {
+	numWindows := 0
+	for _, regions := range Info.BackgroundRegions {
+		for _, region := range regions {
+			numWindows += region.Windows
+		}
+	}
+	return numWindows
+}
@@ -1,8 +1,17 @@
 package pipeline
 
 import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
 	"sync"
 
+	"github.com/will-rowe/baby-groot/src/graph"
 	"github.com/will-rowe/baby-groot/src/lshforest"
 	"github.com/will-rowe/baby-groot/src/minhash"
 	"github.com/will-rowe/baby-groot/src/misc"
@@ -10,16 +19,45 @@ import (
 
 // theBoss is used to orchestrate the minions
 type theBoss struct {
-	info                *Info          // the runtime info for the pipeline
-	graphMinionRegister []*graphMinion // used to keep a record of the graph minions
-	reads               chan []byte    // the boss uses this channel to receive data from the main sketching pipeline
-	receivedReadCount   int            // the number of reads the boss is sent during it's lifetime
-	mappedCount         int            // the total number of reads that were successful mapped to at least one graph
-	multimappedCount    int            // the total number of reads that had multiple mappings
+	info                *Info                   // the runtime info for the pipeline
+	graphMinionRegister map[uint32]*graphMinion // used to keep a record of the graph minions (keyed by graphID, which may not be contiguous)
+	reads               chan indexedRead        // the boss uses this channel to receive data from the main sketching pipeline
+	receivedReadCount   int                     // the number of reads the boss is sent during it's lifetime
+	mappedCount         int                     // the total number of reads that were successful mapped to at least one graph
+	multimappedCount    int                     // the total number of reads that had multiple mappings
+	strandCounts        [3]int                  // the number of mapped reads that hit windows on the forward strand only, the reverse strand only, or both strands (stranded indexes only)
+	sharedCount         int                     // the number of reads that hit at least one window shared by several graphs
+	prefilteredCount    int                     // the number of reads that were rejected by the k-mer prefilter, without being sketched
+	coarseMissCount     int                     // the number of reads that the coarse index didn't choose any graphs for (two-stage querying only)
+}
+
+// the strands of the windows that a read hit, whether any of them were shared by several graphs and whether the read was rejected by the prefilter or the coarse index, recorded as bit flags
+const (
+	forwardStrand uint8 = 1 << iota
+	reverseStrand
+	sharedWindow
+	prefilteredRead
+	coarseMiss
+)
+
+// indexedRead is a read and its position in the input, which is used to combine the hits for a read across index shards
+type indexedRead struct {
+	id   int
+	name []byte // the ID line of the read, used to report its mappings
+	seq  []byte
+}
+
+// readHits records the number of graph windows that a read hit, and the flags for those windows
+type readHits struct {
+	id       int
+	name     []byte
+	numHits  int
+	flags    uint8
+	mappings []*lshforest.Key // the graph windows that the read mapped to (only kept if the mappings are being recorded)
 }
 
 // mapReads is a function to start off the minions to map reads, the minions to augement graphs, and to return their boss
-func mapReads(runtimeInfo *Info, inputChan chan []byte) (*theBoss, error) {
+func mapReads(runtimeInfo *Info, inputChan chan indexedRead) (*theBoss, error) {
 
 	// create a boss to orchestrate the minions and collect stats
 	boss := &theBoss{
@@ -33,96 +71,338 @@ func mapReads(runtimeInfo *Info, inputChan chan []byte) (*theBoss, error) {
 	// launch the graph minions (one minion per graph in the index)
 	var graphWG sync.WaitGroup
 	graphWG.Add(len(boss.info.Store))
-	boss.graphMinionRegister = make([]*graphMinion, len(boss.info.Store))
+	boss.graphMinionRegister = make(map[uint32]*graphMinion, len(boss.info.Store))
 	for graphID, graph := range boss.info.Store {
 
 		// create, start and register the graph minion
-		minion := newGraphMinion(graphID, graph, &graphWG)
+		minion := newGraphMinion(graphID, graph, runtimeInfo.Stranded, &graphWG)
 		minion.start()
 		boss.graphMinionRegister[graphID] = minion
 	}
 
-	// launch the sketching minions (one per CPU)
+	// map the reads, either against all the index shards at once or against each shard in turn
+	var err error
+	if runtimeInfo.db == nil && len(runtimeInfo.shards) > 1 && runtimeInfo.Sketch.SequentialShards {
+		err = boss.mapSequentially()
+	} else {
+		err = boss.mapConcurrently()
+	}
+
+	// close down the graph minions
+	for _, graphMinion := range boss.graphMinionRegister {
+		close(graphMinion.inputChannel)
+	}
+	graphWG.Wait()
+	return boss, err
+}
+
+// mapConcurrently is a method to map the reads against the attached LSH Ensemble, or against all of the index shards at once
+func (boss *theBoss) mapConcurrently() error {
+	dbs := []*graph.ContainmentIndex{boss.info.db}
+	if boss.info.db == nil {
+		dbs = make([]*graph.ContainmentIndex, len(boss.info.shards))
+		for i, shard := range boss.info.shards {
+			if len(boss.info.shards) > 1 {
+				log.Printf("\tloading index shard %d of %d", i+1, len(boss.info.shards))
+			}
+			dbs[i] = &graph.ContainmentIndex{}
+			if err := dbs[i].Load(shard.IndexFile); err != nil {
+				return err
+			}
+
+			// release the shard (and its memory-mapped file) once the reads are mapped
+			defer dbs[i].Close()
+		}
+	}
+
+	// collect the hits for each read, recording the mappings if requested (any error is returned once the reads are mapped, so that the minions aren't left blocked)
+	var err error
+	for hits := range boss.runMinions(boss.reads, dbs) {
+		boss.countRead(hits.numHits, hits.flags)
+		if err == nil {
+			err = boss.info.writeMappings(hits)
+		}
+	}
+	return err
+}
+
+// mapSequentially is a method to map the reads against each index shard in turn, so that only one shard is held in memory
+// the reads are spooled to a tmp file so that they can be replayed against each shard
+func (boss *theBoss) mapSequentially() error {
+	spool, err := ioutil.TempFile("", "groot-reads-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	// hitCounts records the number of hits for each read, across all the shards (saturating at 2, as we only need to know if a read multimapped)
+	// hitFlags records the flags for the windows hit by each read, across all the shards
+	hitCounts := []uint8{}
+	hitFlags := []uint8{}
+	var mappingErr error
+	for shardID, shard := range boss.info.shards {
+		log.Printf("\tmapping reads against index shard %d of %d", shardID+1, len(boss.info.shards))
+		db := &graph.ContainmentIndex{}
+		if err := db.Load(shard.IndexFile); err != nil {
+			return err
+		}
+
+		// the first shard receives the reads from the pipeline and spools them, the rest replay the spool
+		reads := make(chan indexedRead, BUFFERSIZE)
+		spoolErr := make(chan error, 1)
+		if shardID == 0 {
+			go func() {
+				defer close(reads)
+				spoolErr <- spoolReads(spool, boss.reads, reads)
+			}()
+		} else {
+			go func() {
+				defer close(reads)
+				spoolErr <- replayReads(spool, reads)
+			}()
+		}
+		for hits := range boss.runMinions(reads, []*graph.ContainmentIndex{db}) {
+			for len(hitCounts) <= hits.id {
+				hitCounts = append(hitCounts, 0)
+				hitFlags = append(hitFlags, 0)
+			}
+			hitFlags[hits.id] |= hits.flags
+			if total := int(hitCounts[hits.id]) + hits.numHits; total > 2 {
+				hitCounts[hits.id] = 2
+			} else {
+				hitCounts[hits.id] = uint8(total)
+			}
+			if mappingErr == nil {
+				mappingErr = boss.info.writeMappings(hits)
+			}
+		}
+		if err := <-spoolErr; err != nil {
+			return err
+		}
+
+		// release the shard before loading the next one
+		if err := db.Close(); err != nil {
+			return err
+		}
+		runtime.GC()
+		debug.FreeOSMemory()
+	}
+	for readID, numHits := range hitCounts {
+		boss.countRead(int(numHits), hitFlags[readID])
+	}
+	return mappingErr
+}
+
+// runMinions is a method to launch the sketching minions (one per CPU), which query reads against the index shards and send the hits on to the graph minions
+// it returns a channel which receives the number of hits for each read, which is closed once all the reads have been mapped
+func (boss *theBoss) runMinions(reads <-chan indexedRead, dbs []*graph.ContainmentIndex) <-chan readHits {
+	hitsChan := make(chan readHits, BUFFERSIZE)
 	var wg sync.WaitGroup
-	wg.Add(runtimeInfo.NumProc)
-	countChan := make(chan [3]int, runtimeInfo.NumProc)
-	for i := 0; i < runtimeInfo.NumProc; i++ {
+	wg.Add(boss.info.NumProc)
+	for i := 0; i < boss.info.NumProc; i++ {
 		go func(workerNum int) {
 			defer wg.Done()
 
-			// keep a track of what this minion does
-			receivedReads := 0
-			mappedCount := 0
-			multimappedCount := 0
-
-			// start the main processing loop
-			for {
+			// start the main processing loop, pulling reads from queue until done
+			for read := range reads {
 
-				// pull reads from queue until done
-				read, ok := <-boss.reads
-				if !ok {
-
-					// send back the stats from this minion
-					countChan <- [3]int{receivedReads, mappedCount, multimappedCount}
-					return
+				// skip reads that share too few k-mers with the graphs to map
+				if !boss.info.prefilterRead(read.seq, boss.info.Sketch.MinSharedKmers) {
+					hitsChan <- readHits{id: read.id, name: read.name, flags: prefilteredRead}
+					continue
 				}
 
-				// get sketch for read
-				readSketch, err := minhash.GetReadSketch(read, uint(boss.info.KmerSize), uint(boss.info.SketchSize), false)
+				// get sketch for read, using the forward k-mers if the index is stranded
+				var readSketch []uint64
+				var err error
+				if boss.info.Stranded {
+					readSketch, err = minhash.GetStrandedReadSketch(read.seq, uint(boss.info.KmerSize), uint(boss.info.SketchSize), boss.info.DustLevel > 0)
+				} else {
+					readSketch, err = minhash.GetReadSketch(read.seq, uint(boss.info.KmerSize), uint(boss.info.SketchSize), false, boss.info.DustLevel > 0)
+				}
 				misc.ErrorCheck(err)
 
 				// get the number of k-mers in the sequence
-				readLength := len(read)
+				readLength := len(read.seq)
 				kmerCount := float64(readLength-boss.info.KmerSize) + 1
 
 				// query the LSH ensemble
-				hits, err := boss.info.db.Query(readSketch, readLength+boss.info.KmerSize-1, boss.info.ContainmentThreshold)
+				hits, missed, err := boss.info.queryRead(dbs, read.seq, readSketch, readLength+boss.info.KmerSize-1, boss.info.ContainmentThreshold)
 				if err != nil {
 					panic(err)
 				}
+				flags, numHits := uint8(0), 0
+				var mappings []*lshforest.Key
+				if missed {
+					flags |= coarseMiss
+				}
 				for _, hit := range hits {
 
+					// apply the shared window policy, splitting the k-mers of the read between the graphs that share the window or skipping the window
+					if len(hit.SharedWith) != 0 {
+						flags |= sharedWindow
+					}
+					freq, keep := boss.info.Sketch.sharedHitFreq(hit, kmerCount)
+					if !keep {
+						continue
+					}
+					numHits++
+					if hit.RC {
+						flags |= reverseStrand
+					} else {
+						flags |= forwardStrand
+					}
+
 					// make a copy of this graphWindow
 					graphWindow := &lshforest.Key{
 						GraphID:        hit.GraphID,
 						Node:           hit.Node,
 						OffSet:         hit.OffSet,
 						ContainedNodes: hit.ContainedNodes, // don't need to deep copy this as we don't edit it
-						Freq:           kmerCount,          // add the k-mer count of the read in this window
+						Freq:           freq,               // add the k-mer count of the read in this window
+						RC:             hit.RC,
+						SharedWith:     hit.SharedWith,
 					}
 
-					// send the window on for graph augmentation
+					// keep the mapping for the report, then send the window on for graph augmentation
+					if boss.info.mappings != nil {
+						mappings = append(mappings, graphWindow)
+					}
 					boss.graphMinionRegister[hit.GraphID].inputChannel <- graphWindow
 
 				}
-
-				// update counts
-				receivedReads++
-				if len(hits) > 0 {
-					mappedCount++
-				}
-				if len(hits) > 1 {
-					multimappedCount++
-				}
+				hitsChan <- readHits{id: read.id, name: read.name, numHits: numHits, flags: flags, mappings: mappings}
 			}
 		}(i)
 	}
+	go func() {
+		wg.Wait()
+		close(hitsChan)
+	}()
+	return hitsChan
+}
 
-	// wait for the sketching minions
+// countRead is a method to update the read counts with the number of hits for a read, and the flags for the windows it hit
+func (boss *theBoss) countRead(numHits int, flags uint8) {
+	boss.receivedReadCount++
+	if numHits > 0 {
+		boss.mappedCount++
+	}
+	if numHits > 1 {
+		boss.multimappedCount++
+	}
+	if flags&sharedWindow != 0 {
+		boss.sharedCount++
+	}
+	if flags&prefilteredRead != 0 {
+		boss.prefilteredCount++
+	}
+	if flags&coarseMiss != 0 {
+		boss.coarseMissCount++
+	}
+	switch flags &^ (sharedWindow | prefilteredRead | coarseMiss) {
+	case forwardStrand:
+		boss.strandCounts[0]++
+	case reverseStrand:
+		boss.strandCounts[1]++
+	case forwardStrand | reverseStrand:
+		boss.strandCounts[2]++
+	}
+}
+
+// queryShards is a function to query a read sketch against several LSH Ensemble indexes concurrently and merge the hits
+func queryShards(dbs []*graph.ContainmentIndex, readSketch []uint64, querySize int, containmentThreshold float64) ([]*lshforest.Key, error) {
+	if len(dbs) == 1 {
+		return dbs[0].Query(readSketch, querySize, containmentThreshold)
+	}
+	shardHits := make([][]*lshforest.Key, len(dbs))
+	shardErrs := make([]error, len(dbs))
+	var wg sync.WaitGroup
+	wg.Add(len(dbs))
+	for i, db := range dbs {
+		go func(i int, db *graph.ContainmentIndex) {
+			defer wg.Done()
+			shardHits[i], shardErrs[i] = db.Query(readSketch, querySize, containmentThreshold)
+		}(i, db)
+	}
 	wg.Wait()
-	close(countChan)
+	hits := []*lshforest.Key{}
+	for i := range dbs {
+		if shardErrs[i] != nil {
+			return nil, shardErrs[i]
+		}
+		hits = append(hits, shardHits[i]...)
+	}
+	return hits, nil
+}
 
-	// get the counts
-	for count := range countChan {
-		boss.receivedReadCount += count[0]
-		boss.mappedCount += count[1]
-		boss.multimappedCount += count[2]
+// spoolReads is a function to write the reads from the pipeline to a spool file as they are sent on
+// the reads are numbered in the order they are received, so they are numbered in the same way when the spool is replayed
+func spoolReads(spool *os.File, input <-chan indexedRead, output chan<- indexedRead) error {
+	writer := bufio.NewWriter(spool)
+	var err error
+	for read := range input {
+
+		// keep draining the input after an error, so that the pipeline doesn't block
+		if err == nil {
+			if err = writeSpoolChunk(writer, read.name); err == nil {
+				err = writeSpoolChunk(writer, read.seq)
+			}
+		}
+		output <- read
 	}
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
 
-	// close down the graph minions
-	for _, graphMinion := range boss.graphMinionRegister {
-		close(graphMinion.inputChannel)
+// writeSpoolChunk is a function to write a length prefixed chunk to the read spool
+func writeSpoolChunk(writer *bufio.Writer, chunk []byte) error {
+	lengthBuffer := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lengthBuffer, uint64(len(chunk)))
+	if _, err := writer.Write(lengthBuffer[:n]); err != nil {
+		return err
 	}
-	graphWG.Wait()
-	return boss, nil
+	_, err := writer.Write(chunk)
+	return err
+}
+
+// replayReads is a function to read the spooled reads back from the start of the spool file
+func replayReads(spool *os.File, output chan<- indexedRead) error {
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(spool)
+	for readID := 0; ; readID++ {
+		name, err := readSpoolChunk(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		read, err := readSpoolChunk(reader)
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		output <- indexedRead{id: readID, name: name, seq: read}
+	}
+}
+
+// readSpoolChunk is a function to read a length prefixed chunk from the read spool, returning io.EOF if the spool is finished
+func readSpoolChunk(reader *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	chunk := make([]byte, length)
+	if _, err := io.ReadFull(reader, chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
 }
@@ -0,0 +1,60 @@
+package pipeline
+
+/*
+ this part of the pipeline queries reads in two stages, using a coarse index of the k-mers sampled from each graph to choose the graphs, and then only querying the windows from those graphs
+*/
+
+import (
+	"fmt"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/lshforest"
+)
+
+// DefaultCoarseThreshold is the default proportion of the sampled k-mers from a read that a graph must hold to be chosen by the coarse index
+const DefaultCoarseThreshold = 0.5
+
+// HasCoarseIndex is a method to check if the index has coarse sketches, which are needed for two-stage querying
+func (Info *Info) HasCoarseIndex() bool {
+	return Info.CoarseScale > 0 && Info.coarseSketches != nil
+}
+
+// UseCoarseIndex is a method to build the coarse index from the coarse sketches, so that reads are queried in two stages
+func (Info *Info) UseCoarseIndex() error {
+	if !Info.HasCoarseIndex() {
+		return fmt.Errorf("index was built without a coarse index (use --coarseScale)")
+	}
+	Info.coarse = graph.NewCoarseIndex(Info.coarseSketches, Info.KmerSize, Info.CoarseScale)
+	return nil
+}
+
+// queryRead is a method to query a read against the index shards, first choosing the graphs with the coarse index if one is in use
+// the bool is true if the coarse index didn't choose any graphs, so that the windows were not queried
+// reads that don't have any k-mers sampled by the coarse index are queried against every window
+func (Info *Info) queryRead(dbs []*graph.ContainmentIndex, read []byte, readSketch []uint64, querySize int, containmentThreshold float64) ([]*lshforest.Key, bool, error) {
+	if Info.coarse == nil {
+		hits, err := queryShards(dbs, readSketch, querySize, containmentThreshold)
+		return hits, false, err
+	}
+	coarseThreshold := Info.Sketch.CoarseThreshold
+	if coarseThreshold <= 0 {
+		coarseThreshold = DefaultCoarseThreshold
+	}
+	graphIDs, sampled := Info.coarse.Query(read, coarseThreshold)
+	if !sampled {
+		hits, err := queryShards(dbs, readSketch, querySize, containmentThreshold)
+		return hits, false, err
+	}
+	if len(graphIDs) == 0 {
+		return nil, true, nil
+	}
+	hits := []*lshforest.Key{}
+	for _, db := range dbs {
+		dbHits, err := db.QueryGraphs(readSketch, querySize, containmentThreshold, graphIDs)
+		if err != nil {
+			return nil, false, err
+		}
+		hits = append(hits, dbHits...)
+	}
+	return hits, false, nil
+}
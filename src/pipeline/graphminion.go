@@ -13,15 +13,17 @@ type graphMinion struct {
 	id           uint32
 	graph        *graph.GrootGraph
 	inputChannel chan *lshforest.Key
+	stranded     bool // record the strand of the mapped windows
 	wg           *sync.WaitGroup
 }
 
 // newGraphMinion is the constructor function
-func newGraphMinion(id uint32, graph *graph.GrootGraph, wg *sync.WaitGroup) *graphMinion {
+func newGraphMinion(id uint32, graph *graph.GrootGraph, stranded bool, wg *sync.WaitGroup) *graphMinion {
 	return &graphMinion{
 		id:           id,
 		graph:        graph,
 		inputChannel: make(chan *lshforest.Key, BUFFERSIZE),
+		stranded:     stranded,
 		wg:           wg,
 	}
 }
@@ -43,6 +45,12 @@ func (graphMinion *graphMinion) start() {
 
 			// increment the nodes contained in the mapping window
 			misc.ErrorCheck(graphMinion.graph.IncrementSubPath(mappingData.ContainedNodes, mappingData.Freq))
+			if graphMinion.stranded {
+				graphMinion.graph.IncrementStrandCount(mappingData.RC, uint64(mappingData.Freq))
+			}
+			if len(mappingData.SharedWith) != 0 {
+				graphMinion.graph.SharedKmers += uint64(mappingData.Freq)
+			}
 		}
 	}()
 }
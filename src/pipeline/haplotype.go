@@ -6,8 +6,10 @@ package pipeline
 
 import (
 	"log"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/will-rowe/baby-groot/src/graph"
@@ -36,6 +38,9 @@ func (proc *GFAreader) Connect(input []string) {
 // Run is the method to run this process, which satisfies the pipeline interface
 func (proc *GFAreader) Run() {
 	var wg sync.WaitGroup
+	if proc.info.Sources == nil {
+		proc.info.Sources = make(map[uint32]string)
+	}
 	for i, gfaFile := range proc.input {
 		gfaObj, err := graph.LoadGFA(gfaFile)
 		misc.ErrorCheck(err)
@@ -50,6 +55,10 @@ func (proc *GFAreader) Run() {
 			proc.info.Haplotype.TotalKmers = kmerCount
 		}
 
+		// name the graph using the GFA filename (groot-graph-<name>.gfa), which also gives the graph its stable graphID
+		graphID, _, err := proc.info.GetGraphID(strings.TrimPrefix(strings.TrimSuffix(filepath.Base(gfaFile), ".gfa"), "groot-graph-"))
+		misc.ErrorCheck(err)
+
 		// convert GFAs to GrootGraph and send them on to the path finder
 		wg.Add(1)
 		go func(gfaID int, g *gfa.GFA) {
@@ -59,7 +68,7 @@ func (proc *GFAreader) Run() {
 				log.Fatal(err)
 			}
 			proc.output <- grootGraph
-		}(i, gfaObj)
+		}(int(graphID), gfaObj)
 	}
 	wg.Wait()
 	close(proc.output)
@@ -160,7 +169,7 @@ func (proc *HaplotypeParser) Run() {
 
 		// print some stuff
 		paths, abundances := g.GetEMpaths()
-		log.Printf("\tgraph %d has %d called alleles after EM", g.GraphID, len(paths))
+		log.Printf("\tgraph %v has %d called alleles after EM", proc.info.GraphName(g.GraphID), len(paths))
 		for i, path := range paths {
 			log.Printf("\t- [%v (abundance: %.3f)]", path, abundances[i])
 			keptPaths = append(keptPaths, path)
@@ -225,7 +234,7 @@ func (proc *MCMCpathFinder) Run() {
 				paths, err := g.GetMarkovPaths()
 				misc.ErrorCheck(err)
 
-				log.Printf("\tgraph %d has %d markov paths passing thresholds", g.GraphID, len(paths))
+				log.Printf("\tgraph %v has %d markov paths passing thresholds", proc.info.GraphName(g.GraphID), len(paths))
 				for _, path := range paths {
 					log.Printf("\t- [%v]", path)
 				}
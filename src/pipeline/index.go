@@ -5,15 +5,21 @@ package pipeline
 */
 
 import (
+	"bytes"
+	"crypto/md5"
 	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/biogo/biogo/seq/multi"
 	"github.com/ekzhu/lshensemble"
+	"github.com/will-rowe/baby-groot/src/cluster"
 	"github.com/will-rowe/baby-groot/src/graph"
 	"github.com/will-rowe/baby-groot/src/lshforest"
 	"github.com/will-rowe/baby-groot/src/misc"
+	"github.com/will-rowe/baby-groot/src/seqio"
 	"github.com/will-rowe/gfa"
 )
 
@@ -21,6 +27,7 @@ import (
 type MSAconverter struct {
 	info   *Info
 	input  []string
+	names  map[string]string
 	output chan *graph.GrootGraph
 }
 
@@ -34,15 +41,27 @@ func (proc *MSAconverter) Connect(input []string) {
 	proc.input = input
 }
 
+// SetNames is the method to set the names used for the graphs, using a map of input file to name (files without a name use their basename)
+func (proc *MSAconverter) SetNames(names map[string]string) {
+	proc.names = names
+}
+
 // Run is the method to run this process, which satisfies the pipeline interface
 func (proc *MSAconverter) Run() {
 	var wg sync.WaitGroup
 	wg.Add(len(proc.input))
 
 	// load each MSA outside of the go-routines to prevent 'too many open files' error on OSX
-	for i, msaFile := range proc.input {
-		msa, err := gfa.ReadMSA(msaFile)
+	for _, msaFile := range proc.input {
+		msa, err := graph.ReadMSA(msaFile)
+		misc.ErrorCheck(err)
+
+		// get the graphID for this MSA (existing graphIDs are kept if the MSA has been indexed before)
+		graphID, _, err := proc.info.GetGraphID(getName(proc.names, msaFile))
 		misc.ErrorCheck(err)
+		md5sum, err := misc.GetMD5(msaFile)
+		misc.ErrorCheck(err)
+		proc.info.RecordInput(graphID, msaFile, md5sum)
 		go func(msaID int, msa *multi.Multi) {
 			// convert the MSA to a GFA instance
 			newGFA, err := gfa.MSA2GFA(msa)
@@ -54,13 +73,202 @@ func (proc *MSAconverter) Run() {
 			}
 			proc.output <- grootGraph
 			wg.Done()
-		}(i, msa)
+		}(int(graphID), msa)
 	}
 	wg.Wait()
 	close(proc.output)
 }
 
+// GFAconverter is a pipeline process that converts a list of GFAs to GrootGraphs
+type GFAconverter struct {
+	info   *Info
+	input  []string
+	names  map[string]string
+	output chan *graph.GrootGraph
+}
+
+// NewGFAconverter is the constructor
+func NewGFAconverter(info *Info) *GFAconverter {
+	return &GFAconverter{info: info, output: make(chan *graph.GrootGraph, BUFFERSIZE)}
+}
+
+// Connect is the method to connect the GFAconverter to some data source
+func (proc *GFAconverter) Connect(input []string) {
+	proc.input = input
+}
+
+// SetNames is the method to set the names used for the graphs, using a map of input file to name (files without a name use their basename)
+func (proc *GFAconverter) SetNames(names map[string]string) {
+	proc.names = names
+}
+
+// Run is the method to run this process, which satisfies the pipeline interface
+// GFAs that can't be used as GrootGraphs are reported and skipped, rather than stopping the pipeline
+// the exception is a GFA that replaces a graph in an existing index, which stops the pipeline before the index is written so that the existing graph isn't lost
+func (proc *GFAconverter) Run() {
+	defer close(proc.output)
+	rejected := 0
+	for _, gfaFile := range proc.input {
+		graphID, replace, err := proc.info.GetGraphID(getName(proc.names, gfaFile))
+		misc.ErrorCheck(err)
+		grootGraph, err := proc.convert(gfaFile, graphID)
+		if err != nil {
+			if replace {
+				misc.ErrorCheck(fmt.Errorf("could not use %v to replace graph %v, so the existing index has been left unchanged: %v", gfaFile, proc.info.GraphName(graphID), err))
+			}
+			proc.info.removeSource(graphID)
+			log.Printf("\trejected graph: %v (%v)", gfaFile, err)
+			rejected++
+			continue
+		}
+		proc.output <- grootGraph
+	}
+	log.Printf("\tnumber of GFA files rejected: %d", rejected)
+}
+
+// convert is a method to load a single GFA, check it and convert it to a GrootGraph, recording the GFA as the input for the graph
+func (proc *GFAconverter) convert(gfaFile string, graphID uint32) (*graph.GrootGraph, error) {
+	gfaObj, err := graph.LoadGFA(gfaFile)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := gfaObj.GetPaths()
+	if err != nil || len(paths) == 0 {
+		return nil, fmt.Errorf("graph has no paths (P lines)")
+	}
+
+	// CreateGrootGraph will also check that the graph can be topologically sorted
+	grootGraph, err := graph.CreateGrootGraph(gfaObj, int(graphID))
+	if err != nil {
+		return nil, err
+	}
+	md5sum, err := misc.GetMD5(gfaFile)
+	if err != nil {
+		return nil, err
+	}
+	proc.info.RecordInput(graphID, gfaFile, md5sum)
+	smallestWindow := proc.info.GetWindowSizes()[0]
+	for pathID, length := range grootGraph.Lengths {
+		if length < smallestWindow {
+			log.Printf("\twarning: path shorter than window size will not be indexed: %v (%v)", string(grootGraph.Paths[pathID]), gfaFile)
+		}
+	}
+	return grootGraph, nil
+}
+
+// ClusterBuilder is a pipeline process that clusters the sequences in a FASTA file and builds a partial order alignment graph for each cluster
+type ClusterBuilder struct {
+	info   *Info
+	input  string
+	output chan *graph.GrootGraph
+}
+
+// NewClusterBuilder is the constructor
+func NewClusterBuilder(info *Info) *ClusterBuilder {
+	return &ClusterBuilder{info: info, output: make(chan *graph.GrootGraph, BUFFERSIZE)}
+}
+
+// Connect is the method to connect the ClusterBuilder to some data source
+func (proc *ClusterBuilder) Connect(input string) {
+	proc.input = input
+}
+
+// Run is the method to run this process, which satisfies the pipeline interface
+func (proc *ClusterBuilder) Run() {
+	defer close(proc.output)
+
+	// load the sequences
+	seqs, err := seqio.ReadFASTA(proc.input)
+	misc.ErrorCheck(err)
+	if len(seqs) == 0 {
+		misc.ErrorCheck(fmt.Errorf("no sequences found in the FASTA file: %v", proc.input))
+	}
+	log.Printf("\tnumber of sequences loaded: %d", len(seqs))
+	md5sum, err := misc.GetMD5(proc.input)
+	misc.ErrorCheck(err)
+
+	// cluster the sequences
+	clusterer, err := cluster.NewClusterer(proc.info.BuildDB.ClusterKmerSize, proc.info.BuildDB.ClusterSketchSize, proc.info.BuildDB.Identity, proc.info.BuildDB.MaxCandidates)
+	misc.ErrorCheck(err)
+	clusters, err := clusterer.Run(seqs)
+	misc.ErrorCheck(err)
+	names, clusters := nameClusters(seqs, clusters)
+	log.Printf("\tnumber of clusters at %.2f identity: %d", proc.info.BuildDB.Identity, len(clusters))
+
+	// build a graph for each cluster, with a pool of NumProc workers as each partial order alignment holds its dynamic programming matrices until it is done
+	numWorkers := proc.info.NumProc
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	type poaJob struct {
+		graphID     uint32
+		clusterSeqs []*seqio.Sequence
+	}
+	jobs := make(chan poaJob)
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				grootGraph, err := graph.CreatePOAgraph(job.clusterSeqs, job.graphID)
+				misc.ErrorCheck(err)
+				proc.output <- grootGraph
+			}
+		}()
+	}
+	for i, members := range clusters {
+		clusterSeqs := make([]*seqio.Sequence, len(members))
+		for j, seqIdx := range members {
+			clusterSeqs[j] = seqs[seqIdx]
+		}
+		graphID, replace, err := proc.info.GetGraphID(names[i])
+		misc.ErrorCheck(err)
+		if replace {
+			misc.ErrorCheck(fmt.Errorf("cluster name is already used by another graph: %v", names[i]))
+		}
+		proc.info.RecordInput(graphID, proc.input, md5sum)
+		jobs <- poaJob{graphID, clusterSeqs}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// nameClusters returns the name of each cluster, merging clusters whose representatives are identical as they would get the same name
+// identical sequences are usually clustered together, but sequences too short to be sketched always start their own cluster
+func nameClusters(seqs []*seqio.Sequence, clusters [][]int) ([]string, [][]int) {
+	names := []string{}
+	merged := [][]int{}
+	seen := make(map[string]int)
+	for _, members := range clusters {
+		name := clusterName(seqs[members[0]])
+		if i, ok := seen[name]; ok {
+			merged[i] = append(merged[i], members...)
+			continue
+		}
+		seen[name] = len(names)
+		names = append(names, name)
+		merged = append(merged, members)
+	}
+	return names, merged
+}
+
+// clusterName returns the name for a cluster, using the md5 digest of its representative sequence so that the name doesn't depend on the order of the clusters
+// the full digest is kept, as the graphID of the cluster is derived from its name
+func clusterName(representative *seqio.Sequence) string {
+	return fmt.Sprintf("cluster-%x", md5.Sum(bytes.ToUpper(representative.Seq)))
+}
+
+// getName returns the graph name for an input file, defaulting to the file basename without the extension
+func getName(names map[string]string, inputFile string) string {
+	if name, ok := names[inputFile]; ok {
+		return name
+	}
+	return strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+}
+
 // GraphSketcher is a pipeline process that windows graph traversals and sketches them
+// the windowing jobs run on a pool of NumProc workers, but each window is kept (by the window merger of its graph, and then by the SketchIndexer) until the index is built
 type GraphSketcher struct {
 	info   *Info
 	input  chan *graph.GrootGraph
@@ -77,46 +285,155 @@ func (proc *GraphSketcher) Connect(previous *MSAconverter) {
 	proc.input = previous.output
 }
 
+// ConnectGFA is the method to connect the GraphSketcher to the output of a GFAconverter
+func (proc *GraphSketcher) ConnectGFA(previous *GFAconverter) {
+	proc.input = previous.output
+}
+
+// ConnectClusterBuilder is the method to connect the GraphSketcher to the output of a ClusterBuilder
+func (proc *GraphSketcher) ConnectClusterBuilder(previous *ClusterBuilder) {
+	proc.input = previous.output
+}
+
 // Run is the method to run this process, which satisfies the pipeline interface
 func (proc *GraphSketcher) Run() {
 	defer close(proc.output)
 
 	// after sketching all the received graphs, add the graphs to a store and save it
-	graphChan := make(chan *graph.GrootGraph)
-	graphStore := make(graph.Store)
+	// if the runtime info already holds graphs (i.e. an index is being updated), the sketched graphs are added to the existing store
+	// the path regions left out of each graph as background are sent along with the graph, so that they can be recorded in the runtime info
+	type sketched struct {
+		grootGraph *graph.GrootGraph
+		background []graph.BackgroundRegion
+	}
+	graphChan := make(chan sketched)
+	graphStore := proc.info.Store
+	if graphStore == nil {
+		graphStore = make(graph.Store)
+	}
+	receivedGraphs := 0
 
-	// receive the graphs to be sketched
-	var wg sync.WaitGroup
-	for newGraph := range proc.input {
-		wg.Add(1)
-		go func(grootGraph *graph.GrootGraph) {
+	// window the graphs with a single pool of NumProc workers, which runs the jobs (paths and traversals) of the graphs as they are received
+	numWorkers := proc.info.NumProc
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	jobs := make(chan func())
+	var workerWG sync.WaitGroup
+	workerWG.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer workerWG.Done()
+			for job := range jobs {
+				job()
+			}
+		}()
+	}
 
-			// create sketch for each window in the graph
-			for window := range grootGraph.WindowGraph(proc.info.WindowSize, proc.info.KmerSize, proc.info.SketchSize) {
+	// queue the jobs for each graph, for each window set in the index
+	// windows with identical sketches are combined for each graph, and each window is sent on as soon as it is made (unless it was combined with an earlier window)
+	var graphWG sync.WaitGroup
+	go func() {
+		for grootGraph := range proc.input {
+			grootGraph := grootGraph
+			merger := graph.NewWindowMerger()
+			sendWindow := func(window *lshforest.Key) {
+				if merger.Add(window) {
+					proc.output <- window
+				}
+			}
+			graphJobs := []func(){}
+			var background []graph.BackgroundRegion
+			var backgroundOpts []*graph.WindowOptions
+			for _, windowSize := range proc.info.GetWindowSizes() {
+				windowOpts := &graph.WindowOptions{
+					WindowSize:    windowSize,
+					KmerSize:      proc.info.KmerSize,
+					SketchSize:    proc.info.SketchSize,
+					Stranded:      proc.info.Stranded,
+					DustLevel:     proc.info.DustLevel,
+					MaxTraversals: proc.info.MaxTraversals,
+					Stride:        proc.info.GetWindowStride(),
+					Minimizers:    proc.info.MinimizerWindows,
+				}
 
-				// send the windows for this graph onto the next process
-				proc.output <- window
+				// leave out the windows dominated by background k-mers, if a background was given
+				if proc.info.background != nil {
+					windowOpts.Background = proc.info.background
+					windowOpts.MaxBackground = proc.info.Background.MaxBackground
+					backgroundOpts = append(backgroundOpts, windowOpts)
+				}
+				windowJobs, err := grootGraph.WindowJobs(windowOpts, sendWindow)
+				misc.ErrorCheck(err)
+				graphJobs = append(graphJobs, windowJobs...)
 			}
-			// this graph is sketched, now send it on to be saved in the current process
-			graphChan <- grootGraph
-			wg.Done()
-		}(newGraph)
-	}
+
+			// the background regions are found by a job of their own, which reads the path sequences itself and so can run alongside the window jobs of the graph
+			if len(backgroundOpts) != 0 {
+				graphJobs = append(graphJobs, func() {
+					for _, windowOpts := range backgroundOpts {
+						regions, err := grootGraph.BackgroundRegions(windowOpts)
+						misc.ErrorCheck(err)
+						background = append(background, regions...)
+					}
+				})
+			}
+
+			// once every job for this graph is done, send the graph on to be saved in the current process
+			var jobWG sync.WaitGroup
+			jobWG.Add(len(graphJobs))
+			graphWG.Add(1)
+			go func() {
+				defer graphWG.Done()
+				jobWG.Wait()
+				graphChan <- sketched{grootGraph, background}
+			}()
+			for _, job := range graphJobs {
+				job := job
+				jobs <- func() {
+					defer jobWG.Done()
+					job()
+				}
+			}
+		}
+		close(jobs)
+	}()
 	go func() {
-		wg.Wait()
+		workerWG.Wait()
+		graphWG.Wait()
 		close(graphChan)
 	}()
 
 	// collect the graphs
+	numBackground, backgroundWindows := 0, 0
 	for sketchedGraph := range graphChan {
-		graphStore[sketchedGraph.GraphID] = sketchedGraph
+		graphID := sketchedGraph.grootGraph.GraphID
+		graphStore[graphID] = sketchedGraph.grootGraph
+		receivedGraphs++
+
+		// record the background regions, replacing those of any graph being updated
+		if len(sketchedGraph.background) == 0 {
+			delete(proc.info.BackgroundRegions, graphID)
+			continue
+		}
+		if proc.info.BackgroundRegions == nil {
+			proc.info.BackgroundRegions = make(map[uint32][]graph.BackgroundRegion)
+		}
+		proc.info.BackgroundRegions[graphID] = sketchedGraph.background
+		numBackground++
+		for _, region := range sketchedGraph.background {
+			backgroundWindows += region.Windows
+		}
 	}
 
 	// check some graphs have been sketched
-	if len(graphStore) == 0 {
+	if receivedGraphs == 0 {
 		misc.ErrorCheck(fmt.Errorf("could not create any graphs"))
 	}
-	log.Printf("\tnumber of groot graphs built: %d", len(graphStore))
+	log.Printf("\tnumber of groot graphs built: %d", receivedGraphs)
+	if proc.info.background != nil {
+		log.Printf("\tnumber of graphs with windows left out as background: %d (%d path windows)", numBackground, backgroundWindows)
+	}
 
 	// add the graphs to the pipeline info
 	proc.info.Store = graphStore
@@ -141,27 +458,64 @@ func (proc *SketchIndexer) Connect(previous *GraphSketcher) {
 // Run is the method to run this process, which satisfies the pipeline interface
 func (proc *SketchIndexer) Run() {
 
-	// create a tmp map of domain records and the key lookup
+	// create a tmp map of domain records and the windows, where each window is given the next window ID
 	domainRecMap := make(map[int]*lshensemble.DomainRecord)
-	keyLookup := make(map[string]*lshforest.Key) // links sketches to the graph windows
+	windows := []*lshforest.Key{}
 
-	// collect the sketches and store as domains
+	// if an existing index is attached to the runtime, start with its domain records and windows
 	sketchCount := 0
+	if existingIndex := proc.info.db; existingIndex != nil {
+		for _, rec := range existingIndex.DomainRecords {
+			domainRecMap[sketchCount] = rec
+			sketchCount++
+		}
+		windows = append(windows, existingIndex.Windows...)
+		log.Printf("\tnumber of sketches retained from the existing index: %d\n", sketchCount)
+	}
+
+	// collect the windows, which have already been combined for each graph by the GraphSketcher
+	// every window is needed to build the LSH Ensemble and is sorted to give it a stable window ID, so peak memory grows with the number of windows in the index
+	newWindows := []*lshforest.Key{}
 	for window := range proc.input {
+		newWindows = append(newWindows, window)
+	}
+	graph.SortWindows(newWindows)
+
+	// store the sketches as domains
+	// distinct windows on the paths can share a start and number of paths (which collided when windows were keyed by these), so these are counted
+	type windowStart struct {
+		graphID, offset, numRefs, windowSize uint32
+		node                                 uint64
+		rc                                   bool
+	}
+	starts := make(map[windowStart]struct{}, len(newWindows))
+	collisions := 0
+	for _, window := range newWindows {
+		if len(window.Ref) != 0 {
+			start := windowStart{window.GraphID, window.OffSet, uint32(len(window.Ref)), window.WindowSize, window.Node, window.RC}
+			if _, ok := starts[start]; ok {
+				collisions++
+			}
+			starts[start] = struct{}{}
+		}
 
-		// create a domain record for this sketch
-		key := fmt.Sprintf("g%dn%do%dp%d", window.GraphID, window.Node, window.OffSet, len(window.Ref))
+		// create a domain record for this sketch, keyed by the window ID
 		domainRecMap[sketchCount] = &lshensemble.DomainRecord{
-			Key:       key,
-			Size:      proc.info.WindowSize + proc.info.KmerSize - 1,
+			Key:       uint32(len(windows)),
+			Size:      int(window.WindowSize) + proc.info.KmerSize - 1,
 			Signature: window.Sketch,
 		}
-		keyLookup[key] = window
+		windows = append(windows, window)
 		sketchCount++
 	}
+	if collisions != 0 {
+		log.Printf("\tnumber of windows sharing a start and path count with another window (kept apart by window IDs): %d\n", collisions)
+	}
 
 	// store the domain records
-	index := graph.PrepareIndex(domainRecMap, keyLookup, proc.info.NumPart, proc.info.MaxK, proc.info.WindowSize+proc.info.KmerSize-1, proc.info.SketchSize)
+	index := graph.PrepareIndex(domainRecMap, windows, proc.info.NumPart, proc.info.MaxK, proc.info.WindowSize+proc.info.KmerSize-1, proc.info.SketchSize)
+	index.KmerSize = proc.info.KmerSize
+	index.WindowSizes = proc.info.WindowSizes
 	proc.info.AttachDB(index)
 	log.Printf("\tnumber of sketches added to the LSH Ensemble index: %d\n", sketchCount)
 }
@@ -0,0 +1,215 @@
+package pipeline
+
+/*
+ this part of the pipeline records the provenance of an index, so that allele calls can be traced back to the input files and database release
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/will-rowe/baby-groot/src/misc"
+)
+
+// ManifestFile is the name of the provenance file written alongside the index files
+const ManifestFile = "manifest.json"
+
+// ReleaseFile is the name of the file written by groot get to describe a downloaded database
+const ReleaseFile = "groot-db.json"
+
+// DatabaseRelease describes a database downloaded by groot get
+type DatabaseRelease struct {
+	Name       string    `json:"name"`
+	Identity   string    `json:"identity"`
+	URL        string    `json:"url"`
+	MD5        string    `json:"md5"`
+	Downloaded time.Time `json:"downloaded"`
+}
+
+// InputFile records the file used to build a graph
+type InputFile struct {
+	Path string
+	MD5  string
+}
+
+// Manifest is the provenance record for an index
+type Manifest struct {
+	Created    time.Time          `json:"created"`
+	Version    string             `json:"version"`
+	Parameters ManifestParameters `json:"parameters"`
+	Database   *DatabaseRelease   `json:"database,omitempty"`
+	Background *BackgroundSet     `json:"background,omitempty"`
+	Graphs     []ManifestGraph    `json:"graphs"`
+}
+
+// ManifestParameters records the parameters used to build an index
+type ManifestParameters struct {
+	KmerSize          int     `json:"kmerSize"`
+	SketchSize        int     `json:"sketchSize"`
+	WindowSize        int     `json:"windowSize"`
+	WindowSizes       []int   `json:"windowSizes,omitempty"`
+	Stranded          bool    `json:"stranded,omitempty"`
+	SharedSimilarity  float64 `json:"sharedSimilarity,omitempty"`
+	DustLevel         int     `json:"dustLevel,omitempty"`
+	MaxTraversals     int     `json:"maxTraversals,omitempty"`
+	WindowStride      int     `json:"windowStride,omitempty"`
+	MinimizerWindows  bool    `json:"minimizerWindows,omitempty"`
+	CoarseScale       int     `json:"coarseScale,omitempty"`
+	NumPart           int     `json:"numPart"`
+	MaxK              int     `json:"maxK"`
+	Shards            int     `json:"shards,omitempty"`
+	IndexDir          string  `json:"indexDir"`
+	Identity          float64 `json:"clusterIdentity,omitempty"`
+	ClusterKmerSize   int     `json:"clusterKmerSize,omitempty"`
+	ClusterSketchSize int     `json:"clusterSketchSize,omitempty"`
+	MaxCandidates     int     `json:"maxCandidates,omitempty"`
+}
+
+// ManifestGraph records the source of a graph and the sequences it holds
+type ManifestGraph struct {
+	GraphID uint32   `json:"graphID"`
+	Name    string   `json:"name"`
+	Source  string   `json:"source,omitempty"`
+	MD5     string   `json:"md5,omitempty"`
+	Paths   []string `json:"paths"`
+
+	// the path regions whose windows were left out of the index as background
+	BackgroundRegions []ManifestBackgroundRegion `json:"backgroundRegions,omitempty"`
+}
+
+// ManifestBackgroundRegion records a region of a graph path whose windows were left out of the index as background
+type ManifestBackgroundRegion struct {
+	Path       string `json:"path"`
+	WindowSize int    `json:"windowSize"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	Windows    int    `json:"windows"`
+}
+
+// Save is a method to write the database release info to disk
+func (DatabaseRelease *DatabaseRelease) Save(fileName string) error {
+	data, err := json.MarshalIndent(DatabaseRelease, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+// Load is a method to read the database release info from disk
+func (DatabaseRelease *DatabaseRelease) Load(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, DatabaseRelease)
+}
+
+// RecordInput is a method to record the input file (and its md5sum) used to build a graph
+func (Info *Info) RecordInput(graphID uint32, inputFile, md5sum string) {
+	if Info.Inputs == nil {
+		Info.Inputs = make(map[uint32]InputFile)
+	}
+	if absPath, err := filepath.Abs(inputFile); err == nil {
+		inputFile = absPath
+	}
+	Info.Inputs[graphID] = InputFile{Path: inputFile, MD5: md5sum}
+}
+
+// CheckInputs is a method to find which input files need to be indexed when an index is updated, using the md5sums recorded for the graphs already in the index
+// the files for new graphs and the files that have changed since they were indexed are returned, along with the graphIDs of the graphs that they replace
+// files that are unchanged since they were indexed are left out, so that their graphs are kept as they are
+func (Info *Info) CheckInputs(inputFiles []string, names map[string]string) ([]string, map[uint32]struct{}, error) {
+	changed := []string{}
+	replaced := make(map[uint32]struct{})
+	for _, inputFile := range inputFiles {
+		graphID, replace, err := Info.GetGraphID(getName(names, inputFile))
+		if err != nil {
+			return nil, nil, err
+		}
+		if replace {
+			md5sum, err := misc.GetMD5(inputFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			if input, ok := Info.Inputs[graphID]; ok && input.MD5 == md5sum {
+				continue
+			}
+			replaced[graphID] = struct{}{}
+		}
+		changed = append(changed, inputFile)
+	}
+	return changed, replaced, nil
+}
+
+// GetManifest is a method to collect the provenance information for the graphs in the Store
+func (Info *Info) GetManifest() *Manifest {
+	manifest := &Manifest{
+		Created: time.Now(),
+		Version: Info.Version,
+		Parameters: ManifestParameters{
+			KmerSize:          Info.KmerSize,
+			SketchSize:        Info.SketchSize,
+			WindowSize:        Info.WindowSize,
+			WindowSizes:       Info.WindowSizes,
+			Stranded:          Info.Stranded,
+			SharedSimilarity:  Info.SharedSimilarity,
+			DustLevel:         Info.DustLevel,
+			MaxTraversals:     Info.MaxTraversals,
+			WindowStride:      Info.WindowStride,
+			MinimizerWindows:  Info.MinimizerWindows,
+			CoarseScale:       Info.CoarseScale,
+			NumPart:           Info.NumPart,
+			MaxK:              Info.MaxK,
+			Shards:            Info.NumShards,
+			IndexDir:          Info.IndexDir,
+			Identity:          Info.BuildDB.Identity,
+			ClusterKmerSize:   Info.BuildDB.ClusterKmerSize,
+			ClusterSketchSize: Info.BuildDB.ClusterSketchSize,
+			MaxCandidates:     Info.BuildDB.MaxCandidates,
+		},
+		Database:   Info.Database,
+		Background: Info.Background,
+		Graphs:     make([]ManifestGraph, 0, len(Info.Store)),
+	}
+	for graphID, g := range Info.Store {
+		graphRecord := ManifestGraph{
+			GraphID: graphID,
+			Name:    Info.GraphName(graphID),
+			Source:  Info.Inputs[graphID].Path,
+			MD5:     Info.Inputs[graphID].MD5,
+			Paths:   make([]string, 0, len(g.Paths)),
+		}
+		pathIDs := make([]int, 0, len(g.Paths))
+		for pathID := range g.Paths {
+			pathIDs = append(pathIDs, int(pathID))
+		}
+		sort.Ints(pathIDs)
+		for _, pathID := range pathIDs {
+			graphRecord.Paths = append(graphRecord.Paths, string(g.Paths[uint32(pathID)]))
+		}
+		for _, region := range Info.BackgroundRegions[graphID] {
+			graphRecord.BackgroundRegions = append(graphRecord.BackgroundRegions, ManifestBackgroundRegion{
+				Path:       string(g.Paths[region.PathID]),
+				WindowSize: region.WindowSize,
+				Start:      region.Start,
+				End:        region.End,
+				Windows:    region.Windows,
+			})
+		}
+		manifest.Graphs = append(manifest.Graphs, graphRecord)
+	}
+	sort.Slice(manifest.Graphs, func(i, j int) bool { return manifest.Graphs[i].GraphID < manifest.Graphs[j].GraphID })
+	return manifest
+}
+
+// WriteManifest is a method to write the provenance information for the index to disk
+func (Info *Info) WriteManifest(fileName string) error {
+	data, err := json.MarshalIndent(Info.GetManifest(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
@@ -0,0 +1,55 @@
+package pipeline
+
+/*
+ this part of the pipeline reports the graph window that each read mapped to, along with the strand of the mapping if the index is stranded
+ the report is tab separated, with a line for each mapping, and the reads are reported in the order they finish mapping (not the input order)
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// MappingsHeader is the header line of the mapping report
+const MappingsHeader = "read\tgraph\tnode\toffset\tstrand\n"
+
+// RecordMappings is a method to write the mappings of each read to w as the reads are mapped
+// the strand is + or - for a stranded index, and . otherwise (as the windows of an unstranded index are sketched from canonical k-mers)
+func (Info *Info) RecordMappings(w io.Writer) error {
+	if _, err := io.WriteString(w, MappingsHeader); err != nil {
+		return err
+	}
+	Info.mappings = w
+	return nil
+}
+
+// writeMappings is a method to write a line to the mapping report for each graph window that a read mapped to
+func (Info *Info) writeMappings(hits readHits) error {
+	if Info.mappings == nil {
+		return nil
+	}
+	readName := mappedReadName(hits.name, hits.id)
+	for _, mapping := range hits.mappings {
+		strand := "."
+		if Info.Stranded {
+			strand = "+"
+			if mapping.RC {
+				strand = "-"
+			}
+		}
+		if _, err := fmt.Fprintf(Info.mappings, "%s\t%v\t%d\t%d\t%v\n", readName, Info.GraphName(mapping.GraphID), mapping.Node, mapping.OffSet, strand); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mappedReadName is a function to return the read ID from the first word of its ID line, using the read number if the read has no ID
+func mappedReadName(idLine []byte, readID int) []byte {
+	fields := bytes.Fields(bytes.TrimLeft(idLine, "@>"))
+	if len(fields) == 0 {
+		return []byte(fmt.Sprintf("read%d", readID+1))
+	}
+	return fields[0]
+}
@@ -0,0 +1,115 @@
+package pipeline
+
+/*
+ this part of the pipeline merges independently built indexes into a single index
+*/
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+)
+
+// MergeIndexes is a function to combine several indexes, which must have been built with the same parameters, into a single index
+// the graphs are given stable graphIDs derived from their names, and the windows in each LSH Ensemble are rewritten to match
+// if a prefix is given for an index, it is added to the names of its graphs so that names shared between indexes don't clash
+// graphs without a name are named by their graphID in the merged index, so unnamed graphs with the same graphID in several indexes need prefixes
+// the coarse scale of the first index is used for the merged index, as the coarse sketches are rebuilt when the index is written
+// the background is only kept if every index subtracted the same one, but the background regions of each graph are always kept
+func MergeIndexes(infos []*Info, dbs []*graph.ContainmentIndex, prefixes []string) (*Info, error) {
+	if len(infos) < 2 {
+		return nil, fmt.Errorf("need at least 2 indexes to merge")
+	}
+	if len(dbs) != len(infos) {
+		return nil, fmt.Errorf("each index needs a runtime info and an LSH Ensemble")
+	}
+	if prefixes != nil && len(prefixes) != len(infos) {
+		return nil, fmt.Errorf("number of prefixes (%d) does not match the number of indexes (%d)", len(prefixes), len(infos))
+	}
+	merged := &Info{
+		KmerSize:         infos[0].KmerSize,
+		SketchSize:       infos[0].SketchSize,
+		WindowSize:       infos[0].WindowSize,
+		NumPart:          infos[0].NumPart,
+		MaxK:             infos[0].MaxK,
+		WindowSizes:      infos[0].WindowSizes,
+		Stranded:         infos[0].Stranded,
+		SharedSimilarity: infos[0].SharedSimilarity,
+		DustLevel:        infos[0].DustLevel,
+		MaxTraversals:    infos[0].MaxTraversals,
+		WindowStride:     infos[0].WindowStride,
+		MinimizerWindows: infos[0].MinimizerWindows,
+		CoarseScale:      infos[0].CoarseScale,
+		Store:            make(graph.Store),
+		Sources:          make(map[uint32]string),
+		Inputs:           make(map[uint32]InputFile),
+		Database:         infos[0].Database,
+
+		Background:        infos[0].Background,
+		BackgroundRegions: make(map[uint32][]graph.BackgroundRegion),
+		background:        infos[0].background,
+	}
+	mergedDB := &graph.ContainmentIndex{}
+	names := make(map[string]int)
+	for i, info := range infos {
+		if !info.sameParameters(merged) {
+			return nil, fmt.Errorf("index %d was built with different parameters to index 1 (k-mer, sketch and window sizes, numPart, maxK, strandedness, DUST level and window selection must match)", i+1)
+		}
+
+		// only keep the database release if every index was built from it
+		if merged.Database != nil && (info.Database == nil || info.Database.Name != merged.Database.Name || info.Database.MD5 != merged.Database.MD5) {
+			merged.Database = nil
+		}
+		if !info.sameBackground(infos[0]) {
+			merged.Background, merged.background = nil, nil
+		}
+
+		// give the graphs stable graphIDs derived from their names in the merged index, working through them in graphID order
+		graphIDs := make([]uint32, 0, len(info.Store))
+		for graphID := range info.Store {
+			graphIDs = append(graphIDs, graphID)
+		}
+		sort.Slice(graphIDs, func(a, b int) bool { return graphIDs[a] < graphIDs[b] })
+		newIDs := make(map[uint32]uint32, len(graphIDs))
+		for _, graphID := range graphIDs {
+
+			// graphs without a recorded source are named by their graphID, which is recorded as their source so that they keep the name the user knew them by
+			source := info.GraphName(graphID)
+			if prefixes != nil && prefixes[i] != "" {
+				source = prefixes[i] + "-" + source
+			}
+			if existing, ok := names[source]; ok {
+				if _, named := info.Sources[graphID]; !named {
+					return nil, fmt.Errorf("unnamed graph %v is in index %d and index %d, please use --prefixes to keep them apart", source, existing, i+1)
+				}
+				return nil, fmt.Errorf("graph name \"%v\" is used in index %d and index %d", source, existing, i+1)
+			}
+			names[source] = i + 1
+			newID, _, err := merged.GetGraphID(source)
+			if err != nil {
+				return nil, err
+			}
+			newIDs[graphID] = newID
+			g := info.Store[graphID]
+			g.GraphID = newID
+			merged.Store[newID] = g
+			if input, ok := info.Inputs[graphID]; ok {
+				merged.Inputs[newID] = input
+			}
+			if regions, ok := info.BackgroundRegions[graphID]; ok {
+				merged.BackgroundRegions[newID] = regions
+			}
+		}
+
+		// rewrite the windows for the new graphIDs and add them to the merged index
+		if err := dbs[i].RenumberGraphs(newIDs); err != nil {
+			return nil, err
+		}
+		if err := mergedDB.AddIndex(dbs[i]); err != nil {
+			return nil, err
+		}
+	}
+	merged.AttachDB(mergedDB)
+	return merged, nil
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+/*
+ this part of the pipeline builds a Bloom filter of every k-mer in the graphs when an index is written, which is used to reject reads that share too few k-mers with the database before they are sketched
+ the k-mers are also sampled for the coarse index, if one was requested (see coarse.go)
+*/
+
+import (
+	"math"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/minhash"
+)
+
+// the settings for the k-mer prefilter
+const (
+	PrefilterBitsPerKmer    = 10 // the number of bits in the prefilter for each k-mer in the graphs (giving a false positive rate of ~1% with 7 hashes)
+	PrefilterHashes         = 7  // the number of bits set for each k-mer
+	DefaultMinSharedKmers   = 0  // the default number of k-mers a read must share with the graphs to be sketched (prefiltering is off unless requested, so that no mappable reads are dropped)
+	SuggestedMinSharedKmers = 10 // a suggested number of shared k-mers to use if prefiltering is turned on
+)
+
+// BuildKmerIndexes is a method to build the k-mer prefilter and the coarse sketches (if Info.CoarseScale is set) from the graphs in the Store, replacing any existing ones
+func (Info *Info) BuildKmerIndexes() error {
+	graphHashes := make(map[uint32][]uint64, len(Info.Store))
+	numKmers := 0
+	for graphID, g := range Info.Store {
+		hashes, err := g.KmerHashes(Info.KmerSize, Info.DustLevel, Info.MaxTraversals)
+		if err != nil {
+			return err
+		}
+		graphHashes[graphID] = hashes
+		numKmers += len(hashes)
+	}
+	prefilter := minhash.NewMultiHashBloomFilter(numKmers*PrefilterBitsPerKmer, PrefilterHashes)
+	for _, hashes := range graphHashes {
+		for _, hash := range hashes {
+			prefilter.Add(hash)
+		}
+	}
+	Info.prefilter = prefilter
+	Info.coarseSketches = nil
+	if Info.CoarseScale > 0 {
+		Info.coarseSketches = make(map[uint32][]uint64, len(graphHashes))
+		for graphID, hashes := range graphHashes {
+			Info.coarseSketches[graphID] = graph.CoarseSketch(hashes, Info.KmerSize, Info.CoarseScale)
+		}
+	}
+	return nil
+}
+
+// HasPrefilter is a method to check if the index has a k-mer prefilter (indexes built before prefiltering don't)
+func (Info *Info) HasPrefilter() bool {
+	return Info.prefilter != nil
+}
+
+// prefilterRead is a method to check if a read shares at least minShared k-mers with the graphs, according to the prefilter
+// reads are always kept if there is no prefilter
+func (Info *Info) prefilterRead(read []byte, minShared int) bool {
+	if Info.prefilter == nil || minShared < 1 {
+		return true
+	}
+	shared := 0
+	for _, hash := range minhash.KmerHashes(read, uint(Info.KmerSize)) {
+		if hash != math.MaxUint64 && Info.prefilter.Check(hash) {
+			if shared++; shared >= minShared {
+				return true
+			}
+		}
+	}
+	return false
+}
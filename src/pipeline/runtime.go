@@ -1,13 +1,18 @@
 package pipeline
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/indexio"
+	"github.com/will-rowe/baby-groot/src/minhash"
 )
 
 // Info stores the runtime information
@@ -23,18 +28,65 @@ type Info struct {
 	ContainmentThreshold float64
 	IndexDir             string
 	Store                graph.Store
+	Sources              map[uint32]string    // the name of the input (MSA, GFA or cluster) used to build each graph in the Store
+	Inputs               map[uint32]InputFile // the file (and its md5sum) used to build each graph in the Store
+	Database             *DatabaseRelease     // the database release used to build the index (if downloaded by groot get)
+	NumShards            int                  // the number of shards the index is split into
+	ShardID              int                  // the shard of the index that this runtime info was loaded from
+	WindowSizes          []int                // the window sizes of a multi-resolution index (empty if only WindowSize is used)
+	Stranded             bool                 // the index holds a window for each strand, sketched with forward k-mers instead of canonical k-mers
+	SharedSimilarity     float64              // the minimum sketch similarity for windows from different graphs to be tagged as shared
+	DustLevel            int                  // the DUST score threshold (x10) used to mask low-complexity sequence in the graph windows and reads (0 if masking is off)
+	MaxTraversals        int                  // the maximum number of graph traversals windowed from each window start (0 if only the paths are windowed)
+	WindowStride         int                  // only every WindowStride-th window of each path is indexed (0 or 1 if every window is indexed)
+	MinimizerWindows     bool                 // index the windows anchored by a minimizer of WindowStride consecutive window starts, instead of using a fixed stride
+	CoarseScale          int                  // sample 1 in CoarseScale of the k-mers in each graph for the coarse index, used for two-stage querying (0 if there is no coarse index)
+	Background           *BackgroundSet       // the background sequences whose k-mers were subtracted from the graph windows (nil if there was no background)
 
-	// the following fields are not written to disk
+	// the regions of each graph's paths whose windows were left out of the index as background
+	BackgroundRegions map[uint32][]graph.BackgroundRegion
+
+	// the following fields hold the settings for each command
 	Sketch    SketchCmd
 	Haplotype HaploCmd
+	BuildDB   BuildDBCmd
+	Validate  ValidateCmd
 	db        *graph.ContainmentIndex
+	shards    []IndexShard         // the index shards to query, if no LSH Ensemble index is attached
+	prefilter *minhash.BloomFilter // the Bloom filter of the k-mers in the graphs, used to reject reads before they are sketched
+
+	// the k-mers sampled from each graph, and the coarse index built from them if two-stage querying is used
+	coarseSketches map[uint32][]uint64
+	coarse         *graph.CoarseIndex
+
+	// the background k-mers, used to leave out the graph windows dominated by them
+	background *graph.Background
+
+	// the graphID for each name in Sources, used to find graphs by name
+	sourceLookup map[string]uint32
+
+	// the mapping report, which receives a line for each graph window that a read maps to (nil if the mappings aren't being recorded)
+	mappings io.Writer
 }
 
 // SketchCmd stores the runtime info for the sketch command
 type SketchCmd struct {
-	Fasta           bool
-	BloomFilter     bool
-	MinKmerCoverage float64
+	Fasta            bool
+	BloomFilter      bool
+	MinKmerCoverage  float64
+	SequentialShards bool    // query the index shards one at a time, instead of holding them all in memory
+	SharedPolicy     string  // how to map reads that hit windows shared by several graphs (distribute, drop or flag)
+	MinSharedKmers   int     // the number of k-mers a read must share with the graphs (according to the prefilter) to be sketched (0 turns prefiltering off)
+	Hierarchical     bool    // use the coarse index to choose the graphs for each read, then only query the windows from those graphs
+	CoarseThreshold  float64 // the proportion of the sampled k-mers from a read that a graph must hold to be chosen by the coarse index
+}
+
+// BuildDBCmd stores the runtime info for the build-db command
+type BuildDBCmd struct {
+	Identity          float64
+	ClusterKmerSize   int
+	ClusterSketchSize int
+	MaxCandidates     int
 }
 
 // HaploCmd stores the runtime info for the haplotype command
@@ -46,6 +98,182 @@ type HaploCmd struct {
 	HaploDir      string
 }
 
+// GetGraphID is a method to return the graphID for a named source, assigning a stable graphID derived from the name if the source has not been seen before
+// the bool is true if the source was already recorded in the runtime info (i.e. the graph is being replaced)
+// a graph without a recorded source (i.e. from an index built before graphs were named) is matched by its graphID, which is then recorded as its source
+// an error is returned if the graphID derived from the name is already used by another graph
+func (Info *Info) GetGraphID(source string) (uint32, bool, error) {
+	if Info.Sources == nil {
+		Info.Sources = make(map[uint32]string)
+	}
+	if graphID, ok := Info.sourceIDs()[source]; ok {
+		return graphID, true, nil
+	}
+	if graphID, ok := Info.FindGraph(source); ok {
+		Info.setSource(graphID, source)
+		return graphID, true, nil
+	}
+	graphID, err := Info.newGraphID(source)
+	if err != nil {
+		return 0, false, err
+	}
+	Info.setSource(graphID, source)
+	return graphID, false, nil
+}
+
+// newGraphID is a method to derive a graphID from a graph name, so that graphs keep their graphIDs when other graphs are added to or removed from the database
+// the FNV-1a hash of the name is used, and it is an error if the hash is already taken by another graph, as moving to another graphID would make it depend on the order that graphs were added
+func (Info *Info) newGraphID(name string) (uint32, error) {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(name))
+	graphID := hasher.Sum32()
+	if existing, ok := Info.Sources[graphID]; ok {
+		return 0, fmt.Errorf("graph names \"%v\" and \"%v\" both give graphID %d, please rename one of them", name, existing, graphID)
+	}
+	if _, ok := Info.Store[graphID]; ok {
+		return 0, fmt.Errorf("graph name \"%v\" gives graphID %d, which is already used by an unnamed graph", name, graphID)
+	}
+	return graphID, nil
+}
+
+// sourceIDs is a method to return the graphID for each source name, rebuilding the lookup if the Sources have been changed without using setSource or removeSource
+func (Info *Info) sourceIDs() map[string]uint32 {
+	if Info.sourceLookup == nil || len(Info.sourceLookup) != len(Info.Sources) {
+		Info.sourceLookup = make(map[string]uint32, len(Info.Sources))
+		for graphID, source := range Info.Sources {
+			Info.sourceLookup[source] = graphID
+		}
+	}
+	return Info.sourceLookup
+}
+
+// setSource is a method to record the source name for a graph
+func (Info *Info) setSource(graphID uint32, source string) {
+	if Info.Sources == nil {
+		Info.Sources = make(map[uint32]string)
+	}
+	lookup := Info.sourceIDs()
+	if existing, ok := Info.Sources[graphID]; ok {
+		delete(lookup, existing)
+	}
+	Info.Sources[graphID] = source
+	lookup[source] = graphID
+}
+
+// removeSource is a method to remove the source name recorded for a graph
+func (Info *Info) removeSource(graphID uint32) {
+	source, ok := Info.Sources[graphID]
+	if !ok {
+		return
+	}
+	delete(Info.sourceIDs(), source)
+	delete(Info.Sources, graphID)
+}
+
+// UnnamedGraphs is a method to return the graphIDs of the graphs without a recorded source, which are in indexes built before graphs were named
+func (Info *Info) UnnamedGraphs() []uint32 {
+	unnamed := []uint32{}
+	for graphID := range Info.Store {
+		if _, ok := Info.Sources[graphID]; !ok {
+			unnamed = append(unnamed, graphID)
+		}
+	}
+	sort.Slice(unnamed, func(i, j int) bool { return unnamed[i] < unnamed[j] })
+	return unnamed
+}
+
+// GetWindowSizes is a method to return the window size of each window set in the index
+func (Info *Info) GetWindowSizes() []int {
+	if len(Info.WindowSizes) == 0 {
+		return []int{Info.WindowSize}
+	}
+	return Info.WindowSizes
+}
+
+// GetWindowStride is a method to return the stride between the indexed windows of each path, which is 1 for indexes built before the stride could be set
+func (Info *Info) GetWindowStride() int {
+	if Info.WindowStride < 1 {
+		return 1
+	}
+	return Info.WindowStride
+}
+
+// sameParameters is a method to check that two indexes were built with the same parameters
+func (Info *Info) sameParameters(other *Info) bool {
+	if Info.KmerSize != other.KmerSize || Info.SketchSize != other.SketchSize || Info.WindowSize != other.WindowSize || Info.NumPart != other.NumPart || Info.MaxK != other.MaxK || Info.Stranded != other.Stranded || Info.DustLevel != other.DustLevel || Info.MaxTraversals != other.MaxTraversals || Info.GetWindowStride() != other.GetWindowStride() || Info.MinimizerWindows != other.MinimizerWindows {
+		return false
+	}
+	windowSizes, otherSizes := Info.GetWindowSizes(), other.GetWindowSizes()
+	if len(windowSizes) != len(otherSizes) {
+		return false
+	}
+	for i := range windowSizes {
+		if windowSizes[i] != otherSizes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GraphName is a method to return the name of the source used to build a graph, falling back to the graphID for graphs without a recorded source
+func (Info *Info) GraphName(graphID uint32) string {
+	if name, ok := Info.Sources[graphID]; ok {
+		return name
+	}
+	return strconv.Itoa(int(graphID))
+}
+
+// GraphFileName is a method to return the graph name for use in output file names, replacing any characters that aren't letters, digits, dots, dashes or underscores
+func (Info *Info) GraphFileName(graphID uint32) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, Info.GraphName(graphID))
+}
+
+// FindGraph is a method to return the graphID for a graph name, which can also be the graphID itself
+func (Info *Info) FindGraph(name string) (uint32, bool) {
+	if graphID, ok := Info.sourceIDs()[name]; ok {
+		_, stored := Info.Store[graphID]
+		return graphID, stored
+	}
+	graphID, err := strconv.ParseUint(name, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	if _, named := Info.Sources[uint32(graphID)]; named {
+		return 0, false
+	}
+	_, stored := Info.Store[uint32(graphID)]
+	return uint32(graphID), stored
+}
+
+// RemoveGraphs is a method to remove a set of graphs from the runtime info and their windows from the attached LSH Ensemble
+// it returns the number of sketches removed from the LSH Ensemble
+func (Info *Info) RemoveGraphs(graphIDs map[uint32]struct{}) (int, error) {
+	if Info.db == nil {
+		return 0, fmt.Errorf("no LSH Ensemble is attached to the runtime info")
+	}
+	for graphID := range graphIDs {
+		if _, ok := Info.Store[graphID]; !ok {
+			return 0, fmt.Errorf("graph %d is not in the index", graphID)
+		}
+	}
+	removed, err := Info.db.RemoveGraphs(graphIDs)
+	if err != nil {
+		return 0, err
+	}
+	for graphID := range graphIDs {
+		delete(Info.Store, graphID)
+		Info.removeSource(graphID)
+		delete(Info.Inputs, graphID)
+		delete(Info.BackgroundRegions, graphID)
+	}
+	return removed, nil
+}
+
 // AttachDB is a method to attach a LSH Ensemble index to the runtime
 func (Info *Info) AttachDB(db *graph.ContainmentIndex) {
 	Info.db = db
@@ -59,12 +287,70 @@ func (Info *Info) SaveDB(filePath string) error {
 // Dump is a method to dump the pipeline info to file
 func (Info *Info) Dump(path string) error {
 	fh, err := os.Create(path)
-	defer fh.Close()
 	if err != nil {
 		return err
 	}
-	encoder := gob.NewEncoder(fh)
-	return encoder.Encode(Info)
+	defer fh.Close()
+	record := &indexio.InfoRecord{
+		Version:    Info.Version,
+		KmerSize:   Info.KmerSize,
+		SketchSize: Info.SketchSize,
+		WindowSize: Info.WindowSize,
+		NumPart:    Info.NumPart,
+		MaxK:       Info.MaxK,
+		Sources:    Info.Sources,
+		Inputs:     make(map[uint32]indexio.InputRecord, len(Info.Inputs)),
+		NumShards:  Info.NumShards,
+		ShardID:    Info.ShardID,
+		BuildDB:    indexio.BuildDBRecord(Info.BuildDB),
+
+		WindowSizes: Info.WindowSizes,
+		Stranded:    Info.Stranded,
+
+		SharedSimilarity: Info.SharedSimilarity,
+		DustLevel:        Info.DustLevel,
+		MaxTraversals:    Info.MaxTraversals,
+		WindowStride:     Info.WindowStride,
+		MinimizerWindows: Info.MinimizerWindows,
+		CoarseScale:      Info.CoarseScale,
+		CoarseSketches:   make(map[uint32][]uint64),
+
+		BackgroundRegions: make(map[uint32][]indexio.BackgroundRegionRecord, len(Info.BackgroundRegions)),
+
+		NumProc:              Info.NumProc,
+		ContainmentThreshold: Info.ContainmentThreshold,
+		IndexDir:             Info.IndexDir,
+		Sketch:               indexio.SketchRecord(Info.Sketch),
+		Haplotype:            indexio.HaploRecord(Info.Haplotype),
+	}
+	for graphID, input := range Info.Inputs {
+		record.Inputs[graphID] = indexio.InputRecord(input)
+	}
+	for graphID := range Info.Store {
+		if sketch, ok := Info.coarseSketches[graphID]; ok {
+			record.CoarseSketches[graphID] = sketch
+		}
+		for _, region := range Info.BackgroundRegions[graphID] {
+			record.BackgroundRegions[graphID] = append(record.BackgroundRegions[graphID], indexio.BackgroundRegionRecord(region))
+		}
+	}
+	if Info.Background != nil {
+		background := indexio.BackgroundRecord(*Info.Background)
+		record.Background = &background
+		if Info.background != nil {
+			record.BackgroundKmers = Info.background.Kmers()
+		}
+	}
+	if Info.prefilter != nil {
+		if record.Prefilter, err = Info.prefilter.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+	if Info.Database != nil {
+		release := indexio.ReleaseRecord(*Info.Database)
+		record.Database = &release
+	}
+	return indexio.WriteInfo(fh, record, Info.Store.Records())
 }
 
 // Load is a method to load Info from file
@@ -76,12 +362,71 @@ func (Info *Info) Load(path string) error {
 	return Info.LoadFromBytes(data)
 }
 
-// LoadFromBytes is a method to load Info from bytes
+// LoadFromBytes is a method to load Info from bytes, migrating older index formats
 func (Info *Info) LoadFromBytes(data []byte) error {
 	if len(data) == 0 {
 		return fmt.Errorf("groot graph store appears empty")
 	}
-	buf := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buf)
-	return decoder.Decode(Info)
+	record, graphs, err := indexio.ReadInfo(data)
+	if err != nil {
+		return err
+	}
+	Info.Version = record.Version
+	Info.KmerSize = record.KmerSize
+	Info.SketchSize = record.SketchSize
+	Info.WindowSize = record.WindowSize
+	Info.NumPart = record.NumPart
+	Info.MaxK = record.MaxK
+	Info.Sources = record.Sources
+	Info.sourceLookup = nil
+	Info.NumShards = record.NumShards
+	Info.ShardID = record.ShardID
+	Info.WindowSizes = record.WindowSizes
+	Info.Stranded = record.Stranded
+	Info.SharedSimilarity = record.SharedSimilarity
+	Info.DustLevel = record.DustLevel
+	Info.MaxTraversals = record.MaxTraversals
+	Info.WindowStride = record.WindowStride
+	Info.MinimizerWindows = record.MinimizerWindows
+	Info.CoarseScale = record.CoarseScale
+	Info.coarseSketches = nil
+	if record.CoarseScale > 0 {
+		Info.coarseSketches = record.CoarseSketches
+	}
+	Info.Inputs = make(map[uint32]InputFile, len(record.Inputs))
+	for graphID, input := range record.Inputs {
+		Info.Inputs[graphID] = InputFile(input)
+	}
+	Info.Background, Info.background = nil, nil
+	if record.Background != nil {
+		background := BackgroundSet(*record.Background)
+		Info.Background = &background
+		Info.background = graph.NewBackgroundFromKmers(record.BackgroundKmers, record.KmerSize)
+	}
+	Info.BackgroundRegions = make(map[uint32][]graph.BackgroundRegion, len(record.BackgroundRegions))
+	for graphID, regions := range record.BackgroundRegions {
+		for _, region := range regions {
+			Info.BackgroundRegions[graphID] = append(Info.BackgroundRegions[graphID], graph.BackgroundRegion(region))
+		}
+	}
+	Info.Database = nil
+	if record.Database != nil {
+		release := DatabaseRelease(*record.Database)
+		Info.Database = &release
+	}
+	Info.BuildDB = BuildDBCmd(record.BuildDB)
+	Info.NumProc = record.NumProc
+	Info.ContainmentThreshold = record.ContainmentThreshold
+	Info.IndexDir = record.IndexDir
+	Info.Sketch = SketchCmd(record.Sketch)
+	Info.Haplotype = HaploCmd(record.Haplotype)
+	Info.Store = graph.NewStoreFromRecords(graphs)
+	Info.prefilter = nil
+	if len(record.Prefilter) != 0 {
+		Info.prefilter = new(minhash.BloomFilter)
+		if err := Info.prefilter.UnmarshalBinary(record.Prefilter); err != nil {
+			return err
+		}
+	}
+	return nil
 }
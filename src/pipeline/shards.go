@@ -0,0 +1,272 @@
+package pipeline
+
+/*
+ this part of the pipeline splits an index into shards, which are independent pairs of graph (.gg) and LSH Ensemble (.lshe) files, and loads them back
+*/
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/misc"
+)
+
+// the names of the index files
+const (
+	InfoFile    = "groot.gg"     // the graphs and parameters for an unsharded index
+	IndexFile   = "groot.lshe"   // the LSH Ensemble for an unsharded index
+	ShardPrefix = "groot-shard-" // the prefix for the files of a sharded index
+)
+
+// IndexShard is a pair of index files, holding a set of graphs and the LSH Ensemble of their windows
+type IndexShard struct {
+	InfoFile  string
+	IndexFile string
+}
+
+// GetShardFiles is a function to return the index files for one shard of an index
+// an index with a single shard uses groot.gg and groot.lshe
+func GetShardFiles(indexDir string, shardID, numShards int) IndexShard {
+	if numShards <= 1 {
+		return IndexShard{InfoFile: filepath.Join(indexDir, InfoFile), IndexFile: filepath.Join(indexDir, IndexFile)}
+	}
+	base := filepath.Join(indexDir, fmt.Sprintf("%v%d", ShardPrefix, shardID))
+	return IndexShard{InfoFile: base + ".gg", IndexFile: base + ".lshe"}
+}
+
+// FindShards is a function to return the index files in a directory, checking that every shard has both of its files
+func FindShards(indexDir string) ([]IndexShard, error) {
+	infoFiles := []string{}
+	if misc.CheckFile(filepath.Join(indexDir, InfoFile)) == nil {
+		infoFiles = append(infoFiles, filepath.Join(indexDir, InfoFile))
+	} else {
+		var err error
+		if infoFiles, err = filepath.Glob(filepath.Join(indexDir, ShardPrefix+"*.gg")); err != nil {
+			return nil, err
+		}
+	}
+	if len(infoFiles) == 0 {
+		return nil, fmt.Errorf("no index files found in %v", indexDir)
+	}
+	shards := make([]IndexShard, len(infoFiles))
+	for i := range shards {
+		shards[i] = GetShardFiles(indexDir, i, len(shards))
+		if err := misc.CheckFile(shards[i].InfoFile); err != nil {
+			return nil, err
+		}
+		if err := misc.CheckFile(shards[i].IndexFile); err != nil {
+			return nil, err
+		}
+	}
+	return shards, nil
+}
+
+// LoadShards is a function to load the graphs and parameters from every shard of an index into a single runtime info
+// the LSH Ensemble files are attached to the runtime info, so that they can be loaded by the ReadMapper
+func LoadShards(shards []IndexShard) (*Info, error) {
+	var info *Info
+	for shardID, shard := range shards {
+		shardInfo := new(Info)
+		if err := shardInfo.Load(shard.InfoFile); err != nil {
+			return nil, err
+		}
+		numShards := shardInfo.NumShards
+		if numShards == 0 {
+			numShards = 1
+		}
+		if numShards != len(shards) || shardInfo.ShardID != shardID {
+			return nil, fmt.Errorf("index shard %v is shard %d of %d, but %d shards were found", shard.InfoFile, shardInfo.ShardID+1, numShards, len(shards))
+		}
+		if info == nil {
+			info = shardInfo
+			continue
+		}
+		if !shardInfo.sameParameters(info) {
+			return nil, fmt.Errorf("index shard %v was built with different parameters to the other shards", shard.InfoFile)
+		}
+
+		// every shard holds the prefilter for the whole index, so only prefilter if none of them are missing it
+		if shardInfo.prefilter == nil {
+			info.prefilter = nil
+		}
+		for graphID, g := range shardInfo.Store {
+			if _, ok := info.Store[graphID]; ok {
+				return nil, fmt.Errorf("graph %d is present in more than one index shard", graphID)
+			}
+			info.Store[graphID] = g
+		}
+		for graphID, source := range shardInfo.Sources {
+			info.setSource(graphID, source)
+		}
+		for graphID, input := range shardInfo.Inputs {
+			info.Inputs[graphID] = input
+		}
+		for graphID, regions := range shardInfo.BackgroundRegions {
+			info.BackgroundRegions[graphID] = regions
+		}
+
+		// the coarse index can only be used if every shard has coarse sketches for its graphs
+		if info.coarseSketches != nil && shardInfo.coarseSketches != nil {
+			for graphID, sketch := range shardInfo.coarseSketches {
+				info.coarseSketches[graphID] = sketch
+			}
+		} else {
+			info.coarseSketches = nil
+		}
+	}
+	info.ShardID = 0
+	info.shards = shards
+	return info, nil
+}
+
+// ReadShards is a function to read the LSH Ensemble records from every shard of an index into a single containment index
+// the LSH Ensemble is not populated, so that windows can be added to or removed from the index before it is written again
+func ReadShards(shards []IndexShard) (*graph.ContainmentIndex, error) {
+	lshe := &graph.ContainmentIndex{}
+	for _, shard := range shards {
+		shardIndex := &graph.ContainmentIndex{}
+		if err := shardIndex.Read(shard.IndexFile); err != nil {
+			return nil, err
+		}
+		if err := lshe.AddIndex(shardIndex); err != nil {
+			return nil, err
+		}
+	}
+	return lshe, nil
+}
+
+// SplitShards is a function to split the graphs and the attached LSH Ensemble into shards, balancing the number of windows in each shard
+// it returns a runtime info for each shard, with its own LSH Ensemble attached
+func SplitShards(info *Info, numShards int) ([]*Info, error) {
+	if info.db == nil {
+		return nil, fmt.Errorf("no LSH Ensemble is attached to the runtime info")
+	}
+	if numShards > len(info.Store) {
+		return nil, fmt.Errorf("cannot split %d graphs into %d index shards", len(info.Store), numShards)
+	}
+
+	// assign the graphs with the most windows first, each to the shard with the fewest windows so far
+	windowStats := info.db.GetWindowStats()
+	graphIDs := make([]uint32, 0, len(info.Store))
+	for graphID := range info.Store {
+		graphIDs = append(graphIDs, graphID)
+	}
+	numWindows := func(graphID uint32) int {
+		if stats, ok := windowStats[graphID]; ok {
+			return stats.Windows
+		}
+		return 0
+	}
+	sort.Slice(graphIDs, func(i, j int) bool {
+		if numWindows(graphIDs[i]) != numWindows(graphIDs[j]) {
+			return numWindows(graphIDs[i]) > numWindows(graphIDs[j])
+		}
+		return graphIDs[i] < graphIDs[j]
+	})
+	shardGraphs := make([]map[uint32]struct{}, numShards)
+	shardWindows := make([]int, numShards)
+	for i := range shardGraphs {
+		shardGraphs[i] = make(map[uint32]struct{})
+	}
+	for _, graphID := range graphIDs {
+		smallest := 0
+		for shardID := range shardWindows {
+			if len(shardGraphs[shardID]) == 0 || shardWindows[shardID] < shardWindows[smallest] {
+				smallest = shardID
+				if len(shardGraphs[shardID]) == 0 {
+					break
+				}
+			}
+		}
+		shardGraphs[smallest][graphID] = struct{}{}
+		shardWindows[smallest] += numWindows(graphID)
+	}
+
+	// create the runtime info and LSH Ensemble for each shard
+	shards := make([]*Info, numShards)
+	for shardID, graphs := range shardGraphs {
+		db, err := info.db.Subset(graphs)
+		if err != nil {
+			return nil, err
+		}
+		shardInfo := *info
+		shardInfo.NumShards = numShards
+		shardInfo.ShardID = shardID
+		shardInfo.Store = make(graph.Store)
+		shardInfo.Sources = make(map[uint32]string)
+		shardInfo.Inputs = make(map[uint32]InputFile)
+		shardInfo.BackgroundRegions = make(map[uint32][]graph.BackgroundRegion)
+		for graphID := range graphs {
+			shardInfo.Store[graphID] = info.Store[graphID]
+			if source, ok := info.Sources[graphID]; ok {
+				shardInfo.Sources[graphID] = source
+			}
+			if input, ok := info.Inputs[graphID]; ok {
+				shardInfo.Inputs[graphID] = input
+			}
+			if regions, ok := info.BackgroundRegions[graphID]; ok {
+				shardInfo.BackgroundRegions[graphID] = regions
+			}
+		}
+		shardInfo.db = db
+		shardInfo.shards = nil
+		shards[shardID] = &shardInfo
+	}
+	return shards, nil
+}
+
+// WriteIndex is a method to write the graphs and the attached LSH Ensemble to an index directory, splitting them into shards if requested
+// the k-mer prefilter (written to every shard) and the coarse sketches are rebuilt from the graphs, so that they match any graphs that have been added or removed
+// any index files left from a previous index with a different number of shards are removed
+func (Info *Info) WriteIndex(indexDir string, numShards int) error {
+	if err := Info.BuildKmerIndexes(); err != nil {
+		return err
+	}
+	oldShards, _ := FindShards(indexDir)
+	newFiles := make(map[string]struct{})
+	if numShards <= 1 {
+		Info.NumShards, Info.ShardID = 1, 0
+		shard := GetShardFiles(indexDir, 0, 1)
+		if err := Info.SaveDB(shard.IndexFile); err != nil {
+			return err
+		}
+		if err := Info.Dump(shard.InfoFile); err != nil {
+			return err
+		}
+		newFiles[shard.InfoFile], newFiles[shard.IndexFile] = struct{}{}, struct{}{}
+	} else {
+		shardInfos, err := SplitShards(Info, numShards)
+		if err != nil {
+			return err
+		}
+		for shardID, shardInfo := range shardInfos {
+			shard := GetShardFiles(indexDir, shardID, numShards)
+			if err := shardInfo.SaveDB(shard.IndexFile); err != nil {
+				return err
+			}
+			if err := shardInfo.Dump(shard.InfoFile); err != nil {
+				return err
+			}
+			newFiles[shard.InfoFile], newFiles[shard.IndexFile] = struct{}{}, struct{}{}
+		}
+		Info.NumShards = numShards
+	}
+	for _, shard := range oldShards {
+		for _, file := range []string{shard.InfoFile, shard.IndexFile} {
+			if _, ok := newFiles[file]; !ok {
+				if err := os.Remove(file); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetShards is a method to return the index shards attached to the runtime info
+func (Info *Info) GetShards() []IndexShard {
+	return Info.shards
+}
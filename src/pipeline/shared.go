@@ -0,0 +1,275 @@
+package pipeline
+
+/*
+ this part of the pipeline finds the windows that are shared by different graphs, tags them in the index and reports the regions each pair of graphs shares
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/lshforest"
+)
+
+// the policies for mapping reads that hit windows shared by several graphs
+const (
+	SharedDistribute = "distribute" // split the k-mers of the read between the graphs that share the window
+	SharedDrop       = "drop"       // ignore hits on shared windows
+	SharedFlag       = "flag"       // project the read onto every graph that shares the window, and report the k-mers from shared windows
+)
+
+// GetSharedPolicy is a method to return the policy for mapping reads that hit shared windows, which defaults to flagging them
+func (SketchCmd *SketchCmd) GetSharedPolicy() string {
+	if SketchCmd.SharedPolicy == "" {
+		return SharedFlag
+	}
+	return SketchCmd.SharedPolicy
+}
+
+// sharedHitFreq is a method to return the number of read k-mers to project onto the graph of a window hit, following the shared window policy
+// it returns false if the hit should be skipped (i.e. the window is shared and shared windows are dropped)
+func (SketchCmd *SketchCmd) sharedHitFreq(hit *lshforest.Key, kmerCount float64) (float64, bool) {
+	if len(hit.SharedWith) == 0 {
+		return kmerCount, true
+	}
+	switch SketchCmd.GetSharedPolicy() {
+	case SharedDrop:
+		return 0, false
+	case SharedDistribute:
+		return kmerCount / float64(len(hit.SharedWith)+1), true
+	default:
+		return kmerCount, true
+	}
+}
+
+// SharedRegionsFile is the report of the regions shared between graphs, which is written to the index directory
+const SharedRegionsFile = "shared-regions.tsv"
+
+// SharedRegion is a region of a graph path that is covered by windows shared with another graph
+type SharedRegion struct {
+	Graph      string
+	Path       string
+	Start      int // 0-based start of the region in the path
+	End        int // end of the region in the path (exclusive)
+	SharedWith string
+	Windows    int // the number of shared windows in the region
+}
+
+// GetSharedSimilarity is a method to return the minimum sketch similarity used to tag shared windows
+// indexes built before shared windows were tagged have no similarity recorded, so only identical windows are tagged for these
+func (Info *Info) GetSharedSimilarity() float64 {
+	if Info.SharedSimilarity == 0 {
+		return 1.0
+	}
+	return Info.SharedSimilarity
+}
+
+// TagSharedWindows is a method to find the windows in the attached index that are identical or near-identical to windows from other graphs, and record the other graphs in the SharedWith field of each window
+// windows are compared if they are the same size and from the same strand, and are near-identical if the similarity of their sketches is at least Info.SharedSimilarity
+// it returns the number of windows that are shared
+func (Info *Info) TagSharedWindows() (int, error) {
+	if Info.db == nil || Info.db.Windows == nil {
+		return 0, fmt.Errorf("no LSH Ensemble index is attached to the runtime info")
+	}
+	similarity := Info.GetSharedSimilarity()
+	if similarity < 0 || similarity > 1 {
+		return 0, fmt.Errorf("shared window similarity must be between 0 and 1")
+	}
+
+	// clear any old tags, working through the windows in window ID order
+	windows := Info.db.Windows
+	for _, window := range windows {
+		window.SharedWith = nil
+	}
+
+	// split the sketches into bands - if two sketches have the required similarity, they differ in at most (1-similarity)*sketchSize slots, so at least one band will match exactly
+	numBands := int((1-similarity)*float64(Info.SketchSize)) + 1
+	if numBands > Info.SketchSize {
+		numBands = Info.SketchSize
+	}
+	bandKey := func(window *lshforest.Key, band int) string {
+		lower, upper := band*Info.SketchSize/numBands, (band+1)*Info.SketchSize/numBands
+		return fmt.Sprintf("%d/%d/%v/%v", band, window.WindowSize, window.RC, lshforest.CompressSketch2String(window.Sketch[lower:upper]))
+	}
+	buckets := make(map[string][]int)
+	for i, window := range windows {
+		if len(window.Sketch) != Info.SketchSize {
+			return 0, fmt.Errorf("window sketch does not match the sketch size of the index (%d vs. %d)", len(window.Sketch), Info.SketchSize)
+		}
+		for band := 0; band < numBands; band++ {
+			bucket := bandKey(window, band)
+			buckets[bucket] = append(buckets[bucket], i)
+		}
+	}
+
+	// compare each window to the windows from other graphs that share a bucket with it
+	// a window can share several buckets with another window, so the windows already compared are tracked for each window in turn (rather than for every pair in the index)
+	shared := make(map[int]map[uint32]struct{})
+	compared := make(map[int]struct{})
+	for x, a := range windows {
+		for y := range compared {
+			delete(compared, y)
+		}
+		for band := 0; band < numBands; band++ {
+			for _, y := range buckets[bandKey(a, band)] {
+				b := windows[y]
+				if y <= x || a.GraphID == b.GraphID {
+					continue
+				}
+				if _, ok := compared[y]; ok {
+					continue
+				}
+				compared[y] = struct{}{}
+				if sketchSimilarity(a.Sketch, b.Sketch) < similarity {
+					continue
+				}
+				for _, tag := range [][2]int{{x, y}, {y, x}} {
+					if _, ok := shared[tag[0]]; !ok {
+						shared[tag[0]] = make(map[uint32]struct{})
+					}
+					shared[tag[0]][windows[tag[1]].GraphID] = struct{}{}
+				}
+			}
+		}
+	}
+
+	// tag the shared windows
+	for i, graphIDs := range shared {
+		for graphID := range graphIDs {
+			windows[i].SharedWith = append(windows[i].SharedWith, graphID)
+		}
+		sort.Slice(windows[i].SharedWith, func(x, y int) bool { return windows[i].SharedWith[x] < windows[i].SharedWith[y] })
+	}
+	return len(shared), nil
+}
+
+// sketchSimilarity is a function to estimate the similarity of two KHF sketches
+func sketchSimilarity(a, b []uint64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	intersect := 0
+	for i := range a {
+		if a[i] == b[i] {
+			intersect++
+		}
+	}
+	return float64(intersect) / float64(len(a))
+}
+
+// SharedRegions is a method to collect the regions of each graph path that are covered by windows shared with another graph
+// overlapping windows are merged into a single region, and reverse strand windows are skipped as they cover the same regions as the forward strand windows
+func (Info *Info) SharedRegions() ([]*SharedRegion, error) {
+	if Info.db == nil || Info.db.Windows == nil {
+		return nil, fmt.Errorf("no LSH Ensemble index is attached to the runtime info")
+	}
+
+	// get the windows for each graph, path and sharing graph
+	type regionKey struct {
+		graphID, pathID, sharedWith uint32
+	}
+	intervals := make(map[regionKey][][2]int)
+	nodeStarts := make(map[uint32]map[uint32]map[uint64]int)
+	for _, window := range Info.db.Windows {
+		if len(window.SharedWith) == 0 || window.RC {
+			continue
+		}
+		g, ok := Info.Store[window.GraphID]
+		if !ok {
+			return nil, fmt.Errorf("window belongs to a graph that is not in the index: %d", window.GraphID)
+		}
+		if _, ok := nodeStarts[window.GraphID]; !ok {
+			nodeStarts[window.GraphID] = getNodeStarts(g.SortedNodes)
+		}
+		seenPaths := make(map[uint32]struct{}, len(window.Ref))
+		for _, pathID := range window.Ref {
+			if _, ok := seenPaths[pathID]; ok {
+				continue
+			}
+			seenPaths[pathID] = struct{}{}
+
+			// skip paths that don't contain the first node of the window (which can happen if identical windows in the graph were combined)
+			start, ok := nodeStarts[window.GraphID][pathID][window.Node]
+			if !ok {
+				continue
+			}
+			start += int(window.OffSet)
+			for _, graphID := range window.SharedWith {
+				key := regionKey{window.GraphID, pathID, graphID}
+				intervals[key] = append(intervals[key], [2]int{start, start + int(window.WindowSize)})
+			}
+		}
+	}
+
+	// merge the overlapping windows into regions
+	regions := []*SharedRegion{}
+	for key, windows := range intervals {
+		sort.Slice(windows, func(i, j int) bool { return windows[i][0] < windows[j][0] })
+		var region *SharedRegion
+		for _, window := range windows {
+			if region != nil && window[0] <= region.End {
+				if window[1] > region.End {
+					region.End = window[1]
+				}
+				region.Windows++
+				continue
+			}
+			region = &SharedRegion{
+				Graph:      Info.GraphName(key.graphID),
+				Path:       string(Info.Store[key.graphID].Paths[key.pathID]),
+				Start:      window[0],
+				End:        window[1],
+				SharedWith: Info.GraphName(key.sharedWith),
+				Windows:    1,
+			}
+			regions = append(regions, region)
+		}
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].Graph != regions[j].Graph {
+			return regions[i].Graph < regions[j].Graph
+		}
+		if regions[i].SharedWith != regions[j].SharedWith {
+			return regions[i].SharedWith < regions[j].SharedWith
+		}
+		if regions[i].Path != regions[j].Path {
+			return regions[i].Path < regions[j].Path
+		}
+		return regions[i].Start < regions[j].Start
+	})
+	return regions, nil
+}
+
+// getNodeStarts is a function to return the start position of each node in each path, using the same node order as used to window the graph
+func getNodeStarts(nodes []*graph.GrootGraphNode) map[uint32]map[uint64]int {
+	starts := make(map[uint32]map[uint64]int)
+	lengths := make(map[uint32]int)
+	for _, node := range nodes {
+		for _, pathID := range node.PathIDs {
+			if _, ok := starts[pathID]; !ok {
+				starts[pathID] = make(map[uint64]int)
+			}
+			starts[pathID][node.SegmentID] = lengths[pathID]
+			lengths[pathID] += len(node.Sequence)
+		}
+	}
+	return starts
+}
+
+// WriteSharedRegions is a function to write a tab separated report of the regions shared between graphs
+func WriteSharedRegions(fileName string, regions []*SharedRegion) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	w := bufio.NewWriter(fh)
+	fmt.Fprintln(w, "#graph\tpath\tstart\tend\tsharedWith\twindows")
+	for _, region := range regions {
+		fmt.Fprintf(w, "%v\t%v\t%d\t%d\t%v\t%d\n", region.Graph, region.Path, region.Start, region.End, region.SharedWith, region.Windows)
+	}
+	return w.Flush()
+}
@@ -241,12 +241,12 @@ func (proc *FastqHandler) Run() {
 type FastqChecker struct {
 	info   *Info
 	input  chan *seqio.FASTQread
-	output chan []byte
+	output chan indexedRead
 }
 
 // NewFastqChecker is the constructor
 func NewFastqChecker(info *Info) *FastqChecker {
-	return &FastqChecker{info: info, output: make(chan []byte, BUFFERSIZE)}
+	return &FastqChecker{info: info, output: make(chan indexedRead, BUFFERSIZE)}
 }
 
 // Connect is the method to join the input of this process with the output of FastqHandler
@@ -260,15 +260,29 @@ func (proc *FastqChecker) Run() {
 	log.Printf("now streaming reads...")
 
 	// count the number of reads and their lengths as we go
-	rawCount, lengthTotal := 0, 0
+	rawCount, lengthTotal, maskedBases, lowComplexity := 0, 0, 0, 0
+	readID := 0
 	for read := range proc.input {
 		rawCount++
 
 		// tally the length so we can report the mean
 		lengthTotal += len(read.Seq)
 
-		// send the read onwards for mapping
-		proc.output <- read.Seq
+		// mask low-complexity regions in the same way as the graph windows, dropping reads that are entirely masked
+		seq := read.Seq
+		if proc.info.DustLevel > 0 {
+			var numMasked int
+			seq, numMasked = seqio.DustMask(seq, proc.info.DustLevel)
+			maskedBases += numMasked
+			if seqio.UnmaskedKmers(seq, proc.info.KmerSize) == 0 {
+				lowComplexity++
+				continue
+			}
+		}
+
+		// number the read and send it onwards for mapping
+		proc.output <- indexedRead{id: readID, name: read.ID, seq: seq}
+		readID++
 	}
 
 	// check we have received reads & print stats
@@ -278,13 +292,17 @@ func (proc *FastqChecker) Run() {
 	log.Printf("\tnumber of reads received from input: %d\n", rawCount)
 	meanRL := float64(lengthTotal) / float64(rawCount)
 	log.Printf("\tmean read length: %.0f\n", meanRL)
+	if proc.info.DustLevel > 0 {
+		log.Printf("\tnumber of low-complexity bases masked: %d\n", maskedBases)
+		log.Printf("\tnumber of reads dropped as low-complexity: %d\n", lowComplexity)
+	}
 	close(proc.output)
 }
 
 // ReadMapper is a pipeline process to query the LSH database, map reads and project alignments onto graphs
 type ReadMapper struct {
 	info      *Info
-	input     chan []byte
+	input     chan indexedRead
 	output    chan *graph.GrootGraph
 	readStats [4]int // corresponds to num. reads, total num. mapped, num. multimapped, total k-mers
 }
@@ -316,7 +334,13 @@ func (proc *ReadMapper) Run() {
 	if theBoss.receivedReadCount == 0 {
 		misc.ErrorCheck(fmt.Errorf("no reads passed quality-based trimming"))
 	} else {
-		log.Printf("\tnumber of reads sketched: %d\n", theBoss.receivedReadCount)
+		if proc.info.HasPrefilter() && proc.info.Sketch.MinSharedKmers > 0 {
+			log.Printf("\tnumber of reads skipped by the k-mer prefilter: %d\n", theBoss.prefilteredCount)
+		}
+		log.Printf("\tnumber of reads sketched: %d\n", theBoss.receivedReadCount-theBoss.prefilteredCount)
+		if proc.info.coarse != nil {
+			log.Printf("\tnumber of reads without candidate graphs in the coarse index: %d\n", theBoss.coarseMissCount)
+		}
 	}
 	proc.readStats[0] = theBoss.receivedReadCount
 	proc.readStats[1] = theBoss.mappedCount
@@ -333,6 +357,14 @@ func (proc *ReadMapper) Run() {
 	log.Printf("\ttotal number of mapped reads: %d\n", theBoss.mappedCount)
 	log.Printf("\t\tuniquely mapped: %d\n", (theBoss.mappedCount - theBoss.multimappedCount))
 	log.Printf("\t\tmultimapped: %d\n", theBoss.multimappedCount)
+	if theBoss.sharedCount != 0 {
+		log.Printf("\t\thit windows shared by several graphs (%v): %d\n", proc.info.Sketch.GetSharedPolicy(), theBoss.sharedCount)
+	}
+	if proc.info.Stranded {
+		log.Printf("\t\tforward strand: %d\n", theBoss.strandCounts[0])
+		log.Printf("\t\treverse strand: %d\n", theBoss.strandCounts[1])
+		log.Printf("\t\tboth strands: %d\n", theBoss.strandCounts[2])
+	}
 
 	// send on the graphs for pruning now that the mapping is done
 	for _, g := range proc.info.Store {
@@ -402,7 +434,10 @@ func (proc *GraphPruner) Run() {
 	for g := range graphChan {
 		g.GrootVersion = proc.info.Version
 		keptGraphs[g.GraphID] = g
-		log.Printf("\tgraph %d has %d remaining paths after weighting and pruning", g.GraphID, len(g.Paths))
+		log.Printf("\tgraph %v has %d remaining paths after weighting and pruning", proc.info.GraphName(g.GraphID), len(g.Paths))
+		if proc.info.Stranded {
+			log.Printf("\tgraph %v k-mers from forward strand reads: %d, reverse strand reads: %d", proc.info.GraphName(g.GraphID), g.StrandKmers[0], g.StrandKmers[1])
+		}
 		for _, path := range g.Paths {
 			log.Printf("\t- [%v]", string(path))
 			keptPaths = append(keptPaths, string(path))
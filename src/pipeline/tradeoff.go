@@ -0,0 +1,156 @@
+package pipeline
+
+/*
+ this part of the pipeline measures the trade-off between index size and recall for different window selections, by re-indexing the graphs in memory and validating each index with simulated reads
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+)
+
+// TradeoffFile is the report of the window selection trade-off, which is written to the index directory
+const TradeoffFile = "window-tradeoff.tsv"
+
+// WindowTradeoff records the size and recall of an index built with one window selection
+type WindowTradeoff struct {
+	Stride         int
+	Minimizers     bool
+	Windows        int // the number of windows in the index
+	Recall         float64
+	WrongGraphRate float64
+}
+
+// MeasureWindowTradeoff is a function to index the graphs in info.Store with each stride, using a fixed stride and then minimizers, and validate each index with reads simulated using info.Validate
+// every window (a stride of 1) is always measured first, so that the smaller indexes can be compared to it
+func MeasureWindowTradeoff(info *Info, strides []int) ([]*WindowTradeoff, error) {
+	if len(info.Store) == 0 {
+		return nil, fmt.Errorf("no graphs to index")
+	}
+	settings := []*WindowTradeoff{{Stride: 1}}
+	sort.Ints(strides)
+	for _, stride := range strides {
+		if stride < 1 {
+			return nil, fmt.Errorf("window stride must be at least 1")
+		}
+		if stride > 1 && stride != settings[len(settings)-1].Stride {
+			settings = append(settings, &WindowTradeoff{Stride: stride}, &WindowTradeoff{Stride: stride, Minimizers: true})
+		}
+	}
+
+	// window the graphs in order, so that the indexes are reproducible
+	graphIDs := make([]uint32, 0, len(info.Store))
+	for graphID := range info.Store {
+		graphIDs = append(graphIDs, graphID)
+	}
+	sort.Slice(graphIDs, func(i, j int) bool { return graphIDs[i] < graphIDs[j] })
+
+	// the trials window copies of the graphs, as windowing records the paths on each graph
+	graphs := graph.NewStoreFromRecords(info.Store.Records())
+	for _, setting := range settings {
+
+		// index the graphs with this window selection
+		trial := info.trialCopy()
+		trial.WindowStride = setting.Stride
+		trial.MinimizerWindows = setting.Minimizers
+		graphChan := make(chan *graph.GrootGraph)
+		go func() {
+			for _, graphID := range graphIDs {
+				graphChan <- graphs[graphID]
+			}
+			close(graphChan)
+		}()
+		graphSketcher := NewGraphSketcher(trial)
+		graphSketcher.input = graphChan
+		sketchIndexer := NewSketchIndexer(trial)
+		sketchIndexer.Connect(graphSketcher)
+		trialPipeline := NewPipeline()
+		trialPipeline.AddProcesses(graphSketcher, sketchIndexer)
+		trialPipeline.Run()
+
+		// validate the index
+		if err := trial.db.Populate(); err != nil {
+			return nil, err
+		}
+		report, err := ValidateIndex(trial, []*graph.ContainmentIndex{trial.db})
+		if err != nil {
+			return nil, err
+		}
+		setting.Windows = len(trial.db.Windows)
+		setting.Recall = report.Recall()
+		setting.WrongGraphRate = report.WrongGraphRate()
+	}
+	return settings, nil
+}
+
+// trialCopy is a method to copy the runtime info for a trial index, without any graphs or index
+// the maps and settings are copied, so that indexing the trial doesn't change the runtime info it was copied from
+func (Info *Info) trialCopy() *Info {
+	trial := *Info
+	trial.Store = make(graph.Store)
+	trial.shards = nil
+	trial.mappings = nil
+	trial.AttachDB(nil)
+	trial.Sources = make(map[uint32]string, len(Info.Sources))
+	for graphID, name := range Info.Sources {
+		trial.Sources[graphID] = name
+	}
+	trial.Inputs = make(map[uint32]InputFile, len(Info.Inputs))
+	for graphID, input := range Info.Inputs {
+		trial.Inputs[graphID] = input
+	}
+	trial.WindowSizes = append([]int(nil), Info.WindowSizes...)
+	if Info.BackgroundRegions != nil {
+		trial.BackgroundRegions = make(map[uint32][]graph.BackgroundRegion, len(Info.BackgroundRegions))
+		for graphID, regions := range Info.BackgroundRegions {
+			trial.BackgroundRegions[graphID] = append([]graph.BackgroundRegion(nil), regions...)
+		}
+	}
+	if Info.coarseSketches != nil {
+		trial.coarseSketches = make(map[uint32][]uint64, len(Info.coarseSketches))
+		for graphID, sketch := range Info.coarseSketches {
+			trial.coarseSketches[graphID] = sketch
+		}
+	}
+	if Info.Database != nil {
+		database := *Info.Database
+		trial.Database = &database
+	}
+	if Info.Background != nil {
+		background := *Info.Background
+		trial.Background = &background
+	}
+	return &trial
+}
+
+// WriteWindowTradeoff is a function to write a tab separated report of the window selection trade-off
+// the size of each index is also given as a fraction of the index that holds every window (the first in the list)
+func WriteWindowTradeoff(fileName string, tradeoffs []*WindowTradeoff) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	w := bufio.NewWriter(fh)
+	fmt.Fprintln(w, "#selection\tstride\twindows\tindexFraction\trecall\twrongGraphRate")
+	for _, tradeoff := range tradeoffs {
+		fmt.Fprintf(w, "%v\t%d\t%d\t%.3f\t%.3f\t%.3f\n", tradeoff.Selection(), tradeoff.Stride, tradeoff.Windows, float64(tradeoff.Windows)/float64(tradeoffs[0].Windows), tradeoff.Recall, tradeoff.WrongGraphRate)
+	}
+	return w.Flush()
+}
+
+// Selection is a method to name the window selection used for an index
+func (tradeoff *WindowTradeoff) Selection() string {
+	switch {
+	case tradeoff.Minimizers:
+		return "minimizer"
+	case tradeoff.Stride > 1:
+		return "stride"
+	default:
+		return "all"
+	}
+}
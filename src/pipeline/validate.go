@@ -0,0 +1,251 @@
+package pipeline
+
+/*
+ this part of the pipeline validates an index, by simulating reads from every path in the graphs and querying them against the LSH Ensemble
+ if a coarse index is in use, the reads are queried in two stages, in the same way as the sketch command
+*/
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/will-rowe/baby-groot/src/graph"
+	"github.com/will-rowe/baby-groot/src/lshforest"
+	"github.com/will-rowe/baby-groot/src/minhash"
+	"github.com/will-rowe/baby-groot/src/seqio"
+)
+
+// the seed used to simulate reads, so that validation reports are reproducible
+const validationSeed = 42
+
+// ValidateCmd stores the runtime info for validating an index
+type ValidateCmd struct {
+	ReadLength           int     // the length of the simulated reads
+	ErrorRate            float64 // the per-base substitution rate of the simulated reads
+	ReadsPerPath         int     // the number of reads to simulate from each path
+	ContainmentThreshold float64 // the containment threshold used to query the simulated reads
+}
+
+// GraphValidation records how well the simulated reads from one graph were recovered by the index
+type GraphValidation struct {
+	GraphID                uint32
+	Name                   string
+	Reads                  int        // the number of reads simulated from the paths of the graph
+	Recalled               int        // the number of reads that hit at least one window from the graph
+	WrongGraphReads        int        // the number of reads that hit at least one window from another graph
+	Hits                   int        // the total number of windows hit by the reads
+	WrongGraphHits         int        // the number of windows hit by the reads that belong to another graph
+	IndistinguishablePaths [][]string // groups of paths in the graph that share all of their windows
+}
+
+// Recall is a method to return the proportion of simulated reads that hit their own graph
+func (gv *GraphValidation) Recall() float64 {
+	if gv.Reads == 0 {
+		return 0
+	}
+	return float64(gv.Recalled) / float64(gv.Reads)
+}
+
+// WrongGraphRate is a method to return the proportion of window hits that belong to another graph
+func (gv *GraphValidation) WrongGraphRate() float64 {
+	if gv.Hits == 0 {
+		return 0
+	}
+	return float64(gv.WrongGraphHits) / float64(gv.Hits)
+}
+
+// ValidationReport records the validation of every graph in an index
+type ValidationReport struct {
+	Graphs []*GraphValidation // sorted by graphID
+}
+
+// Recall is a method to return the proportion of all the simulated reads that hit their own graph
+func (report *ValidationReport) Recall() float64 {
+	reads, recalled := 0, 0
+	for _, gv := range report.Graphs {
+		reads += gv.Reads
+		recalled += gv.Recalled
+	}
+	if reads == 0 {
+		return 0
+	}
+	return float64(recalled) / float64(reads)
+}
+
+// WrongGraphRate is a method to return the proportion of all the window hits that belong to another graph
+func (report *ValidationReport) WrongGraphRate() float64 {
+	hits, wrongHits := 0, 0
+	for _, gv := range report.Graphs {
+		hits += gv.Hits
+		wrongHits += gv.WrongGraphHits
+	}
+	if hits == 0 {
+		return 0
+	}
+	return float64(wrongHits) / float64(hits)
+}
+
+// ValidateIndex is a function to simulate reads from every path in the graphs, query them against the index shards and report the recall for each graph
+// the reads are simulated using the settings in info.Validate
+func ValidateIndex(info *Info, dbs []*graph.ContainmentIndex) (*ValidationReport, error) {
+	if len(dbs) == 0 {
+		return nil, fmt.Errorf("no LSH Ensemble indexes to validate")
+	}
+	if info.Validate.ReadLength < info.KmerSize {
+		return nil, fmt.Errorf("simulated read length (%d) must be at least the k-mer size (%d)", info.Validate.ReadLength, info.KmerSize)
+	}
+	if info.Validate.ErrorRate < 0 || info.Validate.ErrorRate >= 1 {
+		return nil, fmt.Errorf("simulated read error rate must be between 0 and 1")
+	}
+	if info.Validate.ReadsPerPath < 1 {
+		return nil, fmt.Errorf("must simulate at least one read per path")
+	}
+
+	// the windows for each graph are needed to find paths that can't be distinguished
+	graphWindows := make(map[uint32][]*lshforest.Key)
+	for _, db := range dbs {
+		for _, window := range db.Windows {
+			graphWindows[window.GraphID] = append(graphWindows[window.GraphID], window)
+		}
+	}
+
+	// validate the graphs with a pool of workers, so that only NumProc graphs are validated at once
+	graphIDs := make([]uint32, 0, len(info.Store))
+	for graphID := range info.Store {
+		graphIDs = append(graphIDs, graphID)
+	}
+	sort.Slice(graphIDs, func(i, j int) bool { return graphIDs[i] < graphIDs[j] })
+	report := &ValidationReport{Graphs: make([]*GraphValidation, len(graphIDs))}
+	errs := make([]error, len(graphIDs))
+	jobs := make(chan int)
+	go func() {
+		for i := range graphIDs {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	numWorkers := info.NumProc
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				graphID := graphIDs[i]
+				report.Graphs[i], errs[i] = validateGraph(info, dbs, info.Store[graphID], graphWindows[graphID])
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// validateGraph is a function to simulate reads from each path in a graph and record how many are recovered by the index
+func validateGraph(info *Info, dbs []*graph.ContainmentIndex, g *graph.GrootGraph, windows []*lshforest.Key) (*GraphValidation, error) {
+	gv := &GraphValidation{
+		GraphID: g.GraphID,
+		Name:    info.GraphName(g.GraphID),
+	}
+	pathSeqs, err := g.Graph2Seqs()
+	if err != nil {
+		return nil, err
+	}
+
+	// simulate the reads from the paths in order, so that the report is reproducible
+	pathIDs := make([]uint32, 0, len(pathSeqs))
+	for pathID := range pathSeqs {
+		pathIDs = append(pathIDs, pathID)
+	}
+	sort.Slice(pathIDs, func(i, j int) bool { return pathIDs[i] < pathIDs[j] })
+	r := rand.New(rand.NewSource(validationSeed + int64(g.GraphID)))
+	for _, pathID := range pathIDs {
+		if len(pathSeqs[pathID]) < info.KmerSize {
+			continue
+		}
+		for _, read := range seqio.SimulateReads(pathSeqs[pathID], info.Validate.ReadLength, info.Validate.ReadsPerPath, info.Validate.ErrorRate, r) {
+			// mask the read in the same way as the FastqChecker, skipping reads that are entirely low-complexity
+			if info.DustLevel > 0 {
+				read, _ = seqio.DustMask(read, info.DustLevel)
+				if seqio.UnmaskedKmers(read, info.KmerSize) == 0 {
+					continue
+				}
+			}
+			var readSketch []uint64
+			if info.Stranded {
+				readSketch, err = minhash.GetStrandedReadSketch(read, uint(info.KmerSize), uint(info.SketchSize), info.DustLevel > 0)
+			} else {
+				readSketch, err = minhash.GetReadSketch(read, uint(info.KmerSize), uint(info.SketchSize), false, info.DustLevel > 0)
+			}
+			if err != nil {
+				return nil, err
+			}
+			hits, _, err := info.queryRead(dbs, read, readSketch, len(read)+info.KmerSize-1, info.Validate.ContainmentThreshold)
+			if err != nil {
+				return nil, err
+			}
+			gv.Reads++
+			gv.Hits += len(hits)
+			recalled, wrongGraph := false, false
+			for _, hit := range hits {
+				if hit.GraphID == g.GraphID {
+					recalled = true
+				} else {
+					gv.WrongGraphHits++
+					wrongGraph = true
+				}
+			}
+			if recalled {
+				gv.Recalled++
+			}
+			if wrongGraph {
+				gv.WrongGraphReads++
+			}
+		}
+	}
+	gv.IndistinguishablePaths = findIndistinguishablePaths(g, windows)
+	return gv, nil
+}
+
+// findIndistinguishablePaths is a function to group the paths in a graph that have exactly the same set of windows in the index
+// reads from these paths will hit the same windows, so they can't be told apart at the window and sketch size used for the index
+func findIndistinguishablePaths(g *graph.GrootGraph, windows []*lshforest.Key) [][]string {
+	pathWindows := make(map[uint32][]string)
+	for i, window := range windows {
+		for _, pathID := range window.Ref {
+			pathWindows[pathID] = append(pathWindows[pathID], strconv.Itoa(i))
+		}
+	}
+
+	// group the paths by their windows
+	groups := make(map[string][]uint32)
+	for pathID, windowIDs := range pathWindows {
+		fingerprint := strings.Join(windowIDs, ",")
+		groups[fingerprint] = append(groups[fingerprint], pathID)
+	}
+	indistinguishable := [][]string{}
+	for _, pathIDs := range groups {
+		if len(pathIDs) < 2 {
+			continue
+		}
+		sort.Slice(pathIDs, func(i, j int) bool { return pathIDs[i] < pathIDs[j] })
+		names := make([]string, len(pathIDs))
+		for i, pathID := range pathIDs {
+			names[i] = string(g.Paths[pathID])
+		}
+		indistinguishable = append(indistinguishable, names)
+	}
+	sort.Slice(indistinguishable, func(i, j int) bool { return indistinguishable[i][0] < indistinguishable[j][0] })
+	return indistinguishable
+}
@@ -0,0 +1,115 @@
+package seqio
+
+// DustWindow is the maximum length of the low-complexity intervals found by the DUST filter
+const DustWindow = 64
+
+// DefaultDustLevel is the recommended DUST score threshold (x10) if masking is turned on, which is the level used by dustmasker and sdust
+const DefaultDustLevel = 20
+
+// dustCodes is used to convert "ACGT" (and "acgt") to 0123, with every other base set to 4
+var dustCodes = func() [256]uint8 {
+	codes := [256]uint8{}
+	for i := range codes {
+		codes[i] = 4
+	}
+	for i, base := range []byte("ACGT") {
+		codes[base] = uint8(i)
+		codes[base+32] = uint8(i)
+	}
+	return codes
+}()
+
+// DustMask is a function to mask the low-complexity regions of a sequence with Ns, returning the masked copy and the number of masked bases
+/* the algorithm is based on symmetric DUST (Morgulis et al. 2006):
+-1. an interval is scored using the counts (c) of the l triplets it contains: sum(c*(c-1)/2) / (l-1)
+-2. for each triplet, find the highest scoring interval (up to DustWindow bases) that ends with it, and the highest scoring interval that starts with it
+-3. mask each interval that is the highest scoring interval for both its first and last triplet, if its score is greater than level/10
+*/
+func DustMask(seq []byte, level int) ([]byte, int) {
+	masked := make([]byte, len(seq))
+	copy(masked, seq)
+	if level <= 0 || len(seq) < 3 {
+		return masked, 0
+	}
+
+	// encode the triplets, marking those with ambiguous bases
+	triplets := make([]int, len(seq)-2)
+	for i := range triplets {
+		a, b, c := dustCodes[seq[i]], dustCodes[seq[i+1]], dustCodes[seq[i+2]]
+		if a > 3 || b > 3 || c > 3 {
+			triplets[i] = -1
+			continue
+		}
+		triplets[i] = int(a)<<4 | int(b)<<2 | int(c)
+	}
+
+	// find the highest scoring intervals and mask those that are the best for both their ends
+	bestStarts, scores := bestDustIntervals(triplets, -1)
+	bestEnds, _ := bestDustIntervals(triplets, 1)
+	numMasked, maskedTo := 0, 0
+	for end, start := range bestStarts {
+		if start < 0 || bestEnds[start] != end || scores[end]*10 <= float64(level) {
+			continue
+		}
+		if maskedTo < start {
+			maskedTo = start
+		}
+		for ; maskedTo < end+3; maskedTo++ {
+			masked[maskedTo] = 'N'
+			numMasked++
+		}
+	}
+	return masked, numMasked
+}
+
+// bestDustIntervals is a function to find the highest scoring interval for each triplet, extending the interval backwards (step -1) or forwards (step 1) from the triplet
+// it returns the other end of each interval (-1 if there isn't one) and the interval scores, preferring the longer interval if scores are tied
+func bestDustIntervals(triplets []int, step int) ([]int, []float64) {
+	bestEnds := make([]int, len(triplets))
+	bestScores := make([]float64, len(triplets))
+	counts := [64]int{}
+	for i, first := range triplets {
+		bestEnds[i] = -1
+		if first < 0 {
+			continue
+		}
+		sum, l := 0, 0
+		j := i
+		for ; j >= 0 && j < len(triplets) && (j-i)*step < DustWindow-2; j += step {
+			if triplets[j] < 0 {
+				break
+			}
+			sum += counts[triplets[j]]
+			counts[triplets[j]]++
+			l++
+			if l < 2 {
+				continue
+			}
+			if score := float64(sum) / float64(l-1); score >= bestScores[i] {
+				bestScores[i], bestEnds[i] = score, j
+			}
+		}
+
+		// reset the counts for the next interval
+		for k := i; k != j; k += step {
+			counts[triplets[k]] = 0
+		}
+	}
+	return bestEnds, bestScores
+}
+
+// UnmaskedKmers is a function to count the k-mers in a sequence that don't contain an N (or other ambiguous base)
+func UnmaskedKmers(seq []byte, kmerSize int) int {
+	count, run := 0, 0
+	for _, base := range seq {
+		if dustCodes[base] > 3 {
+			run = 0
+			continue
+		}
+		run++
+		if run >= kmerSize {
+			count++
+		}
+	}
+	return count
+}
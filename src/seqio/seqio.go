@@ -4,7 +4,13 @@
 package seqio
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 	"unicode"
 
 	"github.com/will-rowe/baby-groot/src/minhash"
@@ -67,6 +73,26 @@ func (Sequence *Sequence) RunMinHash(kmerSize, sketchSize int, kmv bool, bf *min
 	return sketch, err
 }
 
+// RunStrandedMinHash is a method to create a KHF minhash sketch for the forward k-mers of the sequence
+func (Sequence *Sequence) RunStrandedMinHash(kmerSize, sketchSize int) ([]uint64, error) {
+	mh := minhash.NewStrandedKHFsketch(uint(kmerSize), uint(sketchSize))
+	err := mh.AddSequence(Sequence.Seq)
+	return mh.GetSketch(), err
+}
+
+// ReverseComplement is a function to return the reverse complement of a sequence, leaving the original sequence unchanged
+func ReverseComplement(seq []byte) []byte {
+	rc := make([]byte, len(seq))
+	for i, base := range seq {
+		complement := byte('N')
+		if int(base) < len(complementBases) && complementBases[base] != 0 {
+			complement = complementBases[base]
+		}
+		rc[len(seq)-1-i] = complement
+	}
+	return rc
+}
+
 // BaseCheck is a method to check for ACTGN bases and also to convert bases to upper case
 // TODO: improve the efficiency of this...
 func (Sequence *Sequence) BaseCheck() error {
@@ -160,3 +186,54 @@ func NewFASTQread(l1 []byte, l2 []byte, l3 []byte, l4 []byte) (*FASTQread, error
 		Qual:     l4,
 	}, nil
 }
+
+// ReadFASTA is a function to read all the sequences from a FASTA file (which can be gzipped)
+// the sequence IDs are the first word of each header and the bases are checked and converted to upper case
+func ReadFASTA(fileName string) ([]*Sequence, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var reader io.Reader = fh
+	if strings.HasSuffix(fileName, ".gz") {
+		gz, err := gzip.NewReader(fh)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	seqs := []*Sequence{}
+	var current *Sequence
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '>' {
+			fields := bytes.Fields(line[1:])
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("FASTA file contains an empty sequence ID: %v", fileName)
+			}
+			current = &Sequence{ID: append([]byte(nil), fields[0]...)}
+			seqs = append(seqs, current)
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("FASTA file does not begin with a sequence ID: %v", fileName)
+		}
+		current.Seq = append(current.Seq, line...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, seq := range seqs {
+		if err := seq.BaseCheck(); err != nil {
+			return nil, err
+		}
+	}
+	return seqs, nil
+}
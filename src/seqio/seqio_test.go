@@ -1,6 +1,8 @@
 package seqio
 
 import (
+	"bytes"
+	"math/rand"
 	"testing"
 )
 
@@ -60,6 +62,9 @@ func TestSeqMethods(t *testing.T) {
 	if ByteSliceCheck(read.Seq, expectedTrimmedSeq) == false {
 		t.Errorf("QualTrim method failed")
 	}
+	if ByteSliceCheck(ReverseComplement(read.Seq), expectedRevComp) == false {
+		t.Errorf("ReverseComplement function failed")
+	}
 	read.RevComplement()
 	if ByteSliceCheck(read.Seq, expectedRevComp) == false {
 		t.Errorf("RevComplement method failed")
@@ -84,3 +89,64 @@ func TestMinHash(t *testing.T) {
 		t.Log(sketch)
 	}
 }
+
+func TestSimulateReads(t *testing.T) {
+	seq := []byte("ACTGACTGACTGACTGACTGACTGACTGACTG")
+	r := rand.New(rand.NewSource(1))
+	reads := SimulateReads(seq, 10, 5, 0.0, r)
+	if len(reads) != 5 {
+		t.Fatal("wrong number of reads simulated")
+	}
+	for _, read := range reads {
+		if len(read) != 10 || !bytes.Contains(seq, read) {
+			t.Fatalf("error-free read was not taken from the sequence: %v", string(read))
+		}
+	}
+	// reads are truncated to the sequence and every base is changed with an error rate of 1
+	reads = SimulateReads(seq, 100, 1, 1.0, r)
+	if len(reads[0]) != len(seq) {
+		t.Fatal("read was not truncated to the sequence length")
+	}
+	for i := range seq {
+		if reads[0][i] == seq[i] {
+			t.Fatal("substitution error did not change the base")
+		}
+	}
+}
+
+func TestDustMask(t *testing.T) {
+	// a random sequence should not be masked
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 200)
+	for i := range random {
+		random[i] = "ACGT"[r.Intn(4)]
+	}
+	if masked, numMasked := DustMask(random, DefaultDustLevel); numMasked != 0 || !bytes.Equal(masked, random) {
+		t.Fatalf("random sequence was masked (%d bases)", numMasked)
+	}
+
+	// a repeat in the middle of the sequence should be masked, but not its flanks (allowing for flanking bases that happen to extend the repeat)
+	repeat := bytes.Repeat([]byte("CA"), 20)
+	seq := append(append(append([]byte{}, random[:50]...), repeat...), random[50:100]...)
+	masked, numMasked := DustMask(seq, DefaultDustLevel)
+	if numMasked < len(repeat)-2 || numMasked > len(repeat)+4 {
+		t.Fatalf("repeat was not masked correctly (%d bases masked from %d)", numMasked, len(repeat))
+	}
+	if !bytes.Equal(masked[:46], seq[:46]) || !bytes.Equal(masked[94:], seq[94:]) {
+		t.Fatalf("flanks of the repeat were masked: %v", string(masked))
+	}
+	if _, numMasked := DustMask(seq, 0); numMasked != 0 {
+		t.Fatal("sequence was masked with masking turned off")
+	}
+
+	// k-mers that overlap the masked bases should not be counted
+	if UnmaskedKmers(masked, 7) != UnmaskedKmers(seq, 7)-(numMasked+6) {
+		t.Fatalf("wrong number of unmasked k-mers: %d", UnmaskedKmers(masked, 7))
+	}
+	if UnmaskedKmers(bytes.Repeat([]byte("A"), 50), 7) != 44 {
+		t.Fatal("wrong number of k-mers in an unmasked sequence")
+	}
+	if masked, _ := DustMask(bytes.Repeat([]byte("A"), 50), DefaultDustLevel); UnmaskedKmers(masked, 7) != 0 {
+		t.Fatal("homopolymer was not masked")
+	}
+}
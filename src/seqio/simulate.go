@@ -0,0 +1,34 @@
+package seqio
+
+import "math/rand"
+
+// the bases used to introduce substitution errors
+var simBases = []byte("ACGT")
+
+// SimulateReads is a function to simulate reads from random positions in a sequence, with substitution errors introduced at the given rate
+// if the sequence is shorter than the read length, the whole sequence is used for each read
+func SimulateReads(seq []byte, readLength, numReads int, errorRate float64, r *rand.Rand) [][]byte {
+	if readLength > len(seq) {
+		readLength = len(seq)
+	}
+	reads := make([][]byte, numReads)
+	for i := range reads {
+		start := r.Intn(len(seq) - readLength + 1)
+		read := make([]byte, readLength)
+		copy(read, seq[start:start+readLength])
+		for j := range read {
+			if r.Float64() >= errorRate {
+				continue
+			}
+
+			// substitute a different base
+			substitute := simBases[r.Intn(len(simBases))]
+			for substitute == read[j] {
+				substitute = simBases[r.Intn(len(simBases))]
+			}
+			read[j] = substitute
+		}
+		reads[i] = read
+	}
+	return reads
+}